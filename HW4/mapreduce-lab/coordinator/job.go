@@ -0,0 +1,419 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"mapreduce-lab/mr"
+	"mapreduce-lab/storage"
+)
+
+type state string
+
+const (
+	statePending    state = "pending"
+	stateRunning    state = "running"
+	stateSucceeded  state = "succeeded"
+	stateFailed     state = "failed"
+	stateSuperseded state = "superseded" // an attempt that lost to another one of its task
+)
+
+// Attempt is one execution of a task on one worker. A task may have
+// several: retries after a failure and speculative backups.
+type Attempt struct {
+	N          int        `json:"n"`
+	Worker     string     `json:"worker"`
+	Backup     bool       `json:"backup,omitempty"`
+	State      state      `json:"state"`
+	Output     []string   `json:"output,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	Started    time.Time  `json:"started"`
+	Finished   *time.Time `json:"finished,omitempty"`
+	DurationMS float64    `json:"duration_ms,omitempty"`
+}
+
+// Task is one call to a splitter, mapper or reducer. Its Output and Worker
+// are those of the attempt that was committed.
+type Task struct {
+	ID         int        `json:"id"`
+	Phase      string     `json:"phase"`
+	Inputs     []string   `json:"inputs"`
+	State      state      `json:"state"`
+	Worker     string     `json:"worker,omitempty"`
+	Output     []string   `json:"output,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	Started    *time.Time `json:"started,omitempty"`
+	Finished   *time.Time `json:"finished,omitempty"`
+	DurationMS float64    `json:"duration_ms,omitempty"`
+	Attempts   []Attempt  `json:"attempts"`
+}
+
+// Phase is the progress of split, map or reduce.
+type Phase struct {
+	Name       string     `json:"name"`
+	State      state      `json:"state"`
+	Tasks      int        `json:"tasks"`
+	Done       int        `json:"done"`
+	Failed     int        `json:"failed"`
+	Retries    int        `json:"retries"`
+	Backups    int        `json:"backups"`
+	Started    *time.Time `json:"started,omitempty"`
+	Finished   *time.Time `json:"finished,omitempty"`
+	DurationMS float64    `json:"duration_ms,omitempty"`
+}
+
+// Job is one run of a map/reduce job through the pipeline. Its fields are guarded by
+// mu; view copies them out for encoding.
+type Job struct {
+	mu sync.Mutex
+
+	ID           string
+	JobName      string
+	Args         mr.Args
+	Input        string
+	Chunks       int
+	ChunkBytes   int64
+	Reducers     int
+	Merge        bool
+	Format       string
+	Compression  string
+	Order        string
+	Top          int
+	OutputFormat string
+	State        state
+	Outputs      []string
+	Output       string
+	Error        string
+	Created      time.Time
+	Finished     *time.Time
+	DurationMS   float64
+	Phases       []*Phase
+	Tasks        []*Task
+}
+
+// jobView is a Job copied out from under its lock.
+type jobView struct {
+	ID           string     `json:"id"`
+	JobName      string     `json:"job"`
+	Args         mr.Args    `json:"args,omitempty"`
+	Input        string     `json:"input"`
+	Chunks       int        `json:"chunks"`
+	ChunkBytes   int64      `json:"chunk_bytes,omitempty"`
+	Reducers     int        `json:"reducers"`
+	Merge        bool       `json:"merge"`
+	Format       string     `json:"format"`
+	Compression  string     `json:"compression"`
+	Order        string     `json:"order,omitempty"`
+	Top          int        `json:"top,omitempty"`
+	OutputFormat string     `json:"output_format"`
+	State        state      `json:"state"`
+	Outputs      []string   `json:"outputs,omitempty"` // one sorted file per reducer
+	Output       string     `json:"output,omitempty"`  // the single final file, if there is one
+	Error        string     `json:"error,omitempty"`
+	Created      time.Time  `json:"created"`
+	Finished     *time.Time `json:"finished,omitempty"`
+	DurationMS   float64    `json:"duration_ms,omitempty"`
+	Phases       []Phase    `json:"phases"`
+	Tasks        []Task     `json:"tasks"`
+}
+
+func newJob(id string, req JobRequest) *Job {
+	j := &Job{
+		ID: id, JobName: req.Job, Args: req.Args, Input: req.Input, Chunks: req.Chunks, ChunkBytes: req.ChunkBytes, Reducers: req.Reducers, Merge: req.Merge,
+		Format: req.Format, Compression: req.Compression, Order: req.Order, Top: req.Top, OutputFormat: req.OutputFormat,
+		State: statePending, Created: time.Now().UTC(),
+	}
+	phases := []string{"split", "map", "reduce"}
+	if j.merges() {
+		phases = append(phases, "merge")
+	}
+	for _, name := range phases {
+		j.Phases = append(j.Phases, &Phase{Name: name, State: statePending})
+	}
+	return j
+}
+
+// merges reports whether the job ends with a merge of its reducers'
+// outputs; a single reducer's output needs none.
+func (j *Job) merges() bool { return j.Merge && j.Reducers > 1 }
+
+func (j *Job) view() jobView {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	v := jobView{
+		ID: j.ID, JobName: j.JobName, Args: j.Args, Input: j.Input, Chunks: j.Chunks, ChunkBytes: j.ChunkBytes, Reducers: j.Reducers, Merge: j.Merge,
+		Format: j.Format, Compression: j.Compression, Order: j.Order, Top: j.Top, OutputFormat: j.OutputFormat,
+		State: j.State, Outputs: j.Outputs, Output: j.Output, Error: j.Error,
+		Created: j.Created, Finished: j.Finished, DurationMS: j.DurationMS,
+		Phases: make([]Phase, len(j.Phases)), Tasks: make([]Task, len(j.Tasks)),
+	}
+	for i, p := range j.Phases {
+		v.Phases[i] = *p
+	}
+	for i, t := range j.Tasks {
+		v.Tasks[i] = *t
+		v.Tasks[i].Attempts = slices.Clone(t.Attempts)
+	}
+	return v
+}
+
+func (j *Job) phase(name string) *Phase {
+	for _, p := range j.Phases {
+		if p.Name == name {
+			return p
+		}
+	}
+	panic("unknown phase " + name)
+}
+
+// attemptOutput is where attempt n of t writes: a path of its own under
+// the job's directory next to the input, so attempts never overwrite each
+// other.
+func (j *Job) attemptOutput(t *Task, n int) storage.URL {
+	in, _ := storage.Parse(j.Input) // checked when the job was submitted
+	return in.At(fmt.Sprintf("mr/jobs/%s/%s/task%03d-attempt%d", j.ID, t.Phase, t.ID, n))
+}
+
+func now() *time.Time {
+	t := time.Now().UTC()
+	return &t
+}
+
+func millis(from, to *time.Time) float64 {
+	return float64(to.Sub(*from).Microseconds()) / 1000
+}
+
+// coordinator runs jobs against pools of splitter, mapper and reducer
+// workers.
+type coordinator struct {
+	client   *http.Client
+	cfg      schedConfig
+	splitter *pool
+	mappers  *pool
+	reducers *pool
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+	done []string // finished job IDs, oldest first
+	seq  int
+}
+
+func newCoordinator(client *http.Client, cfg schedConfig, splitters, mappers, reducers []string) *coordinator {
+	if cfg.TaskTimeout <= 0 {
+		cfg.TaskTimeout = 60 * time.Second
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 3
+	}
+	if cfg.KeepJobs <= 0 {
+		cfg.KeepJobs = 100
+	}
+	return &coordinator{
+		client:   client,
+		cfg:      cfg,
+		splitter: newPool("splitter", splitters),
+		mappers:  newPool("mapper", mappers),
+		reducers: newPool("reducer", reducers),
+		jobs:     make(map[string]*Job),
+	}
+}
+
+func (c *coordinator) pools() []*pool { return []*pool{c.splitter, c.mappers, c.reducers} }
+
+func (c *coordinator) submit(req JobRequest) *Job {
+	c.mu.Lock()
+	c.seq++
+	j := newJob(fmt.Sprintf("job-%d-%s", c.seq, time.Now().UTC().Format("20060102T150405Z")), req)
+	c.jobs[j.ID] = j
+	c.mu.Unlock()
+
+	go c.run(context.Background(), j)
+	return j
+}
+
+func (c *coordinator) job(id string) (*Job, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	j, ok := c.jobs[id]
+	return j, ok
+}
+
+// retire records that job id has finished, and forgets the oldest
+// finished jobs beyond KeepJobs.
+func (c *coordinator) retire(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.done = append(c.done, id)
+	for len(c.done) > c.cfg.KeepJobs {
+		delete(c.jobs, c.done[0])
+		c.done = c.done[1:]
+	}
+}
+
+// run drives j through split, map, reduce and the optional merge. Each
+// phase starts once the one before has finished; within map and reduce,
+// tasks run in parallel across the worker pool.
+func (c *coordinator) run(ctx context.Context, j *Job) {
+	defer c.retire(j.ID)
+	j.mu.Lock()
+	j.State = stateRunning
+	j.mu.Unlock()
+
+	outs, final, err := c.runJob(ctx, j)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Finished = now()
+	j.DurationMS = millis(&j.Created, j.Finished)
+	if err != nil {
+		j.State, j.Error = stateFailed, err.Error()
+		log.Printf("job %s failed after %.0fms: %v", j.ID, j.DurationMS, err)
+		return
+	}
+	j.State, j.Outputs, j.Output = stateSucceeded, outs, final
+	log.Printf("job %s ok outputs=%d out=%s dur=%.0fms", j.ID, len(outs), final, j.DurationMS)
+}
+
+// runJob returns the reducers' outputs and, when there is one, the single
+// final file: the merge's output, or the only reducer's.
+func (c *coordinator) runJob(ctx context.Context, j *Job) ([]string, string, error) {
+	split, err := c.runPhase(ctx, j, "split", c.splitter, [][]string{{j.Input}}, c.split)
+	if err != nil {
+		return nil, "", err
+	}
+
+	chunks := split[0]
+	mapInputs := make([][]string, len(chunks))
+	for i, chunk := range chunks {
+		mapInputs[i] = []string{chunk}
+	}
+	maps, err := c.runPhase(ctx, j, "map", c.mappers, mapInputs, c.mapChunk)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Shuffle: reducer p reads partition p of every map output.
+	reduceInputs := make([][]string, j.Reducers)
+	for _, parts := range maps {
+		for p, part := range parts {
+			reduceInputs[p] = append(reduceInputs[p], part)
+		}
+	}
+	reduced, err := c.runPhase(ctx, j, "reduce", c.reducers, reduceInputs, c.reduce)
+	if err != nil {
+		return nil, "", err
+	}
+	outs := make([]string, len(reduced))
+	for p, out := range reduced {
+		outs[p] = out[0]
+	}
+
+	switch {
+	case len(outs) == 1:
+		return outs, outs[0], nil
+	case !j.merges():
+		return outs, "", nil
+	}
+	merged, err := c.runPhase(ctx, j, "merge", c.reducers, [][]string{outs}, c.merge)
+	if err != nil {
+		return nil, "", err
+	}
+	return outs, merged[0][0], nil
+}
+
+// taskFunc runs one attempt of a task on worker, writing under out, and
+// returns the URLs it wrote.
+type taskFunc func(ctx context.Context, j *Job, worker string, inputs []string, out storage.URL) ([]string, error)
+
+func (c *coordinator) split(ctx context.Context, j *Job, worker string, inputs []string, out storage.URL) ([]string, error) {
+	var resp struct {
+		Chunks []string `json:"chunks"`
+	}
+	q := url.Values{"in": inputs, "out": {out.String()}}
+	if j.ChunkBytes > 0 {
+		q.Set("chunk_bytes", fmt.Sprint(j.ChunkBytes))
+	} else {
+		q.Set("chunks", fmt.Sprint(j.Chunks))
+	}
+	if err := callService(ctx, c.client, worker, "/split", q, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Chunks) == 0 {
+		return nil, fmt.Errorf("splitter returned no chunks")
+	}
+	return resp.Chunks, nil
+}
+
+func (c *coordinator) mapChunk(ctx context.Context, j *Job, worker string, inputs []string, out storage.URL) ([]string, error) {
+	var resp struct {
+		Partitions []string `json:"partitions"`
+	}
+	q := j.query(inputs, out.String())
+	q.Set("partitions", fmt.Sprint(j.Reducers))
+	// Reducers tell the formats apart themselves; only the mapper is told.
+	q.Set("format", j.Format)
+	q.Set("compression", j.Compression)
+	if err := callService(ctx, c.client, worker, "/map", q, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Partitions) != j.Reducers {
+		return nil, fmt.Errorf("mapper returned %d partitions, want %d", len(resp.Partitions), j.Reducers)
+	}
+	return resp.Partitions, nil
+}
+
+// reduce runs one partition's reduce. Unless a merge follows, its output is
+// final, so it is written in the job's order and output format.
+func (c *coordinator) reduce(ctx context.Context, j *Job, worker string, inputs []string, out storage.URL) ([]string, error) {
+	return c.callReducer(ctx, j, worker, "/reduce", inputs, out, !j.merges())
+}
+
+// merge combines the reducers' outputs, which hold disjoint keys, into one.
+func (c *coordinator) merge(ctx context.Context, j *Job, worker string, inputs []string, out storage.URL) ([]string, error) {
+	return c.callReducer(ctx, j, worker, "/merge", inputs, out, true)
+}
+
+// callReducer runs path on a reducer. A reducer writing a final output is
+// given the job's order and output format; one feeding the merge writes the
+// default JSON object the merge reads.
+func (c *coordinator) callReducer(ctx context.Context, j *Job, worker, path string, inputs []string, out storage.URL, final bool) ([]string, error) {
+	var resp struct {
+		Out string `json:"out"`
+	}
+	var o mr.Output
+	if final {
+		o = j.output()
+	}
+	q := j.query(inputs, out.String()+o.Ext())
+	if final {
+		q.Set("order", j.Order)
+		q.Set("top", fmt.Sprint(j.Top))
+		q.Set("output_format", j.OutputFormat)
+	}
+	if err := callService(ctx, c.client, worker, path, q, &resp); err != nil {
+		return nil, err
+	}
+	return []string{resp.Out}, nil
+}
+
+// output is the Output the job's final file is written in. createJob has
+// checked it parses.
+func (j *Job) output() mr.Output {
+	o, _ := mr.ParseOutput(j.Order, j.Top, j.OutputFormat)
+	return o
+}
+
+// query is a mapper or reducer request for j: its job and arguments, the
+// inputs and the output.
+func (j *Job) query(inputs []string, out string) url.Values {
+	q := mr.Query(j.JobName, j.Args)
+	q["in"] = inputs
+	q.Set("out", out)
+	return q
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"mapreduce-lab/mr"
+	"mapreduce-lab/storage"
+)
+
+type JobRequest struct {
+	Job          string  `json:"job"`           // registered job name, default wordcount
+	Args         mr.Args `json:"args"`          // the job's arguments, e.g. {"pattern": "^HAMLET"} for grep
+	Input        string  `json:"input"`         // storage URL of the text to process
+	Chunks       int     `json:"chunks"`        // map tasks, 1..50, default 3
+	ChunkBytes   int64   `json:"chunk_bytes"`   // or split into chunks of about this many bytes
+	Reducers     int     `json:"reducers"`      // reduce tasks (partitions), 1..64, default 1
+	Merge        bool    `json:"merge"`         // merge the reducers' outputs into one file
+	Format       string  `json:"format"`        // intermediate records: binary (default) or json
+	Compression  string  `json:"compression"`   // none (default), snappy or zstd; binary only
+	Order        string  `json:"order"`         // final output order: key, or count (largest first)
+	Top          int     `json:"top"`           // keep only the first top records (by count, with no order)
+	OutputFormat string  `json:"output_format"` // final output: object (default), pairs or tsv
+}
+
+func main() {
+	addr := getenv("ADDR", ":8080")
+	cfg := schedConfig{
+		TaskTimeout:     durationEnv("TASK_TIMEOUT", 60*time.Second),
+		MaxAttempts:     intEnv("MAX_ATTEMPTS", 3),
+		SpeculateFactor: floatEnv("SPECULATE_FACTOR", 2),
+		SpeculateMin:    durationEnv("SPECULATE_MIN", time.Second),
+		KeepJobs:        intEnv("KEEP_JOBS", 100),
+	}
+	heartbeat := durationEnv("HEARTBEAT_INTERVAL", 2*time.Second)
+	misses := intEnv("HEARTBEAT_MISSES", 2)
+
+	c := newCoordinator(&http.Client{}, cfg,
+		splitList(getenv("SPLITTER_URLS", "http://localhost:9101")),
+		splitList(getenv("MAPPER_URLS", "http://localhost:9102")),
+		splitList(getenv("REDUCER_URLS", "http://localhost:9103")))
+	for _, p := range c.pools() {
+		if p.size() == 0 {
+			log.Fatalf("no %s workers configured", p.name)
+		}
+		if heartbeat > 0 {
+			go p.monitor(context.Background(), &http.Client{}, heartbeat, misses)
+		}
+	}
+
+	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		_, _ = w.Write([]byte("ok"))
+	})
+	http.HandleFunc("POST /jobs", c.createJob)
+	http.HandleFunc("GET /jobs", c.listJobs)
+	http.HandleFunc("GET /jobs/{id}", c.getJob)
+	http.HandleFunc("GET /workers", c.listWorkers)
+
+	log.Printf("coordinator listening on %s splitters=%d mappers=%d reducers=%d",
+		addr, c.splitter.size(), c.mappers.size(), c.reducers.size())
+	log.Fatal(http.ListenAndServe(addr, nil))
+}
+
+// maxJobRequest caps the body of POST /jobs.
+const maxJobRequest = 1 << 20
+
+// createJob starts a job and answers 202 right away; poll the Location for
+// progress.
+func (c *coordinator) createJob(w http.ResponseWriter, r *http.Request) {
+	var req JobRequest
+	r.Body = http.MaxBytesReader(w, r.Body, maxJobRequest)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			http.Error(w, "request body over 1 MB", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "invalid JSON: "+err.Error(), 400)
+		return
+	}
+	if _, err := storage.Parse(req.Input); err != nil {
+		http.Error(w, "input: "+err.Error(), 400)
+		return
+	}
+	if req.Job == "" {
+		req.Job = mr.DefaultJob
+	}
+	job, err := mr.Lookup(req.Job, req.Args)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	enc, err := mr.ParseEncoding(req.Format, req.Compression)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	req.Format, req.Compression = enc.Format(), enc.Compression.String()
+	o, err := mr.ParseOutput(req.Order, req.Top, req.OutputFormat)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	req.Order, req.OutputFormat = string(o.Order), string(o.Format)
+	if req.ChunkBytes < 0 || (req.ChunkBytes > 0 && req.Chunks != 0) {
+		http.Error(w, "give chunks or chunk_bytes (> 0), not both", 400)
+		return
+	}
+	if req.Chunks == 0 && req.ChunkBytes == 0 {
+		req.Chunks = 3
+	}
+	if req.ChunkBytes == 0 && (req.Chunks < 1 || req.Chunks > 50) {
+		http.Error(w, "invalid chunks (1..50)", 400)
+		return
+	}
+	if req.Reducers == 0 {
+		req.Reducers = 1
+	}
+	if req.Reducers < 1 || req.Reducers > 64 {
+		http.Error(w, "invalid reducers (1..64)", 400)
+		return
+	}
+	// Without a merge each reducer keeps its own top, not the overall one.
+	if (o.Top > 0 || job.Top > 0) && req.Reducers > 1 && !req.Merge {
+		http.Error(w, "top, or a top-k job, with more than one reducer needs merge", 400)
+		return
+	}
+
+	j := c.submit(req)
+	w.Header().Set("Location", "/jobs/"+j.ID)
+	writeJSON(w, http.StatusAccepted, j.view())
+}
+
+func (c *coordinator) getJob(w http.ResponseWriter, r *http.Request) {
+	j, ok := c.job(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "no such job", 404)
+		return
+	}
+	writeJSON(w, http.StatusOK, j.view())
+}
+
+// listJobs returns every job, newest first, without the task list.
+func (c *coordinator) listJobs(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	views := make([]jobView, 0, len(c.jobs))
+	for _, j := range c.jobs {
+		views = append(views, j.view())
+	}
+	c.mu.Unlock()
+	sort.Slice(views, func(a, b int) bool { return views[a].Created.After(views[b].Created) })
+	for i := range views {
+		views[i].Tasks = nil
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+// listWorkers shows every worker's health and load.
+func (c *coordinator) listWorkers(w http.ResponseWriter, r *http.Request) {
+	var views []workerView
+	for _, p := range c.pools() {
+		views = append(views, p.view()...)
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func getenv(k, def string) string {
+	v := os.Getenv(k)
+	if v == "" {
+		return def
+	}
+	return v
+}
+
+func durationEnv(k string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(getenv(k, def.String()))
+	if err != nil || d < 0 {
+		log.Fatalf("%s must be a non-negative duration, got %q", k, os.Getenv(k))
+	}
+	return d
+}
+
+func intEnv(k string, def int) int {
+	n, err := strconv.Atoi(getenv(k, strconv.Itoa(def)))
+	if err != nil || n < 0 {
+		log.Fatalf("%s must be a non-negative integer, got %q", k, os.Getenv(k))
+	}
+	return n
+}
+
+func floatEnv(k string, def float64) float64 {
+	f, err := strconv.ParseFloat(getenv(k, strconv.FormatFloat(def, 'g', -1, 64)), 64)
+	if err != nil || f < 0 {
+		log.Fatalf("%s must be a non-negative number, got %q", k, os.Getenv(k))
+	}
+	return f
+}
+
+// splitList turns "a, b,c" into [a b c].
+func splitList(s string) []string {
+	var out []string
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			out = append(out, f)
+		}
+	}
+	return out
+}
@@ -0,0 +1,519 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeServices stands in for the splitter, mappers and reducer. By default
+// each map and reduce takes 50ms, so parallelism shows up in the timings;
+// mapFn can take over a mapper's reply.
+type fakeServices struct {
+	splitter, reducer    *httptest.Server
+	mappers              []*httptest.Server
+	down                 []atomic.Bool // mapper fails its heartbeats
+	inFlight, peak       atomic.Int32
+	reducing, reducePeak atomic.Int32
+	reduceInputs         atomic.Int32
+	splitQuery           atomic.Value // url.Values of the last /split
+	mapQuery             atomic.Value // and of the last /map
+
+	mu      sync.Mutex
+	calls   map[string]int        // map calls per chunk
+	reduces map[string][]string   // reduce inputs by output
+	queries map[string]url.Values // whole reduce and merge queries by output
+	mapFn   func(mapper, call int, chunk string, w http.ResponseWriter, r *http.Request) bool
+}
+
+// track counts n in flight and records the peak.
+func track(n, peak *atomic.Int32) func() {
+	v := n.Add(1)
+	for p := peak.Load(); v > p && !peak.CompareAndSwap(p, v); p = peak.Load() {
+	}
+	return func() { n.Add(-1) }
+}
+
+func newFakeServices(t *testing.T, mappers int) *fakeServices {
+	f := &fakeServices{calls: make(map[string]int), reduces: make(map[string][]string), queries: make(map[string]url.Values), down: make([]atomic.Bool, mappers)}
+	f.splitter = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.splitQuery.Store(r.URL.Query())
+		n := 0
+		fmt.Sscan(r.URL.Query().Get("chunks"), &n)
+		if r.URL.Query().Has("chunk_bytes") {
+			n = 2
+		}
+		var chunks []string
+		for i := 0; i < n; i++ {
+			chunks = append(chunks, fmt.Sprintf("%s/chunk%02d.txt", r.URL.Query().Get("out"), i))
+		}
+		json.NewEncoder(w).Encode(map[string]any{"chunks": chunks})
+	}))
+	for i := 0; i < mappers; i++ {
+		i := i
+		f.mappers = append(f.mappers, httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/health" {
+				if f.down[i].Load() {
+					http.Error(w, "down", 503)
+				}
+				return
+			}
+			f.mapQuery.Store(r.URL.Query())
+			chunk := r.URL.Query().Get("in")
+			f.mu.Lock()
+			f.calls[chunk]++
+			call, fn := f.calls[chunk], f.mapFn
+			f.mu.Unlock()
+			if fn != nil && fn(i, call, chunk, w, r) {
+				return
+			}
+
+			done := track(&f.inFlight, &f.peak)
+			time.Sleep(50 * time.Millisecond)
+			done()
+			var parts []string
+			n, _ := strconv.Atoi(r.URL.Query().Get("partitions"))
+			for p := 0; p < n; p++ {
+				parts = append(parts, fmt.Sprintf("%s/part-%05d.jsonl", r.URL.Query().Get("out"), p))
+			}
+			json.NewEncoder(w).Encode(map[string]any{"partitions": parts})
+		})))
+	}
+	f.reducer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		in, out := r.URL.Query()["in"], r.URL.Query().Get("out")
+		f.reduceInputs.Store(int32(len(in)))
+		f.mu.Lock()
+		f.reduces[out] = in
+		f.queries[out] = r.URL.Query()
+		f.mu.Unlock()
+		done := track(&f.reducing, &f.reducePeak)
+		time.Sleep(50 * time.Millisecond)
+		done()
+		json.NewEncoder(w).Encode(map[string]any{"out": r.URL.Query().Get("out"), "files": len(r.URL.Query()["in"])})
+	}))
+	t.Cleanup(func() {
+		f.splitter.Close()
+		f.reducer.Close()
+		for _, m := range f.mappers {
+			m.CloseClientConnections()
+			m.Close()
+		}
+	})
+	return f
+}
+
+func (f *fakeServices) coordinator(cfg schedConfig) *coordinator {
+	return f.coordinatorWithReducerSlots(cfg, 1)
+}
+
+// coordinatorWithReducerSlots lists the fake reducer n times, so it may run
+// n reduce tasks at once.
+func (f *fakeServices) coordinatorWithReducerSlots(cfg schedConfig, n int) *coordinator {
+	var mappers, reducers []string
+	for _, m := range f.mappers {
+		mappers = append(mappers, m.URL)
+	}
+	for i := 0; i < n; i++ {
+		reducers = append(reducers, f.reducer.URL)
+	}
+	return newCoordinator(http.DefaultClient, cfg, []string{f.splitter.URL}, mappers, reducers)
+}
+
+// hang blocks until the coordinator gives up on the request.
+func hang(w http.ResponseWriter, r *http.Request) bool {
+	<-r.Context().Done()
+	return true
+}
+
+// runJob submits through the HTTP API and polls until the job is done.
+func runJob(t *testing.T, c *coordinator, body string) jobView {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", c.createJob)
+	mux.HandleFunc("GET /jobs/{id}", c.getJob)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/jobs", strings.NewReader(body)))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("POST /jobs: status %d: %s", rec.Code, rec.Body)
+	}
+	loc := rec.Header().Get("Location")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, loc, nil))
+		var v jobView
+		if err := json.NewDecoder(rec.Body).Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		if v.State == stateSucceeded || v.State == stateFailed {
+			return v
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job still %s", v.State)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+const hamletJob = `{"input":"mem://lab/input/hamlet.txt","chunks":4}`
+
+func phaseTasks(v jobView, phase string) []Task {
+	var out []Task
+	for _, t := range v.Tasks {
+		if t.Phase == phase {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+func mapTasks(v jobView) []Task { return phaseTasks(v, "map") }
+
+func TestJobRunsMapsInParallel(t *testing.T) {
+	f := newFakeServices(t, 2)
+	v := runJob(t, f.coordinator(schedConfig{}), hamletJob)
+
+	if v.State != stateSucceeded || !strings.HasPrefix(v.Output, "mem://lab/mr/jobs/"+v.ID+"/reduce/") {
+		t.Fatalf("job %s, output %q, error %q", v.State, v.Output, v.Error)
+	}
+	if f.peak.Load() != 2 {
+		t.Fatalf("peak concurrent maps = %d, want 2 (one per mapper)", f.peak.Load())
+	}
+	if f.reduceInputs.Load() != 4 {
+		t.Fatalf("reducer got %d inputs, want 4", f.reduceInputs.Load())
+	}
+	want := map[string]int{"split": 1, "map": 4, "reduce": 1}
+	for _, ph := range v.Phases {
+		if ph.State != stateSucceeded || ph.Tasks != want[ph.Name] || ph.Done != ph.Tasks || ph.DurationMS <= 0 {
+			t.Fatalf("phase %+v", ph)
+		}
+	}
+	if len(v.Tasks) != 6 {
+		t.Fatalf("%d tasks, want 6", len(v.Tasks))
+	}
+}
+
+func TestReducersRunInParallelPerPartition(t *testing.T) {
+	f := newFakeServices(t, 2)
+	v := runJob(t, f.coordinatorWithReducerSlots(schedConfig{}, 3), `{"input":"mem://lab/input/hamlet.txt","chunks":4,"reducers":3}`)
+
+	if v.State != stateSucceeded {
+		t.Fatalf("job %s: %s", v.State, v.Error)
+	}
+	if len(v.Outputs) != 3 || v.Output != "" {
+		t.Fatalf("outputs %v, output %q; want 3 outputs and no merged file", v.Outputs, v.Output)
+	}
+	for _, m := range mapTasks(v) {
+		if len(m.Output) != 3 {
+			t.Fatalf("map task %d output %v, want 3 partitions", m.ID, m.Output)
+		}
+	}
+	reduces := phaseTasks(v, "reduce")
+	if len(reduces) != 3 {
+		t.Fatalf("%d reduce tasks, want 3", len(reduces))
+	}
+	for p, task := range reduces {
+		if len(task.Inputs) != 4 {
+			t.Fatalf("reduce %d got %d inputs, want one per map task", p, len(task.Inputs))
+		}
+		for _, in := range task.Inputs {
+			if !strings.HasSuffix(in, fmt.Sprintf("/part-%05d.jsonl", p)) {
+				t.Fatalf("reduce %d read %s, another partition", p, in)
+			}
+		}
+		if task.Output[0] != v.Outputs[p] {
+			t.Fatalf("outputs[%d] = %s, want reduce task's %s", p, v.Outputs[p], task.Output[0])
+		}
+	}
+	if f.reducePeak.Load() != 3 {
+		t.Fatalf("peak concurrent reduces = %d, want 3", f.reducePeak.Load())
+	}
+}
+
+func TestMergeCombinesPartitions(t *testing.T) {
+	f := newFakeServices(t, 2)
+	v := runJob(t, f.coordinatorWithReducerSlots(schedConfig{}, 2), `{"input":"mem://lab/input/hamlet.txt","chunks":4,"reducers":2,"merge":true}`)
+
+	if v.State != stateSucceeded {
+		t.Fatalf("job %s: %s", v.State, v.Error)
+	}
+	if len(v.Phases) != 4 || v.Phases[3].Name != "merge" || v.Phases[3].State != stateSucceeded {
+		t.Fatalf("phases %+v", v.Phases)
+	}
+	if !strings.HasPrefix(v.Output, "mem://lab/mr/jobs/"+v.ID+"/merge/") {
+		t.Fatalf("output %q, want the merge's", v.Output)
+	}
+	f.mu.Lock()
+	in := f.reduces[v.Output]
+	f.mu.Unlock()
+	if strings.Join(in, ",") != strings.Join(v.Outputs, ",") {
+		t.Fatalf("merge read %v, want the reducers' outputs %v", in, v.Outputs)
+	}
+}
+
+func TestOutputOptionsReachFinalWriter(t *testing.T) {
+	f := newFakeServices(t, 1)
+	v := runJob(t, f.coordinatorWithReducerSlots(schedConfig{}, 2), `{"input":"mem://lab/input/hamlet.txt","reducers":2,"merge":true,"top":5,"output_format":"tsv"}`)
+	if v.State != stateSucceeded || v.Order != "count" || v.Top != 5 || v.OutputFormat != "tsv" {
+		t.Fatalf("job %s (%s), order %q top %d format %q", v.State, v.Error, v.Order, v.Top, v.OutputFormat)
+	}
+	if !strings.HasSuffix(v.Output, ".tsv") {
+		t.Fatalf("output %s, want .tsv", v.Output)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// Only the merge writes the final file; the reducers feed it JSON.
+	if q := f.queries[v.Output]; q.Get("order") != "count" || q.Get("top") != "5" || q.Get("output_format") != "tsv" {
+		t.Fatalf("merge got %v", q)
+	}
+	for _, out := range v.Outputs {
+		if q := f.queries[out]; q.Has("order") || q.Has("output_format") || !strings.HasSuffix(out, ".json") {
+			t.Fatalf("reducer feeding the merge got %v, writing %s", q, out)
+		}
+	}
+}
+
+func TestChunkBytesReachesSplitter(t *testing.T) {
+	f := newFakeServices(t, 1)
+	v := runJob(t, f.coordinator(schedConfig{}), `{"input":"mem://lab/input/hamlet.txt","chunk_bytes":65536}`)
+
+	if v.State != stateSucceeded || v.ChunkBytes != 65536 {
+		t.Fatalf("job %s (%s), chunk_bytes %d", v.State, v.Error, v.ChunkBytes)
+	}
+	q := f.splitQuery.Load().(url.Values)
+	if q.Get("chunk_bytes") != "65536" || q.Has("chunks") {
+		t.Fatalf("splitter got %v, want chunk_bytes only", q)
+	}
+	if len(mapTasks(v)) != 2 {
+		t.Fatalf("%d map tasks, want one per chunk the splitter made", len(mapTasks(v)))
+	}
+}
+
+func TestJobAndArgsReachWorkers(t *testing.T) {
+	f := newFakeServices(t, 1)
+	v := runJob(t, f.coordinator(schedConfig{}), `{"job":"grep","args":{"pattern":"^Ham\\."},"input":"mem://lab/input/hamlet.txt","chunks":2,"compression":"zstd"}`)
+
+	if v.State != stateSucceeded || v.JobName != "grep" || v.Args["pattern"] != `^Ham\.` {
+		t.Fatalf("job %s (%s), %s %v", v.State, v.Error, v.JobName, v.Args)
+	}
+	q := f.mapQuery.Load().(url.Values)
+	if q.Get("job") != "grep" || q.Get("arg") != `pattern=^Ham\.` || q.Get("format") != "binary" || q.Get("compression") != "zstd" {
+		t.Fatalf("mapper got %v", q)
+	}
+	if v.Format != "binary" || v.Compression != "zstd" {
+		t.Fatalf("job format %s, compression %s", v.Format, v.Compression)
+	}
+	if in := phaseTasks(v, "reduce")[0].Inputs; len(in) != 2 {
+		t.Fatalf("reduce inputs %v", in)
+	}
+}
+
+func TestFailedTaskRetriesOnAnotherWorker(t *testing.T) {
+	f := newFakeServices(t, 2)
+	f.mapFn = func(mapper, call int, chunk string, w http.ResponseWriter, r *http.Request) bool {
+		if mapper == 0 {
+			http.Error(w, "out of memory", 500)
+			return true
+		}
+		return false
+	}
+	v := runJob(t, f.coordinator(schedConfig{}), hamletJob)
+
+	if v.State != stateSucceeded {
+		t.Fatalf("job %s: %s", v.State, v.Error)
+	}
+	retried := 0
+	for _, task := range mapTasks(v) {
+		if task.Worker != f.mappers[1].URL {
+			t.Fatalf("task %d committed from %s, want the healthy mapper", task.ID, task.Worker)
+		}
+		if len(task.Attempts) == 2 {
+			retried++
+			if task.Attempts[0].State != stateFailed || task.Attempts[1].Worker != f.mappers[1].URL {
+				t.Fatalf("attempts %+v", task.Attempts)
+			}
+		}
+	}
+	if retried == 0 || v.Phases[1].Retries != retried {
+		t.Fatalf("%d tasks retried, phase says %d", retried, v.Phases[1].Retries)
+	}
+}
+
+func TestTaskFailsAfterMaxAttempts(t *testing.T) {
+	f := newFakeServices(t, 1)
+	f.mapFn = func(mapper, call int, chunk string, w http.ResponseWriter, r *http.Request) bool {
+		if strings.HasSuffix(chunk, "chunk01.txt") {
+			http.Error(w, "boom", 500)
+			return true
+		}
+		return false
+	}
+	v := runJob(t, f.coordinator(schedConfig{MaxAttempts: 3}), hamletJob)
+
+	if v.State != stateFailed || !strings.Contains(v.Error, "boom") {
+		t.Fatalf("job %s, error %q", v.State, v.Error)
+	}
+	if v.Phases[1].State != stateFailed || v.Phases[2].State != statePending {
+		t.Fatalf("phases %+v", v.Phases)
+	}
+	for _, task := range mapTasks(v) {
+		if task.State == stateFailed && len(task.Attempts) != 3 {
+			t.Fatalf("failed task had %d attempts, want 3", len(task.Attempts))
+		}
+	}
+}
+
+func TestBadRequestIsNotRetried(t *testing.T) {
+	f := newFakeServices(t, 2)
+	f.mapFn = func(mapper, call int, chunk string, w http.ResponseWriter, r *http.Request) bool {
+		http.Error(w, "invalid url", 400)
+		return true
+	}
+	v := runJob(t, f.coordinator(schedConfig{}), hamletJob)
+
+	if v.State != stateFailed {
+		t.Fatalf("job %s", v.State)
+	}
+	for _, task := range mapTasks(v) {
+		if len(task.Attempts) > 1 {
+			t.Fatalf("task %d retried a 400: %+v", task.ID, task.Attempts)
+		}
+	}
+}
+
+func TestTimedOutTaskIsRetried(t *testing.T) {
+	f := newFakeServices(t, 2)
+	f.mapFn = func(mapper, call int, chunk string, w http.ResponseWriter, r *http.Request) bool {
+		return mapper == 0 && hang(w, r)
+	}
+	v := runJob(t, f.coordinator(schedConfig{TaskTimeout: 200 * time.Millisecond}), hamletJob)
+
+	if v.State != stateSucceeded {
+		t.Fatalf("job %s: %s", v.State, v.Error)
+	}
+	for _, task := range mapTasks(v) {
+		if a := task.Attempts[0]; a.Worker == f.mappers[0].URL && !strings.Contains(a.Error, "timed out") {
+			t.Fatalf("attempt on the hung mapper: %+v", a)
+		}
+	}
+}
+
+func TestStragglerGetsBackup(t *testing.T) {
+	f := newFakeServices(t, 2)
+	f.mapFn = func(mapper, call int, chunk string, w http.ResponseWriter, r *http.Request) bool {
+		// chunk03's first run is stuck; a second run is as fast as any.
+		return strings.HasSuffix(chunk, "chunk03.txt") && call == 1 && hang(w, r)
+	}
+	start := time.Now()
+	v := runJob(t, f.coordinator(schedConfig{SpeculateFactor: 2, SpeculateMin: 10 * time.Millisecond}), hamletJob)
+
+	if v.State != stateSucceeded {
+		t.Fatalf("job %s: %s", v.State, v.Error)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatalf("job took %s; the backup should have finished it", d)
+	}
+	straggler := mapTasks(v)[3]
+	if len(straggler.Attempts) != 2 || !straggler.Attempts[1].Backup {
+		t.Fatalf("straggler attempts %+v", straggler.Attempts)
+	}
+	if straggler.Attempts[0].State != stateSuperseded || straggler.Attempts[1].State != stateSucceeded {
+		t.Fatalf("straggler attempts %+v", straggler.Attempts)
+	}
+	if straggler.Output[0] != straggler.Attempts[1].Output[0] || !strings.Contains(straggler.Output[0], "-attempt2/") {
+		t.Fatalf("committed output %v, want the backup's", straggler.Output)
+	}
+	if v.Phases[1].Backups != 1 {
+		t.Fatalf("phase backups = %d, want 1", v.Phases[1].Backups)
+	}
+}
+
+func TestLostWorkerTasksMoveOn(t *testing.T) {
+	f := newFakeServices(t, 2)
+	f.down[0].Store(true)
+	f.mapFn = func(mapper, call int, chunk string, w http.ResponseWriter, r *http.Request) bool {
+		return mapper == 0 && hang(w, r)
+	}
+	c := f.coordinator(schedConfig{TaskTimeout: time.Minute})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.mappers.monitor(ctx, http.DefaultClient, 20*time.Millisecond, 2)
+
+	v := runJob(t, c, hamletJob)
+	if v.State != stateSucceeded {
+		t.Fatalf("job %s: %s", v.State, v.Error)
+	}
+	for _, task := range mapTasks(v) {
+		if a := task.Attempts[0]; a.Worker == f.mappers[0].URL && a.Error != errWorkerLost.Error() {
+			t.Fatalf("attempt on the lost mapper: %+v", a)
+		}
+	}
+	for _, w := range c.mappers.view() {
+		if w.URL == f.mappers[0].URL && w.Alive {
+			t.Fatal("lost mapper still marked alive")
+		}
+	}
+}
+
+func TestCreateJobValidates(t *testing.T) {
+	c := newCoordinator(http.DefaultClient, schedConfig{}, []string{"x"}, []string{"x"}, []string{"x"})
+	for _, body := range []string{
+		`{`,
+		`{"input":"hamlet.txt"}`,
+		`{"input":"s3://b/k","chunks":99}`,
+		`{"input":"s3://b/k","reducers":65}`,
+		`{"input":"s3://b/k","chunks":2,"chunk_bytes":100}`,
+		`{"input":"s3://b/k","job":"nope"}`,
+		`{"input":"s3://b/k","job":"grep"}`,
+		`{"input":"s3://b/k","job":"topk","args":{"k":"x"}}`,
+		`{"input":"s3://b/k","format":"xml"}`,
+		`{"input":"s3://b/k","order":"size"}`,
+		`{"input":"s3://b/k","top":-1}`,
+		`{"input":"s3://b/k","top":5,"reducers":3}`,
+		`{"input":"s3://b/k","job":"topk","reducers":3}`,
+		`{"input":"s3://b/k","output_format":"csv"}`,
+		`{"input":"s3://b/k","format":"json","compression":"zstd"}`,
+	} {
+		rec := httptest.NewRecorder()
+		c.createJob(rec, httptest.NewRequest(http.MethodPost, "/jobs", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status %d, want 400", body, rec.Code)
+		}
+	}
+}
+
+func TestCreateJobRejectsHugeBody(t *testing.T) {
+	c := newCoordinator(http.DefaultClient, schedConfig{}, []string{"x"}, []string{"x"}, []string{"x"})
+	body := `{"input":"s3://b/k","args":{"pad":"` + strings.Repeat("x", maxJobRequest) + `"}}`
+	rec := httptest.NewRecorder()
+	c.createJob(rec, httptest.NewRequest(http.MethodPost, "/jobs", strings.NewReader(body)))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status %d, want 413", rec.Code)
+	}
+}
+
+func TestFinishedJobsAreCapped(t *testing.T) {
+	c := newCoordinator(http.DefaultClient, schedConfig{KeepJobs: 2}, []string{"x"}, []string{"x"}, []string{"x"})
+	for _, id := range []string{"a", "b", "c"} {
+		c.jobs[id] = newJob(id, JobRequest{})
+		c.retire(id)
+	}
+	if _, ok := c.job("a"); ok {
+		t.Fatal("oldest finished job still kept")
+	}
+	if _, ok := c.job("c"); !ok || len(c.jobs) != 2 {
+		t.Fatalf("kept %d jobs, want the 2 newest", len(c.jobs))
+	}
+}
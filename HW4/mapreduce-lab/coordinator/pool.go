@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errWorkerLost cancels the attempts running on a worker that stopped
+// answering heartbeats.
+var errWorkerLost = errors.New("worker stopped answering heartbeats")
+
+// worker is one splitter, mapper or reducer instance.
+type worker struct {
+	url     string
+	slots   int // tasks it may run at once: how many times its URL is listed
+	busy    int
+	alive   bool
+	misses  int // heartbeats missed in a row
+	running map[*lease]context.CancelCauseFunc
+}
+
+// pool hands out slots on its live workers. Listing a URL twice lets that
+// worker (or a load balancer in front of several) run two tasks at once.
+type pool struct {
+	name string
+
+	mu      sync.Mutex
+	workers []*worker
+	changed chan struct{} // closed and replaced whenever a slot may have opened up
+}
+
+func newPool(name string, urls []string) *pool {
+	p := &pool{name: name, changed: make(chan struct{})}
+	byURL := make(map[string]*worker)
+	for _, u := range urls {
+		u = strings.TrimRight(u, "/")
+		if w, ok := byURL[u]; ok {
+			w.slots++
+			continue
+		}
+		w := &worker{url: u, slots: 1, alive: true, running: make(map[*lease]context.CancelCauseFunc)}
+		byURL[u] = w
+		p.workers = append(p.workers, w)
+	}
+	return p
+}
+
+// size is the total number of slots.
+func (p *pool) size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, w := range p.workers {
+		n += w.slots
+	}
+	return n
+}
+
+// lease is one attempt's hold on a worker slot.
+type lease struct {
+	p *pool
+	w *worker
+}
+
+func (l *lease) url() string { return l.w.url }
+
+// watch registers cancel to be called if the worker is lost while the
+// attempt runs.
+func (l *lease) watch(cancel context.CancelCauseFunc) {
+	l.p.mu.Lock()
+	defer l.p.mu.Unlock()
+	if !l.w.alive {
+		cancel(errWorkerLost)
+		return
+	}
+	l.w.running[l] = cancel
+}
+
+func (l *lease) release() {
+	l.p.mu.Lock()
+	defer l.p.mu.Unlock()
+	delete(l.w.running, l)
+	l.w.busy--
+	l.p.broadcast()
+}
+
+// broadcast wakes everyone waiting in acquire. Callers hold mu.
+func (p *pool) broadcast() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// acquire waits for a free slot on a live worker. Workers in avoid (ones
+// this task already ran on) are only used when no other live worker
+// exists, so a retry lands somewhere else when it can.
+func (p *pool) acquire(ctx context.Context, avoid map[string]bool) (*lease, error) {
+	for {
+		p.mu.Lock()
+		l := p.take(avoid, true)
+		changed := p.changed
+		p.mu.Unlock()
+		if l != nil {
+			return l, nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// tryAcquire takes a free slot on a live worker outside avoid, if one is
+// free right now.
+func (p *pool) tryAcquire(avoid map[string]bool) *lease {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.take(avoid, false)
+}
+
+// take picks the least busy eligible worker. Callers hold mu.
+func (p *pool) take(avoid map[string]bool, fallback bool) *lease {
+	var best *worker
+	others := false // a live worker outside avoid exists, free or not
+	for _, w := range p.workers {
+		if !w.alive || avoid[w.url] {
+			continue
+		}
+		others = true
+		if w.busy < w.slots && (best == nil || w.busy < best.busy) {
+			best = w
+		}
+	}
+	if best == nil && fallback && !others {
+		for _, w := range p.workers {
+			if w.alive && w.busy < w.slots && (best == nil || w.busy < best.busy) {
+				best = w
+			}
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	best.busy++
+	return &lease{p: p, w: best}
+}
+
+// monitor GETs every worker's /health each interval. A worker that misses
+// `misses` heartbeats in a row is marked dead: it gets no new tasks and its
+// running attempts are cancelled so they can be retried elsewhere. Its next
+// good heartbeat brings it back.
+func (p *pool) monitor(ctx context.Context, client *http.Client, interval time.Duration, misses int) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+		p.mu.Lock()
+		workers := append([]*worker(nil), p.workers...)
+		p.mu.Unlock()
+
+		var wg sync.WaitGroup
+		for _, w := range workers {
+			wg.Add(1)
+			go func(w *worker) {
+				defer wg.Done()
+				hctx, cancel := context.WithTimeout(ctx, interval)
+				defer cancel()
+				p.heartbeat(w, checkHealth(hctx, client, w.url), misses)
+			}(w)
+		}
+		wg.Wait()
+	}
+}
+
+func (p *pool) heartbeat(w *worker, err error, misses int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err == nil {
+		w.misses = 0
+		if !w.alive {
+			w.alive = true
+			log.Printf("%s %s is back", p.name, w.url)
+			p.broadcast()
+		}
+		return
+	}
+	w.misses++
+	if w.alive && w.misses >= misses {
+		w.alive = false
+		log.Printf("%s %s lost after %d missed heartbeats: %v", p.name, w.url, w.misses, err)
+		for _, cancel := range w.running {
+			cancel(errWorkerLost)
+		}
+		p.broadcast()
+	}
+}
+
+func checkHealth(ctx context.Context, client *http.Client, worker string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, worker+"/health", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("health status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// workerView is a worker as GET /workers shows it.
+type workerView struct {
+	Pool   string `json:"pool"`
+	URL    string `json:"url"`
+	Alive  bool   `json:"alive"`
+	Busy   int    `json:"busy"`
+	Slots  int    `json:"slots"`
+	Misses int    `json:"missed_heartbeats"`
+}
+
+func (p *pool) view() []workerView {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var out []workerView
+	for _, w := range p.workers {
+		out = append(out, workerView{p.name, w.url, w.alive, w.busy, w.slots, w.misses})
+	}
+	return out
+}
+
+// serviceError is a non-2xx reply from a worker.
+type serviceError struct {
+	status int
+	msg    string
+}
+
+func (e *serviceError) Error() string { return fmt.Sprintf("status %d: %s", e.status, e.msg) }
+
+// permanent reports whether err would recur on any worker: the service
+// rejected the request itself, so running it again is pointless.
+func permanent(err error) bool {
+	var se *serviceError
+	return errors.As(err, &se) && se.status >= 400 && se.status < 500
+}
+
+// callService GETs worker+path with the query and decodes the JSON reply
+// into out.
+func callService(ctx context.Context, client *http.Client, worker, path string, q url.Values, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, worker+path+"?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return fmt.Errorf("%s%s: %w", worker, path, &serviceError{resp.StatusCode, strings.TrimSpace(string(msg))})
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s%s: bad reply: %v", worker, path, err)
+	}
+	return nil
+}
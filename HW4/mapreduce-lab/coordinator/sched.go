@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+// schedConfig is how the coordinator deals with failed and slow tasks.
+type schedConfig struct {
+	// TaskTimeout bounds each attempt. Default 60s.
+	TaskTimeout time.Duration
+	// MaxAttempts is how many failed attempts fail a task. Default 3.
+	MaxAttempts int
+	// A running task gets one backup attempt on another worker once it has
+	// run SpeculateFactor times the median time of the phase's finished
+	// tasks, and at least SpeculateMin. 0 turns speculation off.
+	SpeculateFactor float64
+	SpeculateMin    time.Duration
+	// KeepJobs is how many finished jobs are kept for GET /jobs; the oldest
+	// are dropped beyond that. Default 100.
+	KeepJobs int
+}
+
+// errSuperseded stops the attempts still running once another attempt of
+// their task has been committed.
+var errSuperseded = errors.New("another attempt finished first")
+
+// speculateEvery is how often a running task checks whether it is
+// straggling.
+const speculateEvery = 20 * time.Millisecond
+
+// runPhase runs one task per entry of inputs, each on a worker from p, and
+// returns each task's committed outputs, in task order. A task that fails
+// for good fails the phase.
+func (c *coordinator) runPhase(ctx context.Context, j *Job, name string, p *pool, inputs [][]string, fn taskFunc) ([][]string, error) {
+	j.mu.Lock()
+	ph := j.phase(name)
+	ph.State, ph.Started, ph.Tasks = stateRunning, now(), len(inputs)
+	tasks := make([]*Task, len(inputs))
+	for i, in := range inputs {
+		tasks[i] = &Task{ID: len(j.Tasks), Phase: name, Inputs: in, State: statePending}
+		j.Tasks = append(j.Tasks, tasks[i])
+	}
+	j.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(tasks))
+	for _, t := range tasks {
+		wg.Add(1)
+		go func(t *Task) {
+			defer wg.Done()
+			if err := c.runTask(ctx, j, ph, p, t, fn); err != nil {
+				errs <- err
+				cancel() // no point finishing the rest
+			}
+		}(t)
+	}
+	wg.Wait()
+	close(errs)
+	err := <-errs
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	ph.Finished = now()
+	ph.DurationMS = millis(ph.Started, ph.Finished)
+	if err != nil {
+		ph.State = stateFailed
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	ph.State = stateSucceeded
+	outs := make([][]string, len(tasks))
+	for i, t := range tasks {
+		outs[i] = t.Output
+	}
+	return outs, nil
+}
+
+type attemptResult struct {
+	n   int // index into Task.Attempts
+	out []string
+	err error
+}
+
+// runTask runs attempts of t until one succeeds and is committed. A failed
+// attempt is retried, on another worker when there is one, until
+// MaxAttempts have failed; an error the service reports as the request's
+// own fault (4xx) fails the task at once. A straggler gets one backup
+// attempt, and whichever of the two finishes first wins.
+func (c *coordinator) runTask(ctx context.Context, j *Job, ph *Phase, p *pool, t *Task, fn taskFunc) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(errSuperseded) // stops a losing attempt
+
+	results := make(chan attemptResult, c.cfg.MaxAttempts+1)
+	tried := make(map[string]bool)
+	running, failures, backedUp := 0, 0, false
+	start := func(l *lease, backup bool) {
+		tried[l.url()] = true
+		running++
+		n := c.beginAttempt(j, ph, t, l.url(), backup)
+		go func() {
+			out, err := c.runAttempt(ctx, j, t, n, l, fn)
+			results <- attemptResult{n, out, err}
+		}()
+	}
+
+	l, err := p.acquire(ctx, tried)
+	if err != nil {
+		return err
+	}
+	start(l, false)
+
+	var tick <-chan time.Time
+	if c.cfg.SpeculateFactor > 0 {
+		ticker := time.NewTicker(speculateEvery)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case r := <-results:
+			running--
+			if r.err == nil {
+				c.commit(j, ph, t, r.n, r.out)
+				return nil
+			}
+			failures++
+			if permanent(r.err) || (failures >= c.cfg.MaxAttempts && running == 0) {
+				c.failTask(j, ph, t, r.err)
+				return fmt.Errorf("task %d: %w", t.ID, r.err)
+			}
+			if running > 0 {
+				continue // the other attempt may still make it
+			}
+			log.Printf("job %s task %d attempt failed, retrying: %v", j.ID, t.ID, r.err)
+			l, err := p.acquire(ctx, tried)
+			if err != nil {
+				return err
+			}
+			j.mu.Lock()
+			ph.Retries++
+			j.mu.Unlock()
+			start(l, false)
+
+		case <-tick:
+			if backedUp || running != 1 || !c.straggling(j, t) {
+				continue
+			}
+			if l := p.tryAcquire(tried); l != nil {
+				backedUp = true
+				j.mu.Lock()
+				ph.Backups++
+				j.mu.Unlock()
+				log.Printf("job %s task %d is straggling, starting a backup on %s", j.ID, t.ID, l.url())
+				start(l, true)
+			}
+
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+	}
+}
+
+// runAttempt runs attempt n of t on l's worker. It is cut short by the
+// task timeout, by the worker being lost, or by another attempt winning.
+func (c *coordinator) runAttempt(ctx context.Context, j *Job, t *Task, n int, l *lease, fn taskFunc) ([]string, error) {
+	defer l.release()
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	l.watch(cancel)
+	ctx, stop := context.WithTimeoutCause(ctx, c.cfg.TaskTimeout, fmt.Errorf("timed out after %s", c.cfg.TaskTimeout))
+	defer stop()
+
+	out, err := fn(ctx, j, l.url(), t.Inputs, j.attemptOutput(t, n+1))
+	if err != nil && ctx.Err() != nil {
+		err = context.Cause(ctx)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	a := &t.Attempts[n]
+	a.Finished = now()
+	a.DurationMS = millis(&a.Started, a.Finished)
+	switch {
+	case t.State == stateSucceeded || errors.Is(err, errSuperseded):
+		a.State = stateSuperseded
+	case err != nil:
+		a.State, a.Error = stateFailed, err.Error()
+	default:
+		a.State, a.Output = stateSucceeded, out
+	}
+	return out, err
+}
+
+func (c *coordinator) beginAttempt(j *Job, ph *Phase, t *Task, worker string, backup bool) int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if t.Started == nil {
+		t.Started = now()
+	}
+	t.State, t.Worker = stateRunning, worker
+	n := len(t.Attempts)
+	t.Attempts = append(t.Attempts, Attempt{N: n + 1, Worker: worker, Backup: backup, State: stateRunning, Started: time.Now().UTC()})
+	return n
+}
+
+// commit makes attempt n's output the task's. It is the only place a task's
+// output is set, and runs once per task, so however many attempts finish,
+// later phases only ever read one of them.
+func (c *coordinator) commit(j *Job, ph *Phase, t *Task, n int, out []string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	t.State, t.Worker, t.Output, t.Error = stateSucceeded, t.Attempts[n].Worker, out, ""
+	t.Finished = now()
+	t.DurationMS = millis(t.Started, t.Finished)
+	for i := range t.Attempts {
+		if i != n && t.Attempts[i].State == stateSucceeded {
+			t.Attempts[i].State = stateSuperseded
+		}
+	}
+	ph.Done++
+}
+
+func (c *coordinator) failTask(j *Job, ph *Phase, t *Task, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	t.State, t.Error = stateFailed, err.Error()
+	t.Finished = now()
+	t.DurationMS = millis(t.Started, t.Finished)
+	ph.Failed++
+}
+
+// straggling reports whether t's running attempt has taken much longer than
+// the tasks of its phase that already finished.
+func (c *coordinator) straggling(j *Job, t *Task) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var done []float64
+	for _, o := range j.Tasks {
+		if o.Phase == t.Phase && o.State == stateSucceeded {
+			done = append(done, o.DurationMS)
+		}
+	}
+	if len(done) == 0 {
+		return false
+	}
+	slices.Sort(done)
+	median := time.Duration(done[len(done)/2] * float64(time.Millisecond))
+
+	elapsed := time.Since(t.Attempts[len(t.Attempts)-1].Started)
+	return elapsed >= c.cfg.SpeculateMin && float64(elapsed) >= c.cfg.SpeculateFactor*float64(median)
+}
@@ -2,12 +2,20 @@ module mapreduce-lab
 
 go 1.22
 
-require github.com/aws/aws-sdk-go-v2 v1.30.3 // indirect
+require github.com/aws/aws-sdk-go-v2 v1.30.3
 
 require github.com/aws/aws-sdk-go-v2/config v1.27.27
 
 require github.com/aws/aws-sdk-go-v2/service/s3 v1.55.0
 
+require github.com/klauspost/compress v1.17.11
+
+require github.com/blevesearch/snowballstem v0.9.0
+
+require github.com/rivo/uniseg v0.4.7
+
+require golang.org/x/text v0.15.0
+
 require (
 	github.com/aws/aws-sdk-go-v2/aws/protocol/eventstream v1.6.2 // indirect
 	github.com/aws/aws-sdk-go-v2/credentials v1.17.27 // indirect
@@ -1,114 +1,281 @@
 package main
 
 import (
-	"bytes"
+	"bufio"
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"log"
 	"net/http"
 	"os"
-	"regexp"
+	"path"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"mapreduce-lab/mr"
+	"mapreduce-lab/storage"
 )
 
 type MapResponse struct {
-	Out string `json:"out"`
+	Out        string   `json:"out,omitempty"`        // unpartitioned output
+	Partitions []string `json:"partitions,omitempty"` // with ?partitions=R, one file per partition
+	Records    int      `json:"records"`              // records written, after combining
+	Bytes      int64    `json:"bytes"`                // bytes written, over all partitions
+	Spills     int      `json:"spills,omitempty"`     // sorted runs spilled to disk
 }
 
-var wordRe = regexp.MustCompile(`[A-Za-z0-9']+`) // keeps contractions like don't
-
-func parseS3URL(s string) (bucket, key string, err error) {
-	if !strings.HasPrefix(s, "s3://") {
-		return "", "", errors.New("must start with s3://")
-	}
-	rest := strings.TrimPrefix(s, "s3://")
-	parts := strings.SplitN(rest, "/", 2)
-	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
-		return "", "", errors.New("invalid s3 url, expected s3://bucket/key")
-	}
-	return parts[0], parts[1], nil
+// memoryConfig bounds how much of a map task's output the mapper holds.
+type memoryConfig struct {
+	Limit int64  // bytes of records buffered before combining and spilling
+	Dir   string // local directory for spilled runs and output being written
 }
 
-func s3URL(bucket, key string) string {
-	return "s3://" + bucket + "/" + key
-}
+// mapBlock is how much of a chunk, in whole lines, the map function gets
+// at a time.
+const mapBlock = 1 << 20
 
 func main() {
 	addr := getenv("ADDR", ":8080")
 	region := getenv("AWS_REGION", "us-east-1")
 	outPrefix := getenv("OUT_PREFIX", "mr/maps")
-
-	ctx := context.Background()
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
-	if err != nil {
-		log.Fatalf("aws config: %v", err)
+	stores := storage.Default(region, getenv("FILE_ROOT", "."))
+	limit, err := strconv.ParseInt(getenv("MAP_MEMORY_LIMIT", strconv.Itoa(64<<20)), 10, 64)
+	if err != nil || limit < 1 {
+		log.Fatalf("MAP_MEMORY_LIMIT must be a positive number of bytes, got %q", os.Getenv("MAP_MEMORY_LIMIT"))
 	}
-	s3c := s3.NewFromConfig(cfg)
+	mem := memoryConfig{Limit: limit, Dir: getenv("SPILL_DIR", os.TempDir())}
 
 	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(200)
 		_, _ = w.Write([]byte("ok"))
 	})
 
-	http.HandleFunc("/map", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/map", mapHandler(stores, outPrefix, mem))
+
+	log.Printf("mapper listening on %s region=%s outPrefix=%s memoryLimit=%d spillDir=%s jobs=%s",
+		addr, region, outPrefix, mem.Limit, mem.Dir, strings.Join(mr.Names(), ","))
+	log.Fatal(http.ListenAndServe(addr, nil))
+}
+
+// mapHandler serves /map?in=<url>: it runs a job's map (and combine) over
+// one chunk and writes the records under outPrefix in the chunk's bucket,
+// or to ?out=<url> if given. ?job=<name> picks the job, word count by
+// default, and ?arg=<name>=<value> passes it arguments. ?s3= is accepted
+// for ?in=.
+//
+// The records are in the binary format unless ?format=json asks for JSON
+// lines; ?compression=snappy or zstd compresses the binary format.
+//
+// With ?partitions=R the records are split by partition(key, R) into R
+// files, part-00000.mrb and on, in a directory (?out= then names the
+// directory), so R reducers can each take one partition from every mapper.
+//
+// Memory use is bounded by mem.Limit however large the chunk is: the chunk
+// is streamed to the map a block of lines at a time, and its records are
+// combined in memory and spilled to mem.Dir as sorted runs when they pass
+// the limit, then merged. Every output file is sorted by key, so a reducer
+// can merge its inputs as streams.
+func mapHandler(stores *storage.Stores, outPrefix string, mem memoryConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
 		start := time.Now()
-		in := r.URL.Query().Get("s3")
+		in := r.URL.Query().Get("in")
+		if in == "" {
+			in = r.URL.Query().Get("s3")
+		}
 		if in == "" {
-			http.Error(w, "missing ?s3=s3://bucket/key", 400)
+			http.Error(w, "missing ?in=s3://bucket/key", 400)
 			return
 		}
 
-		bucket, key, err := parseS3URL(in)
+		inURL, err := storage.Parse(in)
 		if err != nil {
 			http.Error(w, err.Error(), 400)
 			return
 		}
-
-		obj, err := s3c.GetObject(ctx, &s3.GetObjectInput{Bucket: &bucket, Key: &key})
+		jobName, job, err := mr.FromQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		enc, err := mr.ParseEncoding(r.URL.Query().Get("format"), r.URL.Query().Get("compression"))
 		if err != nil {
-			http.Error(w, "s3 get error: "+err.Error(), 500)
+			http.Error(w, err.Error(), 400)
 			return
 		}
-		defer obj.Body.Close()
+		parts := 0
+		if v := r.URL.Query().Get("partitions"); v != "" {
+			if parts, err = strconv.Atoi(v); err != nil || parts < 1 || parts > 256 {
+				http.Error(w, "invalid partitions (1..256)", 400)
+				return
+			}
+		}
+		name := fmt.Sprintf("%s/%s_%s", outPrefix, sanitizeKey(inURL.Key), time.Now().UTC().Format("20060102T150405Z"))
+		out := inURL.At(name + enc.Ext())
+		if parts > 0 {
+			out = inURL.At(name)
+		}
+		if o := r.URL.Query().Get("out"); o != "" {
+			if out, err = storage.Parse(o); err != nil {
+				http.Error(w, "out: "+err.Error(), 400)
+				return
+			}
+		}
 
-		buf := new(bytes.Buffer)
-		_, _ = buf.ReadFrom(obj.Body)
-		text := strings.ToLower(buf.String())
+		outs := []storage.URL{out}
+		if parts > 0 {
+			outs = make([]storage.URL, parts)
+			dir := strings.TrimSuffix(out.Key, "/")
+			for i := range outs {
+				outs[i] = out.At(fmt.Sprintf("%s/part-%05d%s", dir, i, enc.Ext()))
+			}
+		}
 
-		counts := map[string]int{}
-		for _, tok := range wordRe.FindAllString(text, -1) {
-			counts[tok]++
+		rc, err := stores.Get(ctx, inURL)
+		if err != nil {
+			http.Error(w, "get error: "+err.Error(), 500)
+			return
 		}
+		defer rc.Close()
 
-		// Write JSON to S3
-		outKey := fmt.Sprintf("%s/%s_%s.json", outPrefix, sanitizeKey(key), time.Now().UTC().Format("20060102T150405Z"))
-		body, _ := json.Marshal(counts)
+		// The document is the chunk's file name, not its whole URL, which
+		// differs between attempts of the same task.
+		doc := path.Base(inURL.Key)
+		sp := mr.NewSpiller(job.Combine, mem.Limit, mem.Dir)
+		defer sp.Close()
+		if err := mapBlocks(rc, mapBlock, func(text string) { job.Map(doc, text, sp.Add) }); err != nil {
+			http.Error(w, "get error: "+err.Error(), 500)
+			return
+		}
+		sorted, err := sp.Sorted()
+		if err != nil {
+			http.Error(w, "map error: "+err.Error(), 500)
+			return
+		}
 
-		_, err = s3c.PutObject(ctx, &s3.PutObjectInput{
-			Bucket: &bucket,
-			Key:    &outKey,
-			Body:   bytes.NewReader(body),
-		})
+		// Write records next to the input (or to ?out=)
+		n, size, err := writePartitions(ctx, stores, sorted, outs, enc, mem.Dir)
 		if err != nil {
-			http.Error(w, "s3 put error: "+err.Error(), 500)
+			http.Error(w, err.Error(), 500)
 			return
 		}
+		resp := MapResponse{Records: n, Bytes: size, Spills: sp.Stats().Spills}
+		if parts == 0 {
+			resp.Out = out.String()
+		} else {
+			for _, u := range outs {
+				resp.Partitions = append(resp.Partitions, u.String())
+			}
+		}
 
-		out := s3URL(bucket, outKey)
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(MapResponse{Out: out})
+		json.NewEncoder(w).Encode(resp)
 
-		log.Printf("map ok input=%s unique=%d out=%s dur=%s", in, len(counts), out, time.Since(start))
-	})
+		st := sp.Stats()
+		log.Printf("map ok input=%s job=%s emitted=%d records=%d bytes=%d format=%s compression=%s spills=%d spilled=%d out=%s partitions=%d dur=%s",
+			in, jobName, st.Records, n, size, enc.Format(), enc.Compression, st.Spills, st.Spilled, out, parts, time.Since(start))
+	}
+}
 
-	log.Printf("mapper listening on %s region=%s outPrefix=%s", addr, region, outPrefix)
-	log.Fatal(http.ListenAndServe(addr, nil))
+// mapBlocks reads r and calls fn with about size bytes of whole lines at a
+// time; a line longer than size comes whole, on its own.
+func mapBlocks(r io.Reader, size int, fn func(text string)) error {
+	br := bufio.NewReaderSize(r, 64<<10)
+	var block strings.Builder
+	for {
+		line, err := br.ReadString('\n')
+		block.WriteString(line)
+		if block.Len() > 0 && (block.Len() >= size || err != nil) {
+			fn(block.String())
+			block.Reset()
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// writePartitions writes the sorted records of src to outs in enc, each
+// record to outs[partition(key)], and returns how many records and bytes it
+// wrote. Each partition goes to a local temporary file first, so it can be
+// streamed into storage without being held in memory.
+func writePartitions(ctx context.Context, stores *storage.Stores, src mr.Source, outs []storage.URL, enc mr.Encoding, dir string) (int, int64, error) {
+	files := make([]*os.File, len(outs))
+	writers := make([]mr.RecordWriter, len(outs))
+	defer func() {
+		for _, f := range files {
+			if f != nil {
+				f.Close()
+				os.Remove(f.Name())
+			}
+		}
+	}()
+	for i := range outs {
+		f, err := os.CreateTemp(dir, "mr-part-*"+enc.Ext())
+		if err != nil {
+			return 0, 0, fmt.Errorf("put error: %w", err)
+		}
+		files[i], writers[i] = f, enc.NewWriter(f)
+	}
+
+	n := 0
+	for {
+		kv, err := src.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, 0, fmt.Errorf("map error: %w", err)
+		}
+		p := 0
+		if len(outs) > 1 {
+			p = partition(kv.Key, len(outs))
+		}
+		if err := writers[p].Write(kv); err != nil {
+			return 0, 0, fmt.Errorf("put error: %w", err)
+		}
+		n++
+	}
+
+	var total int64
+	for i, u := range outs {
+		if err := writers[i].Flush(); err != nil {
+			return 0, 0, fmt.Errorf("put error: %w", err)
+		}
+		size, err := putFile(ctx, stores, u, files[i])
+		if err != nil {
+			return 0, 0, fmt.Errorf("put error: %w", err)
+		}
+		total += size
+	}
+	return n, total, nil
+}
+
+// putFile copies f, written up to its current offset, into u, and returns
+// its size.
+func putFile(ctx context.Context, stores *storage.Stores, u storage.URL, f *os.File) (int64, error) {
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return size, stores.Put(ctx, u, storage.Sized(f, size))
+}
+
+// partition picks which of n reducers gets key. Every mapper sends a key
+// to the same partition, so each reducer sees all of a key's values.
+func partition(key string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
 }
 
 func getenv(k, def string) string {
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"mapreduce-lab/mr"
+	"mapreduce-lab/storage"
+)
+
+func TestMapCountsWords(t *testing.T) {
+	ctx := context.Background()
+	stores := storage.NewStores()
+	stores.Register("mem", storage.NewMemory())
+
+	in, _ := storage.Parse("mem://lab/mr/chunks/c00.txt")
+	_ = stores.Put(ctx, in, strings.NewReader("To be, or not to be:\nthat is the question. Don't"))
+
+	rec := httptest.NewRecorder()
+	mapHandler(stores, "mr/maps", testMemory(t))(rec, httptest.NewRequest(http.MethodGet, "/map?in="+in.String(), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var resp MapResponse
+	_ = json.NewDecoder(rec.Body).Decode(&resp)
+	out, err := storage.Parse(resp.Out)
+	if err != nil || !strings.HasPrefix(resp.Out, "mem://lab/mr/maps/") {
+		t.Fatalf("out %q (%v)", resp.Out, err)
+	}
+
+	counts := map[string]string{}
+	for _, kv := range readRecords(t, stores, out) {
+		counts[kv.Key] = kv.Value
+	}
+	if counts["to"] != "2" || counts["be"] != "2" || counts["don't"] != "1" || len(counts) != 9 {
+		t.Fatalf("counts = %v", counts)
+	}
+}
+
+func readRecords(t *testing.T, stores *storage.Stores, u storage.URL) []mr.KV {
+	t.Helper()
+	b, err := stores.ReadAll(context.Background(), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kvs, err := mr.ReadRecords(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kvs
+}
+
+func TestMapRunsNamedJob(t *testing.T) {
+	ctx := context.Background()
+	stores := storage.NewStores()
+	stores.Register("mem", storage.NewMemory())
+	in, _ := storage.Parse("mem://lab/split/task000-attempt2/chunk03.txt")
+	_ = stores.Put(ctx, in, strings.NewReader("to be or not to be"))
+
+	rec := httptest.NewRecorder()
+	mapHandler(stores, "mr/maps", testMemory(t))(rec, httptest.NewRequest(http.MethodGet, "/map?job=invertedindex&in="+in.String(), nil))
+	var resp MapResponse
+	_ = json.NewDecoder(rec.Body).Decode(&resp)
+	out, err := storage.Parse(resp.Out)
+	if rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	// The combiner leaves one record per word, naming the chunk by file name.
+	var want []mr.KV
+	for _, w := range []string{"be", "not", "or", "to"} {
+		want = append(want, mr.KV{Key: w, Value: "chunk03.txt"})
+	}
+	if got := readRecords(t, stores, out); !slices.Equal(got, want) {
+		t.Fatalf("records %v, want %v", got, want)
+	}
+
+	for _, q := range []string{"job=nope", "job=grep", "job=grep&arg=pattern=(", "arg=novalue"} {
+		rec := httptest.NewRecorder()
+		mapHandler(stores, "mr/maps", testMemory(t))(rec, httptest.NewRequest(http.MethodGet, "/map?in="+in.String()+"&"+url.PathEscape(q), nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status %d, want 400", q, rec.Code)
+		}
+	}
+}
+
+// testMemory is plenty of memory, spilling to a directory the test removes.
+func testMemory(t *testing.T) memoryConfig {
+	return memoryConfig{Limit: 64 << 20, Dir: t.TempDir()}
+}
+
+func TestMapSpillsUnderMemoryLimit(t *testing.T) {
+	ctx := context.Background()
+	stores := storage.NewStores()
+	stores.Register("mem", storage.NewMemory())
+	hamlet, err := os.ReadFile("../shakespeare-hamlet.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, _ := storage.Parse("mem://lab/hamlet.txt")
+	_ = stores.Put(ctx, in, bytes.NewReader(hamlet))
+
+	for _, job := range []string{"wordcount", "invertedindex", "grep&arg=pattern=Ham"} {
+		run := func(mem memoryConfig, out string) (MapResponse, []mr.KV) {
+			rec := httptest.NewRecorder()
+			mapHandler(stores, "mr/maps", mem)(rec, httptest.NewRequest(http.MethodGet, "/map?job="+job+"&in="+in.String()+"&out="+out, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("%s: status %d: %s", job, rec.Code, rec.Body)
+			}
+			var resp MapResponse
+			_ = json.NewDecoder(rec.Body).Decode(&resp)
+			u, _ := storage.Parse(resp.Out)
+			return resp, readRecords(t, stores, u)
+		}
+		_, want := run(testMemory(t), "mem://lab/big.mrb")
+		dir := t.TempDir()
+		resp, got := run(memoryConfig{Limit: 8 << 10, Dir: dir}, "mem://lab/small.mrb")
+
+		if resp.Spills == 0 {
+			t.Fatalf("%s: no spills under an 8KB limit", job)
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("%s: %d records with spilling, %d without, or they differ", job, len(got), len(want))
+		}
+		if !slices.IsSortedFunc(got, func(a, b mr.KV) int { return strings.Compare(a.Key, b.Key) }) {
+			t.Fatalf("%s: output not sorted by key", job)
+		}
+		if left, _ := os.ReadDir(dir); len(left) != 0 {
+			t.Fatalf("%s: %d temporary files left behind", job, len(left))
+		}
+	}
+}
+
+func TestMapMissingInput(t *testing.T) {
+	stores := storage.NewStores()
+	stores.Register("mem", storage.NewMemory())
+	rec := httptest.NewRecorder()
+	mapHandler(stores, "mr/maps", testMemory(t))(rec, httptest.NewRequest(http.MethodGet, "/map?in=mem://lab/nope.txt", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d, want 500", rec.Code)
+	}
+}
+
+func TestMapWritesWhereAsked(t *testing.T) {
+	ctx := context.Background()
+	stores := storage.NewStores()
+	stores.Register("mem", storage.NewMemory())
+	in, _ := storage.Parse("mem://lab/chunk.txt")
+	_ = stores.Put(ctx, in, strings.NewReader("to be"))
+
+	const out = "mem://lab/mr/jobs/j1/map/task001-attempt2.json"
+	rec := httptest.NewRecorder()
+	mapHandler(stores, "mr/maps", testMemory(t))(rec, httptest.NewRequest(http.MethodGet, "/map?in="+in.String()+"&out="+out, nil))
+	var resp MapResponse
+	_ = json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusOK || resp.Out != out {
+		t.Fatalf("status %d, out %q; want %s", rec.Code, resp.Out, out)
+	}
+	u, _ := storage.Parse(out)
+	if _, err := stores.ReadAll(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMapPartitionsByWord(t *testing.T) {
+	ctx := context.Background()
+	stores := storage.NewStores()
+	stores.Register("mem", storage.NewMemory())
+	in, _ := storage.Parse("mem://lab/chunk.txt")
+	_ = stores.Put(ctx, in, strings.NewReader("To be, or not to be: that is the question"))
+
+	rec := httptest.NewRecorder()
+	mapHandler(stores, "mr/maps", testMemory(t))(rec, httptest.NewRequest(http.MethodGet, "/map?in="+in.String()+"&partitions=3&out=mem://lab/m0/", nil))
+	var resp MapResponse
+	_ = json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusOK || len(resp.Partitions) != 3 || resp.Out != "" {
+		t.Fatalf("status %d, response %+v", rec.Code, resp)
+	}
+
+	all := map[string]string{}
+	for i, p := range resp.Partitions {
+		if want := fmt.Sprintf("mem://lab/m0/part-%05d.mrb", i); p != want {
+			t.Fatalf("partition %d at %s, want %s", i, p, want)
+		}
+		u, _ := storage.Parse(p)
+		for _, kv := range readRecords(t, stores, u) {
+			if partition(kv.Key, 3) != i {
+				t.Fatalf("%q in partition %d, belongs in %d", kv.Key, i, partition(kv.Key, 3))
+			}
+			all[kv.Key] = kv.Value
+		}
+	}
+	if all["to"] != "2" || all["be"] != "2" || len(all) != 8 {
+		t.Fatalf("partitions add up to %v", all)
+	}
+}
+
+func TestMapWritesEachFormat(t *testing.T) {
+	ctx := context.Background()
+	stores := storage.NewStores()
+	stores.Register("mem", storage.NewMemory())
+	hamlet, err := os.ReadFile("../shakespeare-hamlet.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, _ := storage.Parse("mem://lab/hamlet.txt")
+	_ = stores.Put(ctx, in, bytes.NewReader(hamlet))
+
+	var want []mr.KV
+	sizes := map[string]int64{}
+	for _, q := range []string{"format=json", "format=binary", "compression=snappy", "format=binary&compression=zstd"} {
+		rec := httptest.NewRecorder()
+		mapHandler(stores, "mr/maps", testMemory(t))(rec, httptest.NewRequest(http.MethodGet, "/map?in="+in.String()+"&"+q, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", q, rec.Code, rec.Body)
+		}
+		var resp MapResponse
+		_ = json.NewDecoder(rec.Body).Decode(&resp)
+		u, _ := storage.Parse(resp.Out)
+		got := readRecords(t, stores, u)
+		if want == nil {
+			want = got
+		} else if !slices.Equal(got, want) {
+			t.Fatalf("%s: %d records differ from JSON's %d", q, len(got), len(want))
+		}
+		if b, _ := stores.ReadAll(ctx, u); int64(len(b)) != resp.Bytes {
+			t.Fatalf("%s: %d bytes written, response says %d", q, len(b), resp.Bytes)
+		}
+		sizes[q] = resp.Bytes
+	}
+	if !(sizes["format=binary&compression=zstd"] < sizes["format=binary"] && sizes["format=binary"] < sizes["format=json"]) {
+		t.Fatalf("sizes %v: want zstd < binary < json", sizes)
+	}
+
+	for _, q := range []string{"format=xml", "compression=lz4", "format=json&compression=zstd"} {
+		rec := httptest.NewRecorder()
+		mapHandler(stores, "mr/maps", testMemory(t))(rec, httptest.NewRequest(http.MethodGet, "/map?in="+in.String()+"&"+q, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status %d, want 400", q, rec.Code)
+		}
+	}
+}
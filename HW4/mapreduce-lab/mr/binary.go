@@ -0,0 +1,379 @@
+package mr
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// The binary record format is smaller and faster to read than JSON lines.
+// A file starts with a header, the magic "MRKV", a version byte and a
+// compression byte, followed by blocks of records:
+//
+//	stored length   uint32, little-endian
+//	raw length      uint32, the length once decompressed
+//	checksum        uint32, CRC-32C of the stored bytes
+//	stored bytes    the records, compressed as the header says
+//
+// Each record in a block is its key and then its value, each preceded by
+// its length as a uvarint. A block holds about blockSize bytes of records;
+// a longer record gets a block of its own. The file ends with a trailer, a
+// block header with stored length 0 whose other 8 bytes are the number of
+// records in the file (uint64, little-endian); nothing may follow it.
+//
+// The checksums catch a corrupted block, and the trailer a file that was
+// truncated, even exactly between two blocks.
+//
+// NewRecordReader tells the two formats apart by the magic, which JSON
+// lines can't start with, so a reducer reads either without being told.
+
+const (
+	magic         = "MRKV"
+	formatVersion = 2
+	blockSize     = 64 << 10
+	maxBlock      = 1 << 30 // larger lengths mean a corrupt file
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Compression is how the binary format compresses its blocks.
+type Compression byte
+
+const (
+	NoCompression Compression = iota
+	Snappy
+	Zstd
+)
+
+func (c Compression) String() string {
+	switch c {
+	case NoCompression:
+		return "none"
+	case Snappy:
+		return "snappy"
+	case Zstd:
+		return "zstd"
+	}
+	return fmt.Sprintf("Compression(%d)", byte(c))
+}
+
+// Encoding is how intermediate records are written: JSON lines, or the
+// binary format with optional compression.
+type Encoding struct {
+	Binary      bool
+	Compression Compression // binary only
+}
+
+// DefaultEncoding is the binary format, uncompressed.
+var DefaultEncoding = Encoding{Binary: true}
+
+// ParseEncoding reads an encoding from its format ("json" or "binary", ""
+// for binary) and compression ("none", "snappy" or "zstd", "" for none).
+func ParseEncoding(format, compression string) (Encoding, error) {
+	var e Encoding
+	switch format {
+	case "", "binary":
+		e.Binary = true
+	case "json":
+	default:
+		return Encoding{}, fmt.Errorf("unknown format %q (have binary, json)", format)
+	}
+	switch compression {
+	case "", "none":
+	case "snappy":
+		e.Compression = Snappy
+	case "zstd":
+		e.Compression = Zstd
+	default:
+		return Encoding{}, fmt.Errorf("unknown compression %q (have none, snappy, zstd)", compression)
+	}
+	if !e.Binary && e.Compression != NoCompression {
+		return Encoding{}, errors.New("compression needs the binary format")
+	}
+	return e, nil
+}
+
+// Format is the name ParseEncoding reads back.
+func (e Encoding) Format() string {
+	if e.Binary {
+		return "binary"
+	}
+	return "json"
+}
+
+// Ext is the file extension for records in e.
+func (e Encoding) Ext() string {
+	if e.Binary {
+		return ".mrb"
+	}
+	return ".jsonl"
+}
+
+// NewWriter returns a writer of records in e.
+func (e Encoding) NewWriter(w io.Writer) RecordWriter {
+	if e.Binary {
+		return NewBinaryWriter(w, e.Compression)
+	}
+	return NewRecordWriter(w)
+}
+
+// binaryWriter writes the binary format.
+type binaryWriter struct {
+	w      io.Writer
+	c      Compression
+	header bool
+	block  []byte
+	stored []byte
+	n      uint64 // records written
+	done   bool   // trailer written
+	err    error
+}
+
+// NewBinaryWriter writes records in the binary format, compressing blocks
+// with c. Call Flush once, when done: it ends the file.
+func NewBinaryWriter(w io.Writer, c Compression) RecordWriter {
+	return &binaryWriter{w: w, c: c}
+}
+
+func (w *binaryWriter) Write(kv KV) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.done {
+		return errors.New("binary records: write after Flush")
+	}
+	w.n++
+	w.block = binary.AppendUvarint(w.block, uint64(len(kv.Key)))
+	w.block = append(w.block, kv.Key...)
+	w.block = binary.AppendUvarint(w.block, uint64(len(kv.Value)))
+	w.block = append(w.block, kv.Value...)
+	if len(w.block) >= blockSize {
+		w.err = w.writeBlock()
+	}
+	return w.err
+}
+
+// Flush writes the last block and the trailer. A writer given no records
+// still writes the header and trailer, so an empty file is known to be
+// binary and complete. Flushing again does nothing.
+func (w *binaryWriter) Flush() error {
+	if w.err != nil || w.done {
+		return w.err
+	}
+	if w.err = w.writeBlock(); w.err != nil {
+		return w.err
+	}
+	var trailer [12]byte // stored length 0, then the record count
+	binary.LittleEndian.PutUint64(trailer[4:], w.n)
+	if _, w.err = w.w.Write(trailer[:]); w.err != nil {
+		return w.err
+	}
+	w.done = true
+	return nil
+}
+
+func (w *binaryWriter) writeBlock() error {
+	if !w.header {
+		if _, err := w.w.Write([]byte{magic[0], magic[1], magic[2], magic[3], formatVersion, byte(w.c)}); err != nil {
+			return err
+		}
+		w.header = true
+	}
+	if len(w.block) == 0 {
+		return nil
+	}
+	stored, err := compress(w.c, w.stored[:0], w.block)
+	if err != nil {
+		return err
+	}
+	var hdr [12]byte
+	binary.LittleEndian.PutUint32(hdr[0:], uint32(len(stored)))
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(len(w.block)))
+	binary.LittleEndian.PutUint32(hdr[8:], crc32.Checksum(stored, castagnoli))
+	if _, err := w.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	if _, err := w.w.Write(stored); err != nil {
+		return err
+	}
+	w.block, w.stored = w.block[:0], stored[:0]
+	return nil
+}
+
+// binaryReader reads the binary format, a block at a time.
+type binaryReader struct {
+	r      *bufio.Reader
+	c      Compression
+	header bool
+	stored []byte
+	block  []byte // what is left of the current block
+	raw    []byte
+	n      int
+	done   bool // trailer read
+}
+
+func (r *binaryReader) Read() (KV, error) {
+	if r.done {
+		return KV{}, io.EOF
+	}
+	if !r.header {
+		if err := r.readHeader(); err != nil {
+			return KV{}, err
+		}
+	}
+	for len(r.block) == 0 {
+		if err := r.readBlock(); err != nil {
+			return KV{}, err
+		}
+	}
+	key, ok := r.field()
+	value, ok2 := r.field()
+	if !ok || !ok2 {
+		return KV{}, fmt.Errorf("record %d: %w: truncated in its block", r.n+1, ErrBadRecord)
+	}
+	r.n++
+	return KV{key, value}, nil
+}
+
+// field takes one length-prefixed string off the current block.
+func (r *binaryReader) field() (string, bool) {
+	n, size := binary.Uvarint(r.block)
+	if size <= 0 || n > uint64(len(r.block)-size) {
+		return "", false
+	}
+	s := string(r.block[size : size+int(n)])
+	r.block = r.block[size+int(n):]
+	return s, true
+}
+
+func (r *binaryReader) readHeader() error {
+	var hdr [6]byte
+	if _, err := io.ReadFull(r.r, hdr[:]); err != nil {
+		return r.bad(err)
+	}
+	if string(hdr[:4]) != magic {
+		return fmt.Errorf("%w: not binary records", ErrBadRecord)
+	}
+	if hdr[4] != formatVersion {
+		return fmt.Errorf("%w: binary format version %d, want %d", ErrBadRecord, hdr[4], formatVersion)
+	}
+	r.c = Compression(hdr[5])
+	if r.c > Zstd {
+		return fmt.Errorf("%w: unknown compression %d", ErrBadRecord, hdr[5])
+	}
+	r.header = true
+	return nil
+}
+
+func (r *binaryReader) readBlock() error {
+	var hdr [12]byte
+	if _, err := io.ReadFull(r.r, hdr[:]); err != nil {
+		return r.bad(err)
+	}
+	storedLen := binary.LittleEndian.Uint32(hdr[0:])
+	if storedLen == 0 {
+		return r.readTrailer(binary.LittleEndian.Uint64(hdr[4:]))
+	}
+	rawLen := binary.LittleEndian.Uint32(hdr[4:])
+	if storedLen > maxBlock || rawLen > maxBlock {
+		return fmt.Errorf("record %d: %w: block of %d bytes", r.n+1, ErrBadRecord, max(storedLen, rawLen))
+	}
+	if cap(r.stored) < int(storedLen) {
+		r.stored = make([]byte, storedLen)
+	}
+	r.stored = r.stored[:storedLen]
+	if _, err := io.ReadFull(r.r, r.stored); err != nil {
+		return r.bad(err)
+	}
+	if crc32.Checksum(r.stored, castagnoli) != binary.LittleEndian.Uint32(hdr[8:]) {
+		return fmt.Errorf("record %d: %w: block checksum mismatch", r.n+1, ErrBadRecord)
+	}
+	raw, err := decompress(r.c, r.raw[:0], r.stored, int(rawLen))
+	if err != nil || len(raw) != int(rawLen) {
+		return fmt.Errorf("record %d: %w: block does not decompress to %d bytes (%v)", r.n+1, ErrBadRecord, rawLen, err)
+	}
+	r.raw, r.block = raw, raw
+	return nil
+}
+
+// readTrailer checks the trailer's record count and that the file ends
+// there, then reports io.EOF.
+func (r *binaryReader) readTrailer(count uint64) error {
+	if count != uint64(r.n) {
+		return fmt.Errorf("%w: trailer counts %d records, file has %d", ErrBadRecord, count, r.n)
+	}
+	if _, err := r.r.ReadByte(); !errors.Is(err, io.EOF) {
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: data after the trailer", ErrBadRecord)
+	}
+	r.done = true
+	return io.EOF
+}
+
+// bad turns input that ended mid-block or before the trailer into
+// ErrBadRecord: the file itself is short. A read that failed, including
+// one the store cut off in transit, passes through.
+func (r *binaryReader) bad(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("record %d: %w: file is truncated", r.n+1, ErrBadRecord)
+	}
+	return fmt.Errorf("record %d: %w", r.n+1, err)
+}
+
+// zstd encoders and decoders are costly to make and safe to share.
+var (
+	zstdOnce sync.Once
+	zstdEnc  *zstd.Encoder
+	zstdDec  *zstd.Decoder
+	zstdErr  error
+)
+
+func zstdCodec() (*zstd.Encoder, *zstd.Decoder, error) {
+	zstdOnce.Do(func() {
+		if zstdEnc, zstdErr = zstd.NewWriter(nil); zstdErr != nil {
+			return
+		}
+		zstdDec, zstdErr = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxBlock))
+	})
+	return zstdEnc, zstdDec, zstdErr
+}
+
+func compress(c Compression, dst, src []byte) ([]byte, error) {
+	switch c {
+	case Snappy:
+		return snappy.Encode(dst[:cap(dst)], src), nil
+	case Zstd:
+		enc, _, err := zstdCodec()
+		if err != nil {
+			return nil, err
+		}
+		return enc.EncodeAll(src, dst), nil
+	}
+	return append(dst, src...), nil
+}
+
+func decompress(c Compression, dst, src []byte, rawLen int) ([]byte, error) {
+	switch c {
+	case Snappy:
+		if n, err := snappy.DecodedLen(src); err != nil || n != rawLen {
+			return nil, fmt.Errorf("snappy length %d: %v", n, err)
+		}
+		return snappy.Decode(dst[:cap(dst)], src)
+	case Zstd:
+		_, dec, err := zstdCodec()
+		if err != nil {
+			return nil, err
+		}
+		return dec.DecodeAll(src, dst)
+	}
+	return append(dst, src...), nil
+}
@@ -0,0 +1,152 @@
+package mr
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The word jobs (all but grep) take the Analyzer's arguments, which pick
+// how text is split into words; see NewAnalyzer.
+func init() {
+	Register("wordcount", func(args Args) (*Job, error) {
+		a, err := NewAnalyzer(args)
+		if err != nil {
+			return nil, err
+		}
+		return &Job{Map: countWords(a), Combine: sum, Reduce: sum}, nil
+	})
+	Register("invertedindex", func(args Args) (*Job, error) {
+		a, err := NewAnalyzer(args)
+		if err != nil {
+			return nil, err
+		}
+		return &Job{Map: indexWords(a), Combine: distinctValues, Reduce: docList}, nil
+	})
+	Register("grep", newGrep)
+	Register("distinct", func(args Args) (*Job, error) {
+		a, err := NewAnalyzer(args)
+		if err != nil {
+			return nil, err
+		}
+		return &Job{Map: emitWords(a), Combine: once, Reduce: present}, nil
+	})
+	Register("topk", newTopK)
+}
+
+// countWords emits (word, 1) for every word.
+func countWords(a *Analyzer) MapFunc {
+	return func(doc, text string, emit Emit) {
+		a.Terms(text, func(w string) { emit(w, "1") })
+	}
+}
+
+// sum adds up integer values.
+func sum(key string, values []string, emit Emit) error {
+	total := 0
+	for _, v := range values {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("value %q is not a count", v)
+		}
+		total += n
+	}
+	emit(key, strconv.Itoa(total))
+	return nil
+}
+
+// indexWords emits (word, doc) for every word in the chunk.
+func indexWords(a *Analyzer) MapFunc {
+	return func(doc, text string, emit Emit) {
+		a.Terms(text, func(w string) { emit(w, doc) })
+	}
+}
+
+// distinctValues emits each of key's values once.
+func distinctValues(key string, values []string, emit Emit) error {
+	for _, v := range dedup(values) {
+		emit(key, v)
+	}
+	return nil
+}
+
+// docList emits the sorted documents key appears in as a JSON list.
+func docList(key string, values []string, emit Emit) error {
+	b, err := json.Marshal(dedup(values))
+	if err != nil {
+		return err
+	}
+	emit(key, string(b))
+	return nil
+}
+
+func dedup(values []string) []string {
+	values = slices.Clone(values)
+	slices.Sort(values)
+	return slices.Compact(values)
+}
+
+// newGrep counts the lines matching the regular expression arg pattern.
+// The output maps each matching line to how many times it occurs.
+func newGrep(args Args) (*Job, error) {
+	if args["pattern"] == "" {
+		return nil, fmt.Errorf("missing arg pattern")
+	}
+	re, err := regexp.Compile(args["pattern"])
+	if err != nil {
+		return nil, fmt.Errorf("arg pattern: %w", err)
+	}
+	grep := func(doc, text string, emit Emit) {
+		// A final "\n" ends the last line; it does not start an empty one.
+		for text != "" {
+			line, rest, _ := strings.Cut(text, "\n")
+			text = rest
+			line = strings.TrimRight(line, "\r")
+			if re.MatchString(line) {
+				emit(line, "1")
+			}
+		}
+	}
+	return &Job{Map: grep, Combine: sum, Reduce: sum}, nil
+}
+
+// emitWords emits every word with no value.
+func emitWords(a *Analyzer) MapFunc {
+	return func(doc, text string, emit Emit) {
+		a.Terms(text, func(w string) { emit(w, "") })
+	}
+}
+
+// once emits key once, whatever its values.
+func once(key string, values []string, emit Emit) error {
+	emit(key, "")
+	return nil
+}
+
+// present marks key as seen in the final output.
+func present(key string, values []string, emit Emit) error {
+	emit(key, "true")
+	return nil
+}
+
+// newTopK counts words and keeps the arg k (default 10) most frequent.
+// Each reducer keeps the top k of its own partition; a word's count is
+// whole within its partition, so merging the reducers' outputs and taking
+// the top k again gives the overall top k.
+func newTopK(args Args) (*Job, error) {
+	k, err := args.Int("k", 10)
+	if err != nil {
+		return nil, err
+	}
+	if k < 1 || k > 10000 {
+		return nil, fmt.Errorf("arg k must be 1..10000, got %d", k)
+	}
+	a, err := NewAnalyzer(args)
+	if err != nil {
+		return nil, err
+	}
+	return &Job{Map: countWords(a), Combine: sum, Reduce: sum, Top: k}, nil
+}
@@ -0,0 +1,194 @@
+// Package mr defines the jobs the MapReduce services run. A job is a map
+// function, an optional combine function and a reduce function, registered
+// under a name; the mapper and reducer look up the job a request names and
+// run its functions over key/value records.
+//
+// Map turns a chunk of text into records. Combine, if the job has one, runs
+// over each map task's records, key by key, before they are written, so
+// less goes over the wire. Reduce gets every value of one key from all the
+// map tasks and emits the final records. Intermediate values are plain
+// strings; the values Reduce emits are JSON text, so the final output can
+// hold numbers and lists as well as strings.
+package mr
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// KV is one key/value record.
+type KV struct {
+	Key   string `json:"k"`
+	Value string `json:"v"`
+}
+
+// Emit adds a record to a function's output.
+type Emit func(key, value string)
+
+// MapFunc emits records for text, a chunk of the document doc.
+type MapFunc func(doc, text string, emit Emit)
+
+// ReduceFunc emits records for key, given all its values. It must not keep
+// values after it returns.
+type ReduceFunc func(key string, values []string, emit Emit) error
+
+// Job is a map/reduce computation.
+type Job struct {
+	Map     MapFunc
+	Combine ReduceFunc // optional
+	Reduce  ReduceFunc
+	// Top, if > 0, keeps only the Top records with the largest values,
+	// which must be numbers, most first and ties in key order (top-K). Each
+	// reducer keeps the top of its partition, and merging several reducers'
+	// outputs takes the top of those again.
+	Top int
+}
+
+// Args are a job's parameters, e.g. grep's pattern.
+type Args map[string]string
+
+// Int is the integer argument name, or def if it is not given.
+func (a Args) Int(name string, def int) (int, error) {
+	v, ok := a[name]
+	if !ok {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("arg %s: %q is not an integer", name, v)
+	}
+	return n, nil
+}
+
+// Def builds a job from its arguments, rejecting bad ones.
+type Def func(Args) (*Job, error)
+
+var (
+	mu   sync.RWMutex
+	defs = make(map[string]Def)
+)
+
+// Register makes def available as name. It panics if name is taken.
+func Register(name string, def Def) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := defs[name]; ok {
+		panic("mr: job " + name + " registered twice")
+	}
+	defs[name] = def
+}
+
+// Lookup builds the job registered as name.
+func Lookup(name string, args Args) (*Job, error) {
+	mu.RLock()
+	def, ok := defs[name]
+	mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown job %q (have %s)", name, strings.Join(Names(), ", "))
+	}
+	j, err := def(args)
+	if err != nil {
+		return nil, fmt.Errorf("job %s: %w", name, err)
+	}
+	return j, nil
+}
+
+// Names lists the registered jobs.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(defs))
+	for name := range defs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DefaultJob is the job a request that names none runs.
+const DefaultJob = "wordcount"
+
+// FromQuery looks up the job a service request names: ?job=<name>, with
+// each argument as ?arg=<name>=<value>.
+func FromQuery(q url.Values) (string, *Job, error) {
+	name := q.Get("job")
+	if name == "" {
+		name = DefaultJob
+	}
+	args := Args{}
+	for _, a := range q["arg"] {
+		k, v, ok := strings.Cut(a, "=")
+		if !ok || k == "" {
+			return "", nil, fmt.Errorf("invalid arg %q, expected name=value", a)
+		}
+		args[k] = v
+	}
+	j, err := Lookup(name, args)
+	return name, j, err
+}
+
+// Query is the query FromQuery reads name and args back from.
+func Query(name string, args Args) url.Values {
+	q := url.Values{"job": {name}}
+	keys := make([]string, 0, len(args))
+	for k := range args {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		q.Add("arg", k+"="+args[k])
+	}
+	return q
+}
+
+// RunMap maps text and, if the job has a combiner, combines the result.
+// The records come back sorted by key.
+func (j *Job) RunMap(doc, text string) ([]KV, error) {
+	var kvs []KV
+	j.Map(doc, text, func(k, v string) { kvs = append(kvs, KV{k, v}) })
+	Sort(kvs)
+	if j.Combine == nil {
+		return kvs, nil
+	}
+	return Group(kvs, j.Combine)
+}
+
+// RunReduce reduces records from any number of map tasks, then keeps the
+// job's Top.
+func (j *Job) RunReduce(kvs []KV) ([]KV, error) {
+	Sort(kvs)
+	out, err := Group(kvs, j.Reduce)
+	if err != nil || j.Top == 0 {
+		return out, err
+	}
+	var top topHeap
+	for _, kv := range out {
+		c, err := count(kv)
+		if err != nil {
+			return nil, err
+		}
+		top.push(counted{kv, c}, j.Top)
+	}
+	return top.sorted(), nil
+}
+
+// Sort sorts kvs by key, keeping the order of each key's values.
+func Sort(kvs []KV) {
+	slices.SortStableFunc(kvs, func(a, b KV) int { return strings.Compare(a.Key, b.Key) })
+}
+
+// Group calls fn once per key of kvs, which must be sorted, with all of
+// that key's values, and returns what it emits.
+func Group(kvs []KV, fn ReduceFunc) ([]KV, error) {
+	var out []KV
+	err := GroupSource(SliceSource(kvs), fn, func(kv KV) error {
+		out = append(out, kv)
+		return nil
+	})
+	return out, err
+}
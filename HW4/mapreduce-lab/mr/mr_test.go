@@ -0,0 +1,383 @@
+package mr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const text = "To be, or not to be:\nthat is the question.\nTo sleep, perchance to dream"
+
+// run maps each chunk as its own document and reduces them together.
+func run(t *testing.T, name string, args Args, chunks ...string) map[string]string {
+	t.Helper()
+	j, err := Lookup(name, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kvs []KV
+	for i, c := range chunks {
+		out, err := j.RunMap("doc"+string(rune('0'+i)), c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kvs = append(kvs, out...)
+	}
+	final, err := j.RunReduce(kvs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := make(map[string]string, len(final))
+	for _, kv := range final {
+		m[kv.Key] = kv.Value
+	}
+	return m
+}
+
+func TestBuiltinJobs(t *testing.T) {
+	lines := strings.SplitAfter(text, "\n")
+
+	wc := run(t, "wordcount", nil, lines...)
+	if wc["to"] != "4" || wc["be"] != "2" || wc["question"] != "1" || len(wc) != 11 {
+		t.Fatalf("wordcount = %v", wc)
+	}
+
+	idx := run(t, "invertedindex", nil, lines...)
+	if idx["to"] != `["doc0","doc2"]` || idx["question"] != `["doc1"]` {
+		t.Fatalf("invertedindex = %v", idx)
+	}
+
+	grep := run(t, "grep", Args{"pattern": `(?i)^to`}, lines...)
+	if len(grep) != 2 || grep["To be, or not to be:"] != "1" || grep["To sleep, perchance to dream"] != "1" {
+		t.Fatalf("grep = %v", grep)
+	}
+	// Every chunk ends in a line break, which is not a blank line of its own.
+	blank := run(t, "grep", Args{"pattern": `^$`}, "a\n\nb\n", "\r\nc\n", "d")
+	if len(blank) != 1 || blank[""] != "2" {
+		t.Fatalf("blank lines = %v, want 2", blank)
+	}
+
+	distinct := run(t, "distinct", nil, lines...)
+	if len(distinct) != 11 || distinct["dream"] != "true" {
+		t.Fatalf("distinct = %v", distinct)
+	}
+
+	top := run(t, "topk", Args{"k": "2"}, lines...)
+	if len(top) != 2 || top["to"] != "4" || top["be"] != "2" {
+		t.Fatalf("topk = %v", top)
+	}
+}
+
+func TestLookupRejectsBadJobs(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		args Args
+	}{
+		{"nope", nil},
+		{"grep", nil},
+		{"grep", Args{"pattern": "("}},
+		{"topk", Args{"k": "0"}},
+		{"topk", Args{"k": "ten"}},
+		{"wordcount", Args{"tokenizer": "nope"}},
+		{"invertedindex", Args{"stem": "klingon"}},
+		{"distinct", Args{"ngrams": "0"}},
+		{"topk", Args{"ngrams": "6"}},
+	} {
+		if _, err := Lookup(c.name, c.args); err == nil {
+			t.Fatalf("Lookup(%s, %v) = nil error", c.name, c.args)
+		}
+	}
+}
+
+func TestQueryRoundTrip(t *testing.T) {
+	name, j, err := FromQuery(Query("topk", Args{"k": "3"}))
+	if err != nil || name != "topk" || j.Top != 3 {
+		t.Fatalf("FromQuery = %s, %v, %v", name, j, err)
+	}
+	if name, _, err := FromQuery(nil); err != nil || name != DefaultJob {
+		t.Fatalf("FromQuery(nil) = %s, %v", name, err)
+	}
+}
+
+func TestRecordsRoundTrip(t *testing.T) {
+	kvs := []KV{{"be", "2"}, {"line\nbreak", `"quoted"`}, {"to", ""}, {"", "empty key"}}
+	// Enough records for several blocks, and one record bigger than a block.
+	for i := 0; i < 20000; i++ {
+		kvs = append(kvs, KV{"word" + strconv.Itoa(i), strconv.Itoa(i % 7)})
+	}
+	kvs = append(kvs, KV{"long", strings.Repeat("ab", blockSize)})
+
+	for _, e := range []Encoding{{}, {Binary: true}, {Binary: true, Compression: Snappy}, {Binary: true, Compression: Zstd}} {
+		var buf bytes.Buffer
+		w := e.NewWriter(&buf)
+		for _, kv := range kvs {
+			if err := w.Write(kv); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		data := buf.Bytes()
+		got, err := ReadRecords(bytes.NewReader(data))
+		if err != nil || !slices.Equal(got, kvs) {
+			t.Fatalf("%s/%s: ReadRecords = %d records, %v; want %d", e.Format(), e.Compression, len(got), err, len(kvs))
+		}
+		if !e.Binary {
+			continue
+		}
+
+		// A flipped byte fails its block's checksum, and a cut-off file is
+		// caught too, rather than read as fewer records: whether it ends
+		// mid-block or exactly between blocks, down to the header alone.
+		bad := slices.Clone(data)
+		bad[len(bad)/2] ^= 0xff
+		if _, err := ReadRecords(bytes.NewReader(bad)); !errors.Is(err, ErrBadRecord) {
+			t.Fatalf("%s: corrupt file: %v, want ErrBadRecord", e.Compression, err)
+		}
+		cuts := []int{len(data) - 1}
+		for off := len(magic) + 2; off < len(data); off += 12 + int(binary.LittleEndian.Uint32(data[off:])) {
+			cuts = append(cuts, off) // the start of each block and of the trailer
+		}
+		if len(cuts) < 4 {
+			t.Fatalf("%s: only %d blocks", e.Compression, len(cuts)-2)
+		}
+		for _, n := range cuts {
+			if _, err := ReadRecords(bytes.NewReader(data[:n])); !errors.Is(err, ErrBadRecord) {
+				t.Fatalf("%s: file cut at %d of %d bytes: %v, want ErrBadRecord", e.Compression, n, len(data), err)
+			}
+		}
+		if _, err := ReadRecords(bytes.NewReader(append(slices.Clone(data), 0))); !errors.Is(err, ErrBadRecord) {
+			t.Fatalf("%s: data after the trailer: %v, want ErrBadRecord", e.Compression, err)
+		}
+	}
+
+	var empty bytes.Buffer
+	if err := NewBinaryWriter(&empty, Zstd).Flush(); err != nil || empty.Len() == 0 {
+		t.Fatalf("empty binary file: %d bytes, %v", empty.Len(), err)
+	}
+	if got, err := ReadRecords(&empty); err != nil || len(got) != 0 {
+		t.Fatalf("ReadRecords(empty) = %v, %v", got, err)
+	}
+}
+
+func TestParseEncoding(t *testing.T) {
+	if e, err := ParseEncoding("", ""); err != nil || e != DefaultEncoding {
+		t.Fatalf("ParseEncoding default = %+v, %v", e, err)
+	}
+	if e, err := ParseEncoding("binary", "zstd"); err != nil || e != (Encoding{Binary: true, Compression: Zstd}) {
+		t.Fatalf("ParseEncoding(binary, zstd) = %+v, %v", e, err)
+	}
+	for _, c := range [][2]string{{"xml", ""}, {"binary", "lz4"}, {"json", "snappy"}} {
+		if _, err := ParseEncoding(c[0], c[1]); err == nil {
+			t.Fatalf("ParseEncoding(%s, %s) = nil error", c[0], c[1])
+		}
+	}
+}
+
+func TestWriteObjectMatchesMarshalIndent(t *testing.T) {
+	counts := map[string]int{"be": 2, "don't": 1, "to": 2, "<&>": 1}
+	var kvs []KV
+	for k, v := range counts {
+		b, _ := json.Marshal(v)
+		kvs = append(kvs, KV{k, string(b)})
+	}
+	Sort(kvs)
+
+	var buf bytes.Buffer
+	if err := WriteObject(&buf, kvs); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := json.MarshalIndent(counts, "", "  ")
+	if buf.String() != string(want) {
+		t.Fatalf("WriteObject =\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	back, err := ReadObject(&buf)
+	if err != nil || !slices.Equal(back, kvs) {
+		t.Fatalf("ReadObject = %v, %v; want %v", back, err, kvs)
+	}
+
+	if err := WriteObject(&bytes.Buffer{}, []KV{{"k", "not json"}}); err == nil {
+		t.Fatal("non-JSON value written")
+	}
+}
+
+func TestSpillerCombinesAcrossRuns(t *testing.T) {
+	j, err := Lookup("wordcount", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	sp := NewSpiller(j.Combine, 256, dir)
+	want := make(map[string]int)
+	for i := 0; i < 2000; i++ {
+		w := string(rune('a'+i%7)) + strings.Repeat("x", i%50)
+		sp.Add(w, "1")
+		want[w]++
+	}
+	src, err := sp.Sorted()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []KV
+	for {
+		kv, err := src.Read()
+		if err != nil {
+			break
+		}
+		got = append(got, kv)
+	}
+	if err := sp.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if sp.Stats().Spills == 0 {
+		t.Fatalf("stats = %+v, want spills", sp.Stats())
+	}
+	if len(got) != len(want) || !slices.IsSortedFunc(got, func(a, b KV) int { return strings.Compare(a.Key, b.Key) }) {
+		t.Fatalf("got %d records, want %d, sorted", len(got), len(want))
+	}
+	for _, kv := range got {
+		if kv.Value != strconv.Itoa(want[kv.Key]) {
+			t.Fatalf("%s = %s, want %d", kv.Key, kv.Value, want[kv.Key])
+		}
+	}
+	if left, _ := os.ReadDir(dir); len(left) != 0 {
+		t.Fatalf("runs left behind: %v", left)
+	}
+}
+
+func TestMergeRejectsUnsortedInput(t *testing.T) {
+	m, err := Merge(SliceSource([]KV{{"a", "1"}, {"c", "1"}}), SliceSource([]KV{{"b", "1"}, {"a", "1"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		_, err = m.Read()
+		if err != nil {
+			break
+		}
+	}
+	if !errors.Is(err, ErrBadRecord) {
+		t.Fatalf("Merge of unsorted input: %v, want ErrBadRecord", err)
+	}
+
+	if _, err := ReadRecords(strings.NewReader("{\"k\":\"a\"\n")); !errors.Is(err, ErrBadRecord) {
+		t.Fatalf("ReadRecords of truncated input: %v, want ErrBadRecord", err)
+	}
+}
+
+// reduceTo runs name over text and writes its output as o says, sorting
+// through sp.
+func reduceTo(t *testing.T, name string, args Args, o Output, sp *Spiller) (string, error) {
+	t.Helper()
+	j, err := Lookup(name, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kvs, err := j.RunMap("doc", text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	_, err = j.ReduceTo(&buf, SliceSource(kvs), o, sp)
+	return buf.String(), err
+}
+
+func TestOutputOrdersAndFormats(t *testing.T) {
+	// to 4, be 2, then nine words once each.
+	byCount := []string{"to", "be", "dream", "is", "not", "or", "perchance", "question", "sleep", "that", "the"}
+	byKey := slices.Clone(byCount)
+	slices.Sort(byKey)
+
+	for _, c := range []struct {
+		order string
+		top   int
+		limit int64 // spill limit for the sort by count
+		want  []string
+	}{
+		{"", 0, 0, byKey},
+		{"key", 2, 0, []string{"be", "dream"}},
+		{"count", 0, 0, byCount},
+		{"count", 0, 64, byCount},
+		{"", 3, 0, byCount[:3]},
+	} {
+		o, err := ParseOutput(c.order, c.top, "pairs")
+		if err != nil {
+			t.Fatal(err)
+		}
+		dir := t.TempDir()
+		sp := NewSpiller(nil, c.limit, dir)
+		out, err := reduceTo(t, "wordcount", nil, o, sp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.limit > 0 && sp.Stats().Spills == 0 {
+			t.Fatalf("order=%s limit=%d: no spills", c.order, c.limit)
+		}
+		sp.Close()
+		var pairs [][2]any
+		if err := json.Unmarshal([]byte(out), &pairs); err != nil {
+			t.Fatalf("pairs %s: %v", out, err)
+		}
+		var keys []string
+		for _, p := range pairs {
+			keys = append(keys, p[0].(string))
+		}
+		if !slices.Equal(keys, c.want) {
+			t.Fatalf("order=%q top=%d: %v, want %v", c.order, c.top, keys, c.want)
+		}
+	}
+
+	// topk orders by count; order=key puts it back in key order.
+	o, _ := ParseOutput("key", 0, "tsv")
+	if out, err := reduceTo(t, "topk", Args{"k": "2"}, o, nil); err != nil || out != "be\t2\nto\t4\n" {
+		t.Fatalf("topk by key = %q, %v", out, err)
+	}
+	// ?top= cuts the job's top k after ordering it.
+	o, _ = ParseOutput("key", 1, "tsv")
+	if out, err := reduceTo(t, "topk", Args{"k": "3"}, o, nil); err != nil || out != "be\t2\n" {
+		t.Fatalf("topk k=3 by key, top 1 = %q, %v", out, err)
+	}
+	o, _ = ParseOutput("", 2, "tsv")
+	if out, err := reduceTo(t, "topk", Args{"k": "3"}, o, nil); err != nil || out != "to\t4\nbe\t2\n" {
+		t.Fatalf("topk k=3, top 2 = %q, %v", out, err)
+	}
+	o, _ = ParseOutput("", 0, "tsv")
+	if out, err := reduceTo(t, "grep", Args{"pattern": "^that"}, o, nil); err != nil || out != "that is the question.\t1\n" {
+		t.Fatalf("grep tsv = %q, %v", out, err)
+	}
+	o, _ = ParseOutput("count", 0, "")
+	if _, err := reduceTo(t, "invertedindex", nil, o, nil); !errors.Is(err, ErrNotNumeric) {
+		t.Fatalf("invertedindex by count: %v, want ErrNotNumeric", err)
+	}
+
+	for _, c := range [][2]string{{"size", ""}, {"", "xml"}} {
+		if _, err := ParseOutput(c[0], 0, c[1]); err == nil {
+			t.Fatalf("ParseOutput(%q, %q) = nil error", c[0], c[1])
+		}
+	}
+}
+
+func TestMergeToUnionsReducers(t *testing.T) {
+	j, _ := Lookup("wordcount", nil)
+	var parts []Source
+	for _, kvs := range [][]KV{{{"a", "5"}, {"c", "3"}}, {{"b", "1"}, {"d", "4"}}} {
+		var buf bytes.Buffer
+		if _, err := j.ReduceTo(&buf, SliceSource(kvs), Output{}, nil); err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, NewObjectReader(&buf))
+	}
+	var out bytes.Buffer
+	o, _ := ParseOutput("count", 0, "tsv")
+	if n, err := j.MergeTo(&out, parts, o, nil); err != nil || n != 4 || out.String() != "a\t5\nd\t4\nc\t3\nb\t1\n" {
+		t.Fatalf("MergeTo = %d, %v:\n%s", n, err, out.String())
+	}
+}
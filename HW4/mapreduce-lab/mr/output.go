@@ -0,0 +1,393 @@
+package mr
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Order is the order of the final output's records.
+type Order string
+
+const (
+	// OrderJob keeps the job's own order: by key, or by count for a job
+	// with a Top.
+	OrderJob   Order = ""
+	OrderKey   Order = "key"   // by key
+	OrderCount Order = "count" // by value, largest first, then by key
+)
+
+// OutputFormat is the final output's file format.
+type OutputFormat string
+
+const (
+	FormatObject OutputFormat = "object" // {"key": value, ...}, as WriteObject
+	FormatPairs  OutputFormat = "pairs"  // [["key", value], ...]
+	FormatTSV    OutputFormat = "tsv"    // key<TAB>value lines
+)
+
+// Output is how the final output is written: which records, in what order,
+// in which format.
+type Output struct {
+	Order  Order
+	Top    int // if > 0, only the first Top records in Order
+	Format OutputFormat
+}
+
+// ErrNotNumeric is ordering by count a job whose values are not numbers.
+var ErrNotNumeric = errors.New("order=count needs numeric values")
+
+// ParseOutput reads an Output from an order ("", "key" or "count"), a top
+// count (0 for all) and a format ("", "object", "pairs" or "tsv"). A top
+// count with no order takes the records with the largest counts, as topk
+// does.
+func ParseOutput(order string, top int, format string) (Output, error) {
+	o := Output{Order: Order(order), Top: top, Format: OutputFormat(format)}
+	switch o.Order {
+	case OrderJob, OrderKey, OrderCount:
+	default:
+		return Output{}, fmt.Errorf("unknown order %q (have key, count)", order)
+	}
+	if top < 0 {
+		return Output{}, fmt.Errorf("invalid top %d", top)
+	}
+	if top > 0 && o.Order == OrderJob {
+		o.Order = OrderCount
+	}
+	switch o.Format {
+	case "":
+		o.Format = FormatObject
+	case FormatObject, FormatPairs, FormatTSV:
+	default:
+		return Output{}, fmt.Errorf("unknown output format %q (have object, pairs, tsv)", format)
+	}
+	return o, nil
+}
+
+// OutputFromQuery reads an Output from a reducer request's ?order=, ?top=
+// and ?output_format=.
+func OutputFromQuery(q map[string][]string) (Output, error) {
+	get := func(k string) string {
+		if v := q[k]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	top := 0
+	if v := get("top"); v != "" {
+		var err error
+		if top, err = strconv.Atoi(v); err != nil {
+			return Output{}, fmt.Errorf("top: %q is not an integer", v)
+		}
+	}
+	return ParseOutput(get("order"), top, get("output_format"))
+}
+
+// Ext is the file extension for the output's format.
+func (o Output) Ext() string {
+	if o.Format == FormatTSV {
+		return ".tsv"
+	}
+	return ".json"
+}
+
+// ReduceTo reduces src, which must be sorted, and writes the final output
+// to w as o says. It returns how many records it wrote. sp sorts the whole
+// output by count when o asks for that with no Top; nil sorts in memory.
+//
+// Only a job with a Top, or an order other than the key order the records
+// come in, holds more than one key's records in memory: a Top keeps that
+// many, and a full sort by count goes through sp.
+func (j *Job) ReduceTo(w io.Writer, src Source, o Output, sp *Spiller) (int, error) {
+	s := o.sink(w, j.Top, sp)
+	if err := GroupSource(src, j.Reduce, s.add); err != nil {
+		return 0, err
+	}
+	return s.close()
+}
+
+// MergeTo writes the union of several reducers' outputs, each written by
+// ReduceTo in the default Output, to w as o says. The outputs hold disjoint
+// keys. Without a Top each is in key order and they are merged as
+// streams; with one, the top of them all is taken again.
+func (j *Job) MergeTo(w io.Writer, srcs []Source, o Output, sp *Spiller) (int, error) {
+	s := o.sink(w, j.Top, sp)
+	if j.Top == 0 {
+		merged, err := Merge(srcs...)
+		if err != nil {
+			return 0, err
+		}
+		srcs = []Source{merged}
+	}
+	for _, src := range srcs {
+		for {
+			kv, err := src.Read()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return 0, err
+			}
+			if err := s.add(kv); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return s.close()
+}
+
+// sink orders records, given in key order, and writes them out.
+type sink struct {
+	o    Output
+	ow   outputWriter
+	sp   *Spiller
+	keep int // if > 0, the records with the largest counts held in top
+	top  topHeap
+	n    int
+}
+
+// sink writes o for a job whose Top is jobTop. The job's top by count is
+// taken first, then put in o's order and cut to o's Top.
+func (o Output) sink(w io.Writer, jobTop int, sp *Spiller) *sink {
+	if sp == nil {
+		sp = NewSpiller(nil, 0, "")
+	}
+	s := &sink{o: o, ow: o.newWriter(w), sp: sp, keep: jobTop}
+	if o.Order == OrderCount && o.Top > 0 && (s.keep == 0 || o.Top < s.keep) {
+		s.keep = o.Top
+	}
+	return s
+}
+
+func (s *sink) add(kv KV) error {
+	switch {
+	case s.keep > 0:
+		c, err := count(kv)
+		if err != nil {
+			return err
+		}
+		s.top.push(counted{kv, c}, s.keep)
+		return nil
+	case s.o.Order != OrderCount:
+		if s.o.Top > 0 && s.n >= s.o.Top {
+			return nil
+		}
+		s.n++
+		return s.ow.Write(kv)
+	default:
+		sk, err := countKey(kv)
+		if err != nil {
+			return err
+		}
+		s.sp.Add(sk, kv.Value)
+		return s.sp.Err()
+	}
+}
+
+// close writes what add held back, then finishes the file.
+func (s *sink) close() (int, error) {
+	var kvs []KV
+	switch {
+	case s.keep > 0:
+		kvs = s.top.sorted()
+		if s.o.Order == OrderKey {
+			Sort(kvs)
+		}
+		if s.o.Top > 0 && len(kvs) > s.o.Top {
+			kvs = kvs[:s.o.Top]
+		}
+	case s.o.Order != OrderCount:
+	default:
+		src, err := s.sp.Sorted()
+		if err != nil {
+			return 0, err
+		}
+		for {
+			kv, err := src.Read()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return 0, err
+			}
+			kv.Key = kv.Key[8:] // drop the count prefix
+			if err := s.ow.Write(kv); err != nil {
+				return 0, err
+			}
+			s.n++
+		}
+	}
+	for _, kv := range kvs {
+		if err := s.ow.Write(kv); err != nil {
+			return 0, err
+		}
+		s.n++
+	}
+	return s.n, s.ow.Close()
+}
+
+// counted is a record and its value as a number.
+type counted struct {
+	kv KV
+	n  float64
+}
+
+func count(kv KV) (float64, error) {
+	n, err := strconv.ParseFloat(kv.Value, 64)
+	if err != nil || math.IsNaN(n) {
+		return 0, fmt.Errorf("%w: %q has %s", ErrNotNumeric, kv.Key, kv.Value)
+	}
+	return n, nil
+}
+
+// compareCounted orders a before b if its count is smaller, or, for the
+// same count, if its key is larger; the reverse is the output's order.
+func compareCounted(a, b counted) int {
+	if a.n != b.n {
+		if a.n < b.n {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(b.kv.Key, a.kv.Key)
+}
+
+// countKey is a key that sorts records by count, largest first, then by
+// key: the count as 8 bytes that compare the way the numbers do, inverted,
+// followed by the key itself.
+func countKey(kv KV) (string, error) {
+	n, err := count(kv)
+	if err != nil {
+		return "", err
+	}
+	if n == 0 {
+		n = 0 // -0 sorts as 0
+	}
+	bits := math.Float64bits(n)
+	if n >= 0 {
+		bits |= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], ^bits)
+	return string(b[:]) + kv.Key, nil
+}
+
+// topHeap keeps the largest records by count, smallest at the root.
+type topHeap []counted
+
+func (h topHeap) Len() int           { return len(h) }
+func (h topHeap) Less(a, b int) bool { return compareCounted(h[a], h[b]) < 0 }
+func (h topHeap) Swap(a, b int)      { h[a], h[b] = h[b], h[a] }
+func (h *topHeap) Push(x any)        { *h = append(*h, x.(counted)) }
+func (h *topHeap) Pop() any {
+	c := (*h)[len(*h)-1]
+	*h = (*h)[:len(*h)-1]
+	return c
+}
+
+// push adds c, keeping at most n records.
+func (h *topHeap) push(c counted, n int) {
+	if h.Len() < n {
+		heap.Push(h, c)
+	} else if compareCounted(c, (*h)[0]) > 0 {
+		(*h)[0] = c
+		heap.Fix(h, 0)
+	}
+}
+
+// sorted returns the records, largest first.
+func (h topHeap) sorted() []KV {
+	cs := slices.Clone(h)
+	slices.SortFunc(cs, func(a, b counted) int { return compareCounted(b, a) })
+	kvs := make([]KV, len(cs))
+	for i, c := range cs {
+		kvs[i] = c.kv
+	}
+	return kvs
+}
+
+// outputWriter writes the final output one record at a time.
+type outputWriter interface {
+	Write(KV) error
+	Close() error
+}
+
+func (o Output) newWriter(w io.Writer) outputWriter {
+	switch o.Format {
+	case FormatPairs:
+		return &pairsWriter{bw: bufio.NewWriter(w)}
+	case FormatTSV:
+		return &tsvWriter{bw: bufio.NewWriter(w)}
+	}
+	return NewObjectWriter(w)
+}
+
+// pairsWriter writes a JSON array of [key, value] pairs, one per line.
+type pairsWriter struct {
+	bw *bufio.Writer
+	n  int
+}
+
+func (p *pairsWriter) Write(kv KV) error {
+	if !json.Valid([]byte(kv.Value)) {
+		return fmt.Errorf("value of %q is not JSON: %q", kv.Key, kv.Value)
+	}
+	if p.n == 0 {
+		p.bw.WriteString("[\n  [")
+	} else {
+		p.bw.WriteString(",\n  [")
+	}
+	key, _ := json.Marshal(kv.Key)
+	p.bw.Write(key)
+	p.bw.WriteString(", ")
+	p.bw.WriteString(kv.Value)
+	_, err := p.bw.WriteString("]")
+	p.n++
+	return err
+}
+
+func (p *pairsWriter) Close() error {
+	if p.n == 0 {
+		p.bw.WriteString("[]")
+	} else {
+		p.bw.WriteString("\n]")
+	}
+	return p.bw.Flush()
+}
+
+// tsvWriter writes key<TAB>value lines. A value that is a JSON string is
+// written as the string; others as their JSON. Tabs, newlines, carriage
+// returns and backslashes are escaped as \t, \n, \r and \\, so every
+// record is one line with one tab.
+type tsvWriter struct {
+	bw *bufio.Writer
+}
+
+var tsvEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+func (t *tsvWriter) Write(kv KV) error {
+	value := kv.Value
+	if strings.HasPrefix(value, `"`) {
+		if err := json.Unmarshal([]byte(kv.Value), &value); err != nil {
+			return fmt.Errorf("value of %q is not JSON: %q", kv.Key, kv.Value)
+		}
+	} else if !json.Valid([]byte(value)) {
+		return fmt.Errorf("value of %q is not JSON: %q", kv.Key, kv.Value)
+	}
+	tsvEscaper.WriteString(t.bw, kv.Key)
+	t.bw.WriteByte('\t')
+	tsvEscaper.WriteString(t.bw, value)
+	return t.bw.WriteByte('\n')
+}
+
+func (t *tsvWriter) Close() error { return t.bw.Flush() }
@@ -0,0 +1,169 @@
+package mr
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Intermediate records, from mappers to reducers, are in the binary format
+// (see binary.go) or JSON lines:
+//
+//	{"k":"be","v":"2"}
+//	{"k":"to","v":"2"}
+//
+// The final output is one JSON object from key to value, in record order,
+// which for word count is the {"word": count} object verify_json.py reads.
+
+// WriteRecords writes kvs as JSON lines.
+func WriteRecords(w io.Writer, kvs []KV) error {
+	rw := NewRecordWriter(w)
+	for _, kv := range kvs {
+		if err := rw.Write(kv); err != nil {
+			return err
+		}
+	}
+	return rw.Flush()
+}
+
+// ReadRecords reads all the records in r, in either format.
+func ReadRecords(r io.Reader) ([]KV, error) {
+	var kvs []KV
+	rr := NewRecordReader(r)
+	for {
+		kv, err := rr.Read()
+		if errors.Is(err, io.EOF) {
+			return kvs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		kvs = append(kvs, kv)
+	}
+}
+
+// WriteObject writes the final output: a JSON object with one member per
+// record, in order. Each value must be JSON text.
+func WriteObject(w io.Writer, kvs []KV) error {
+	ow := NewObjectWriter(w)
+	for _, kv := range kvs {
+		if err := ow.Write(kv); err != nil {
+			return err
+		}
+	}
+	return ow.Close()
+}
+
+// ObjectWriter writes the final output one record at a time, as
+// WriteObject does. Close writes the closing brace and flushes.
+type ObjectWriter struct {
+	bw *bufio.Writer
+	n  int
+}
+
+func NewObjectWriter(w io.Writer) *ObjectWriter { return &ObjectWriter{bw: bufio.NewWriter(w)} }
+
+func (o *ObjectWriter) Write(kv KV) error {
+	if !json.Valid([]byte(kv.Value)) {
+		return fmt.Errorf("value of %q is not JSON: %q", kv.Key, kv.Value)
+	}
+	if o.n == 0 {
+		o.bw.WriteString("{\n  ")
+	} else {
+		o.bw.WriteString(",\n  ")
+	}
+	key, _ := json.Marshal(kv.Key)
+	o.bw.Write(key)
+	o.bw.WriteString(": ")
+	_, err := o.bw.WriteString(kv.Value)
+	o.n++
+	return err
+}
+
+func (o *ObjectWriter) Close() error {
+	if o.n == 0 {
+		o.bw.WriteString("{}")
+	} else {
+		o.bw.WriteString("\n}")
+	}
+	return o.bw.Flush()
+}
+
+// ReadObject reads a final output back as records sorted by key, each value
+// its compact JSON text.
+func ReadObject(r io.Reader) ([]KV, error) {
+	var kvs []KV
+	src := NewObjectReader(r)
+	for {
+		kv, err := src.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		kvs = append(kvs, kv)
+	}
+	Sort(kvs)
+	return kvs, nil
+}
+
+// NewObjectReader reads a final output one member at a time, in the order
+// written, each value its compact JSON text.
+func NewObjectReader(r io.Reader) Source {
+	return &objectReader{dec: json.NewDecoder(bufio.NewReader(r))}
+}
+
+type objectReader struct {
+	dec     *json.Decoder
+	started bool
+	done    bool
+	n       int
+}
+
+func (o *objectReader) Read() (KV, error) {
+	if o.done {
+		return KV{}, io.EOF
+	}
+	if !o.started {
+		if t, err := o.dec.Token(); err != nil || t != json.Delim('{') {
+			return KV{}, o.bad(err, "not a JSON object")
+		}
+		o.started = true
+	}
+	if !o.dec.More() {
+		if t, err := o.dec.Token(); err != nil || t != json.Delim('}') {
+			return KV{}, o.bad(err, "unterminated object")
+		}
+		o.done = true
+		return KV{}, io.EOF
+	}
+	t, err := o.dec.Token()
+	key, ok := t.(string)
+	if err != nil || !ok {
+		return KV{}, o.bad(err, "expected a key")
+	}
+	var v json.RawMessage
+	if err := o.dec.Decode(&v); err != nil {
+		return KV{}, o.bad(err, "bad value")
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, v); err != nil {
+		return KV{}, o.bad(err, "bad value")
+	}
+	o.n++
+	return KV{key, buf.String()}, nil
+}
+
+// bad reports malformed input as ErrBadRecord; a failed read passes
+// through.
+func (o *objectReader) bad(err error, what string) error {
+	var syntax *json.SyntaxError
+	if err == nil || errors.As(err, &syntax) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("member %d: %w: %s (%v)", o.n+1, ErrBadRecord, what, err)
+	}
+	return fmt.Errorf("member %d: %w", o.n+1, err)
+}
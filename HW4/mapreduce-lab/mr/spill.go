@@ -0,0 +1,209 @@
+package mr
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// recordOverhead is roughly what a buffered record costs beyond its bytes:
+// the KV's two string headers and its share of the slice.
+const recordOverhead = 32
+
+// Spiller sorts records that may not fit in memory. Records are buffered
+// until they take up Limit bytes; the buffer is then sorted and combined
+// (the in-mapper combiner), and if combining did not free at least half of
+// it, written to a temporary file in Dir as a sorted run in the binary
+// format. Sorted merges the runs and what is left in memory, combining
+// again across runs, so the records come out sorted by key with each key
+// combined once more at the end. The combiner must therefore accept its own
+// output as input, as sums, de-duplication and the like do.
+type Spiller struct {
+	Combine ReduceFunc // optional
+	Limit   int64      // bytes of buffered records before sorting and spilling
+	Dir     string     // where runs go; "" is the system temporary directory
+
+	buf   []KV
+	size  int64
+	runs  []*os.File
+	err   error // first failed spill; Add keeps failing after it
+	stats SpillStats
+}
+
+// SpillStats says how much sorting a Spiller had to do.
+type SpillStats struct {
+	Records  int   // records added
+	Combines int   // times the buffer was combined in memory
+	Spills   int   // sorted runs written to disk
+	Spilled  int64 // bytes written to disk
+}
+
+func NewSpiller(combine ReduceFunc, limit int64, dir string) *Spiller {
+	return &Spiller{Combine: combine, Limit: limit, Dir: dir}
+}
+
+// Add buffers one record, sorting and spilling if the buffer is full. It is
+// an Emit that remembers its error: check Err after the map has run.
+func (s *Spiller) Add(key, value string) {
+	if s.err != nil {
+		return
+	}
+	s.stats.Records++
+	s.buf = append(s.buf, KV{key, value})
+	s.size += int64(len(key) + len(value) + recordOverhead)
+	if s.Limit > 0 && s.size >= s.Limit {
+		s.err = s.flush()
+	}
+}
+
+// Err is the first error Add hit.
+func (s *Spiller) Err() error { return s.err }
+
+func (s *Spiller) Stats() SpillStats { return s.stats }
+
+// flush sorts and combines the buffer, and spills it if that did not make
+// enough room.
+func (s *Spiller) flush() error {
+	if err := s.sortBuffer(); err != nil {
+		return err
+	}
+	if s.size < s.Limit/2 {
+		return nil
+	}
+	f, err := os.CreateTemp(s.Dir, "mr-spill-*.mrb")
+	if err != nil {
+		return fmt.Errorf("spill: %w", err)
+	}
+	s.runs = append(s.runs, f) // Close removes it, even if the write fails
+	w := NewBinaryWriter(f, NoCompression)
+	for _, kv := range s.buf {
+		if err := w.Write(kv); err != nil {
+			return fmt.Errorf("spill: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("spill: %w", err)
+	}
+	if off, err := f.Seek(0, io.SeekCurrent); err == nil {
+		s.stats.Spilled += off
+	}
+	s.stats.Spills++
+	s.buf, s.size = nil, 0
+	return nil
+}
+
+func (s *Spiller) sortBuffer() error {
+	Sort(s.buf)
+	if s.Combine == nil {
+		return nil
+	}
+	combined, err := Group(s.buf, s.Combine)
+	if err != nil {
+		return err
+	}
+	s.stats.Combines++
+	s.buf, s.size = combined, 0
+	for _, kv := range combined {
+		s.size += int64(len(kv.Key) + len(kv.Value) + recordOverhead)
+	}
+	return nil
+}
+
+// Sorted returns every record added, sorted by key and combined. Call it
+// once, after the last Add, and Close the Spiller when done reading.
+func (s *Spiller) Sorted() (Source, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	if err := s.sortBuffer(); err != nil {
+		return nil, err
+	}
+	if len(s.runs) == 0 {
+		return SliceSource(s.buf), nil
+	}
+	srcs := make([]Source, 0, len(s.runs)+1)
+	for _, f := range s.runs {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		srcs = append(srcs, NewRecordReader(f))
+	}
+	srcs = append(srcs, SliceSource(s.buf))
+	merged, err := Merge(srcs...)
+	if err != nil {
+		return nil, err
+	}
+	if s.Combine == nil {
+		return merged, nil
+	}
+	return &combined{src: merged, fn: s.Combine}, nil
+}
+
+// Close removes the spilled runs.
+func (s *Spiller) Close() error {
+	var errs []error
+	for _, f := range s.runs {
+		errs = append(errs, f.Close(), os.Remove(f.Name()))
+	}
+	s.runs, s.buf = nil, nil
+	return errors.Join(errs...)
+}
+
+// combined runs fn over each key of a sorted source as it is read.
+type combined struct {
+	src  Source
+	fn   ReduceFunc
+	next KV // first record of the next key, if have
+	have bool
+	done bool
+	out  []KV
+}
+
+func (c *combined) Read() (KV, error) {
+	for len(c.out) == 0 {
+		if c.done {
+			return KV{}, io.EOF
+		}
+		if err := c.group(); err != nil {
+			return KV{}, err
+		}
+	}
+	kv := c.out[0]
+	c.out = c.out[1:]
+	return kv, nil
+}
+
+// group reads one key's records and combines them into out.
+func (c *combined) group() error {
+	if !c.have {
+		kv, err := c.src.Read()
+		if errors.Is(err, io.EOF) {
+			c.done = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		c.next, c.have = kv, true
+	}
+	key, values := c.next.Key, []string{c.next.Value}
+	c.have = false
+	for {
+		kv, err := c.src.Read()
+		if errors.Is(err, io.EOF) {
+			c.done = true
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if kv.Key != key {
+			c.next, c.have = kv, true
+			break
+		}
+		values = append(values, kv.Value)
+	}
+	c.out = c.out[:0]
+	return c.fn(key, values, func(k, v string) { c.out = append(c.out, KV{k, v}) })
+}
@@ -0,0 +1,204 @@
+package mr
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Source yields records one at a time. Read returns io.EOF after the last.
+type Source interface {
+	Read() (KV, error)
+}
+
+// ErrBadRecord marks input that is not valid records, or not sorted, as
+// opposed to input that could not be read.
+var ErrBadRecord = errors.New("bad records")
+
+// NewRecordReader reads records in either format, JSON lines or binary,
+// telling them apart by the binary magic.
+func NewRecordReader(r io.Reader) Source {
+	br := bufio.NewReader(r)
+	if head, _ := br.Peek(len(magic)); string(head) == magic {
+		return &binaryReader{r: br}
+	}
+	return &jsonReader{dec: json.NewDecoder(br)}
+}
+
+// jsonReader reads JSON lines.
+type jsonReader struct {
+	dec *json.Decoder
+	n   int
+}
+
+func (r *jsonReader) Read() (KV, error) {
+	var kv KV
+	if err := r.dec.Decode(&kv); err != nil {
+		if errors.Is(err, io.EOF) {
+			return KV{}, io.EOF
+		}
+		var syntax *json.SyntaxError
+		var typ *json.UnmarshalTypeError
+		if errors.As(err, &syntax) || errors.As(err, &typ) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return KV{}, fmt.Errorf("record %d: %w: %v", r.n+1, ErrBadRecord, err)
+		}
+		return KV{}, fmt.Errorf("record %d: %w", r.n+1, err)
+	}
+	r.n++
+	return kv, nil
+}
+
+// RecordWriter writes records one at a time. Call Flush when done.
+type RecordWriter interface {
+	Write(KV) error
+	Flush() error
+}
+
+// jsonWriter writes JSON lines.
+type jsonWriter struct {
+	bw  *bufio.Writer
+	enc *json.Encoder
+}
+
+// NewRecordWriter writes records as JSON lines.
+func NewRecordWriter(w io.Writer) RecordWriter {
+	bw := bufio.NewWriter(w)
+	return &jsonWriter{bw: bw, enc: json.NewEncoder(bw)}
+}
+
+func (w *jsonWriter) Write(kv KV) error { return w.enc.Encode(kv) }
+
+func (w *jsonWriter) Flush() error { return w.bw.Flush() }
+
+// sliceSource reads records from memory.
+type sliceSource []KV
+
+func (s *sliceSource) Read() (KV, error) {
+	if len(*s) == 0 {
+		return KV{}, io.EOF
+	}
+	kv := (*s)[0]
+	*s = (*s)[1:]
+	return kv, nil
+}
+
+// SliceSource reads kvs in order.
+func SliceSource(kvs []KV) Source {
+	s := sliceSource(kvs)
+	return &s
+}
+
+// Merge reads several sources, each sorted by key, as one sorted source.
+// Records with the same key come in source order, so a key's values keep
+// the order they had. A source found out of order is an error: grouping
+// its keys would silently go wrong.
+func Merge(srcs ...Source) (Source, error) {
+	m := &merger{}
+	for i, s := range srcs {
+		kv, err := s.Read()
+		if errors.Is(err, io.EOF) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		m.heads = append(m.heads, head{kv, i, s})
+	}
+	heap.Init(m)
+	return m, nil
+}
+
+type head struct {
+	kv  KV
+	idx int // which source, to break ties
+	src Source
+}
+
+type merger struct {
+	heads []head
+}
+
+func (m *merger) Len() int { return len(m.heads) }
+func (m *merger) Less(a, b int) bool {
+	if m.heads[a].kv.Key != m.heads[b].kv.Key {
+		return m.heads[a].kv.Key < m.heads[b].kv.Key
+	}
+	return m.heads[a].idx < m.heads[b].idx
+}
+func (m *merger) Swap(a, b int) { m.heads[a], m.heads[b] = m.heads[b], m.heads[a] }
+func (m *merger) Push(x any)    { m.heads = append(m.heads, x.(head)) }
+func (m *merger) Pop() any {
+	h := m.heads[len(m.heads)-1]
+	m.heads = m.heads[:len(m.heads)-1]
+	return h
+}
+
+func (m *merger) Read() (KV, error) {
+	if len(m.heads) == 0 {
+		return KV{}, io.EOF
+	}
+	top := &m.heads[0]
+	kv := top.kv
+	next, err := top.src.Read()
+	switch {
+	case errors.Is(err, io.EOF):
+		heap.Pop(m)
+	case err != nil:
+		return KV{}, err
+	case next.Key < kv.Key:
+		return KV{}, fmt.Errorf("input %d: %w: not sorted by key, %q after %q", top.idx, ErrBadRecord, next.Key, kv.Key)
+	default:
+		top.kv = next
+		heap.Fix(m, 0)
+	}
+	return kv, nil
+}
+
+// GroupSource calls fn once per key of src, which must be sorted, with all
+// that key's values, and passes what it emits to out. Only one key's values
+// are held at a time.
+func GroupSource(src Source, fn ReduceFunc, out func(KV) error) error {
+	var (
+		key     string
+		values  []string
+		emitted []KV
+		have    bool
+	)
+	emit := func(k, v string) { emitted = append(emitted, KV{k, v}) }
+	flush := func() error {
+		emitted = emitted[:0]
+		if err := fn(key, values, emit); err != nil {
+			return fmt.Errorf("key %q: %w", key, err)
+		}
+		for _, kv := range emitted {
+			if err := out(kv); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for {
+		kv, err := src.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if have && kv.Key != key {
+			if err := flush(); err != nil {
+				return err
+			}
+			values = values[:0]
+		}
+		key, have = kv.Key, true
+		values = append(values, kv.Value)
+	}
+	if !have {
+		return nil
+	}
+	return flush()
+}
@@ -0,0 +1,179 @@
+package mr
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/blevesearch/snowballstem"
+	"github.com/blevesearch/snowballstem/english"
+	"github.com/blevesearch/snowballstem/french"
+	"github.com/blevesearch/snowballstem/german"
+	"github.com/blevesearch/snowballstem/italian"
+	"github.com/blevesearch/snowballstem/porter"
+	"github.com/blevesearch/snowballstem/portuguese"
+	"github.com/blevesearch/snowballstem/russian"
+	"github.com/blevesearch/snowballstem/spanish"
+	"github.com/rivo/uniseg"
+	"golang.org/x/text/unicode/norm"
+)
+
+// An Analyzer turns text into the terms the word jobs count and index. It
+// is built from a job's arguments:
+//
+//	tokenizer  ascii (default): lower-cased runs of [A-Za-z0-9']
+//	           unicode: Unicode word segmentation (UAX #29) of the NFKC
+//	           normalized text, keeping words with a letter or digit
+//	stopwords  english, or a comma-separated list of words to drop
+//	stem       a Snowball stemmer: english, porter, french, german,
+//	           italian, portuguese, russian or spanish
+//	ngrams     n (1..5, default 1): emit runs of n terms joined by spaces
+//
+// Stopwords are dropped before stemming, and n-grams are made from the terms
+// left, within a line. With no arguments an Analyzer gives exactly the
+// ascii words, which is what verify_json.py counts.
+type Analyzer struct {
+	tokenize func(string) []string
+	stop     map[string]bool
+	stem     func(*snowballstem.Env) bool
+	n        int
+}
+
+var tokenizers = map[string]func(string) []string{
+	"ascii":   Words,
+	"unicode": UnicodeWords,
+}
+
+var stemmers = map[string]func(*snowballstem.Env) bool{
+	"english":    english.Stem,
+	"porter":     porter.Stem,
+	"french":     french.Stem,
+	"german":     german.Stem,
+	"italian":    italian.Stem,
+	"portuguese": portuguese.Stem,
+	"russian":    russian.Stem,
+	"spanish":    spanish.Stem,
+}
+
+// NewAnalyzer builds the Analyzer args ask for.
+func NewAnalyzer(args Args) (*Analyzer, error) {
+	a := &Analyzer{tokenize: Words}
+	if name := args["tokenizer"]; name != "" {
+		if a.tokenize = tokenizers[name]; a.tokenize == nil {
+			return nil, fmt.Errorf("arg tokenizer: unknown %q (have %s)", name, strings.Join(names(tokenizers), ", "))
+		}
+	}
+	switch list := args["stopwords"]; list {
+	case "":
+	case "english":
+		a.stop = englishStopwords
+	default:
+		a.stop = make(map[string]bool)
+		for _, w := range strings.Split(list, ",") {
+			if w = strings.ToLower(strings.TrimSpace(w)); w != "" {
+				a.stop[w] = true
+			}
+		}
+	}
+	if name := args["stem"]; name != "" {
+		if a.stem = stemmers[name]; a.stem == nil {
+			return nil, fmt.Errorf("arg stem: unknown %q (have %s)", name, strings.Join(names(stemmers), ", "))
+		}
+	}
+	n, err := args.Int("ngrams", 1)
+	if err != nil {
+		return nil, err
+	}
+	if n < 1 || n > 5 {
+		return nil, fmt.Errorf("arg ngrams must be 1..5, got %d", n)
+	}
+	a.n = n
+	return a, nil
+}
+
+// Terms calls emit with each term of text, in order.
+func (a *Analyzer) Terms(text string, emit func(string)) {
+	if a.stop == nil && a.stem == nil && a.n == 1 {
+		for _, w := range a.tokenize(text) {
+			emit(w)
+		}
+		return
+	}
+	var terms []string
+	for _, line := range strings.Split(text, "\n") {
+		terms = terms[:0]
+		for _, w := range a.tokenize(line) {
+			if a.stop[w] {
+				continue
+			}
+			if a.stem != nil {
+				env := snowballstem.NewEnv(w)
+				a.stem(env)
+				w = env.Current()
+			}
+			terms = append(terms, w)
+		}
+		for i := 0; i+a.n <= len(terms); i++ {
+			emit(strings.Join(terms[i:i+a.n], " "))
+		}
+	}
+}
+
+var wordRe = regexp.MustCompile(`[A-Za-z0-9']+`) // keeps contractions like don't
+
+// Words splits text into lower-case words: the ascii tokenizer.
+func Words(text string) []string {
+	return wordRe.FindAllString(strings.ToLower(text), -1)
+}
+
+// UnicodeWords splits text into lower-case words by the Unicode word
+// boundary rules, after NFKC normalization, so accented and non-Latin words
+// stay whole and ligatures and full-width letters read as plain ones.
+// Ideographs, which have no spaces between words, come one per word. A
+// typographic apostrophe is read as ', so "Hamlet’s" and "Hamlet's" match.
+func UnicodeWords(text string) []string {
+	text = strings.ReplaceAll(norm.NFKC.String(text), "’", "'")
+	var words []string
+	state := -1
+	for len(text) > 0 {
+		var w string
+		w, text, state = uniseg.FirstWordInString(text, state)
+		if strings.IndexFunc(w, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsNumber(r) }) >= 0 {
+			words = append(words, strings.ToLower(w))
+		}
+	}
+	return words
+}
+
+func names[T any](m map[string]T) []string {
+	var ns []string
+	for n := range m {
+		ns = append(ns, n)
+	}
+	sort.Strings(ns)
+	return ns
+}
+
+// englishStopwords is the Snowball English stopword list.
+var englishStopwords = set(`i me my myself we our ours ourselves you your yours yourself yourselves
+he him his himself she her hers herself it its itself they them their theirs themselves
+what which who whom this that these those am is are was were be been being
+have has had having do does did doing would should could ought
+i'm you're he's she's it's we're they're i've you've we've they've i'd you'd he'd she'd we'd they'd
+i'll you'll he'll she'll we'll they'll isn't aren't wasn't weren't hasn't haven't hadn't
+doesn't don't didn't won't wouldn't shan't shouldn't can't cannot couldn't mustn't
+let's that's who's what's here's there's when's where's why's how's
+a an the and but if or because as until while of at by for with about against
+between into through during before after above below to from up down in out on off
+over under again further then once here there when where why how all any both each
+few more most other some such no nor not only own same so than too very`)
+
+func set(words string) map[string]bool {
+	m := make(map[string]bool)
+	for _, w := range strings.Fields(words) {
+		m[w] = true
+	}
+	return m
+}
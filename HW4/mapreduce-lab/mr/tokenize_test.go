@@ -0,0 +1,141 @@
+package mr
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// terms runs the Analyzer args ask for over the fixture file.
+func terms(t *testing.T, file string, args Args) []string {
+	t.Helper()
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewAnalyzer(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	a.Terms(string(b), func(w string) { out = append(out, w) })
+	return out
+}
+
+func occurrences(words []string, w string) int {
+	n := 0
+	for _, x := range words {
+		if x == w {
+			n++
+		}
+	}
+	return n
+}
+
+func TestUnicodeTokenizerMultilingual(t *testing.T) {
+	unicode := Args{"tokenizer": "unicode"}
+
+	fr := terms(t, "testdata/french.txt", unicode)
+	if occurrences(fr, "être") != 3 || occurrences(fr, "rêver") != 1 || occurrences(fr, "l'été") != 1 {
+		t.Fatalf("french = %v", fr)
+	}
+	// The default tokenizer splits accented words.
+	if ascii := terms(t, "testdata/french.txt", nil); occurrences(ascii, "tre") != 3 {
+		t.Fatalf("french, ascii = %v", ascii)
+	}
+
+	ru := terms(t, "testdata/russian.txt", unicode)
+	want := []string{"быть", "или", "не", "быть", "вот", "в", "чём", "вопрос", "умереть", "уснуть", "уснёшь", "и", "видишь", "сны", "сны", "снами"}
+	if !slices.Equal(ru, want) {
+		t.Fatalf("russian = %v, want %v", ru, want)
+	}
+	if ascii := terms(t, "testdata/russian.txt", nil); len(ascii) != 0 {
+		t.Fatalf("russian, ascii = %v", ascii)
+	}
+
+	// Katakana words stay whole; ideographs and kana come one per word.
+	ja := terms(t, "testdata/japanese.txt", unicode)
+	if occurrences(ja, "ハムレット") != 1 || occurrences(ja, "デンマーク") != 1 || occurrences(ja, "問") != 1 || occurrences(ja, "題") != 1 {
+		t.Fatalf("japanese = %v", ja)
+	}
+
+	// NFKC reads the ligature and full-width letters and digits as plain
+	// ones, and a typographic apostrophe as '.
+	mixed := terms(t, "testdata/mixed.txt", unicode)
+	for _, w := range []string{"first", "tokyo", "3", "café", "naïve", "hamlet's", "πρίγκιπας", "هاملت"} {
+		if occurrences(mixed, w) != 1 {
+			t.Fatalf("mixed: %q missing from %v", w, mixed)
+		}
+	}
+}
+
+func TestStemmingAndStopwords(t *testing.T) {
+	de := terms(t, "testdata/german.txt", Args{"tokenizer": "unicode", "stem": "german"})
+	if occurrences(de, "haus") != 2 || occurrences(de, "gross") != 2 || occurrences(de, "strass") != 2 {
+		t.Fatalf("german, stemmed = %v", de)
+	}
+	ru := terms(t, "testdata/russian.txt", Args{"tokenizer": "unicode", "stem": "russian", "stopwords": "и,в,не,или,вот"})
+	if occurrences(ru, "быт") != 2 || occurrences(ru, "и") != 0 || occurrences(ru, "не") != 0 {
+		t.Fatalf("russian, stemmed = %v", ru)
+	}
+	mixed := terms(t, "testdata/mixed.txt", Args{"tokenizer": "unicode", "stem": "english", "stopwords": "english"})
+	if occurrences(mixed, "hamlet") != 1 || occurrences(mixed, "the") != 0 || occurrences(mixed, "in") != 0 {
+		t.Fatalf("mixed, stemmed = %v", mixed)
+	}
+}
+
+func TestAnalyzerOnHamlet(t *testing.T) {
+	const hamlet = "../shakespeare-hamlet.txt"
+	b, err := os.ReadFile(hamlet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	words := Words(string(b))
+
+	// No arguments is Words, which verify_json.py checks against.
+	if got := terms(t, hamlet, nil); !slices.Equal(got, words) {
+		t.Fatalf("default analyzer gives %d words, Words %d", len(got), len(words))
+	}
+
+	// Stemming folds the text's possessive, "Hamlets", into the name.
+	stemmed := terms(t, hamlet, Args{"stem": "english"})
+	if n, want := occurrences(stemmed, "hamlet"), occurrences(words, "hamlet")+occurrences(words, "hamlets"); n != want || occurrences(stemmed, "hamlets") != 0 {
+		t.Fatalf("stemmed hamlet = %d, want %d", n, want)
+	}
+
+	stopped := terms(t, hamlet, Args{"stopwords": "english"})
+	if occurrences(stopped, "the") != 0 || occurrences(stopped, "and") != 0 || occurrences(stopped, "lord") != occurrences(words, "lord") {
+		t.Fatalf("stopwords left the=%d and=%d, lord %d of %d", occurrences(stopped, "the"), occurrences(stopped, "and"), occurrences(stopped, "lord"), occurrences(words, "lord"))
+	}
+
+	// Bigrams pair neighbouring words of the same line.
+	want := 0
+	for _, line := range strings.Split(string(b), "\n") {
+		ws := Words(line)
+		for i := 0; i+1 < len(ws); i++ {
+			if ws[i] == "my" && ws[i+1] == "lord" {
+				want++
+			}
+		}
+	}
+	bigrams := terms(t, hamlet, Args{"ngrams": "2"})
+	if got := occurrences(bigrams, "my lord"); got != want || want == 0 {
+		t.Fatalf(`"my lord" = %d, want %d`, got, want)
+	}
+
+	// The word jobs take the same arguments. With English stopwords
+	// removed, the speaker prefix "ham" is the top term.
+	j, err := Lookup("topk", Args{"k": "1", "stopwords": "english", "stem": "english"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kvs, err := j.RunMap("hamlet", string(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	top, err := j.RunReduce(kvs)
+	if err != nil || len(top) != 1 || top[0].Key != "ham" {
+		t.Fatalf("top term without English stopwords = %v, %v, want ham", top, err)
+	}
+}
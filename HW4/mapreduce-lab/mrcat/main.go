@@ -0,0 +1,113 @@
+// Command mrcat prints intermediate record files, binary or JSON lines, as
+// JSON, so they can be read and checked by hand:
+//
+//	go run ./mrcat file://lab/mr/jobs/<job>/map/task001-attempt1/part-00000.mrb
+//
+// prints the records as JSON lines. Several files are merged in key order.
+// With -reduce, mrcat instead reduces them with the job given by -job and
+// -arg, as a reducer does, and prints the final JSON object; for word count
+// that is the file verify_json.py checks:
+//
+//	go run ./mrcat -reduce file://lab/.../task001-attempt1/part-00000.mrb \
+//		file://lab/.../task002-attempt1/part-00000.mrb > final.json
+//
+// URLs are read as the services read them, with FILE_ROOT for file://.
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+
+	"mapreduce-lab/mr"
+	"mapreduce-lab/storage"
+)
+
+func main() {
+	reduce := flag.Bool("reduce", false, "reduce the records and print the final JSON object")
+	job := flag.String("job", mr.DefaultJob, "job to reduce with")
+	var args []string
+	flag.Func("arg", "job argument as name=value; repeat for more", func(s string) error {
+		args = append(args, s)
+		return nil
+	})
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: mrcat [-reduce [-job name] [-arg name=value]...] url...")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	stores := storage.Default(getenv("AWS_REGION", "us-east-1"), getenv("FILE_ROOT", "."))
+	srcs := make([]mr.Source, flag.NArg())
+	for i, s := range flag.Args() {
+		u, err := storage.Parse(s)
+		if err != nil {
+			log.Fatal(err)
+		}
+		rc, err := stores.Get(ctx, u)
+		if err != nil {
+			log.Fatalf("get %s: %v", u, err)
+		}
+		defer rc.Close()
+		srcs[i] = mr.NewRecordReader(rc)
+	}
+
+	var j *mr.Job
+	if *reduce {
+		var err error
+		if _, j, err = mr.FromQuery(url.Values{"job": {*job}, "arg": args}); err != nil {
+			log.Fatal(err)
+		}
+	}
+	bw := bufio.NewWriter(os.Stdout)
+	if err := convert(bw, srcs, j); err != nil {
+		log.Fatal(err)
+	}
+	if err := bw.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// convert merges srcs and writes them to w as JSON lines, or, given a job,
+// reduces them and writes the final JSON object.
+func convert(w io.Writer, srcs []mr.Source, j *mr.Job) error {
+	merged, err := mr.Merge(srcs...)
+	if err != nil {
+		return err
+	}
+	if j != nil {
+		_, err := j.ReduceTo(w, merged, mr.Output{}, nil)
+		return err
+	}
+	rw := mr.NewRecordWriter(w)
+	for {
+		kv, err := merged.Read()
+		if err == io.EOF {
+			return rw.Flush()
+		}
+		if err != nil {
+			return err
+		}
+		if err := rw.Write(kv); err != nil {
+			return err
+		}
+	}
+}
+
+func getenv(k, def string) string {
+	v := os.Getenv(k)
+	if v == "" {
+		return def
+	}
+	return v
+}
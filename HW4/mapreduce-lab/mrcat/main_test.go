@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"mapreduce-lab/mr"
+)
+
+func TestConvertMergesAndReduces(t *testing.T) {
+	// Two map outputs, one binary and compressed, one JSON lines.
+	var bin, lines bytes.Buffer
+	w := mr.NewBinaryWriter(&bin, mr.Zstd)
+	_ = w.Write(mr.KV{Key: "be", Value: "2"})
+	_ = w.Write(mr.KV{Key: "to", Value: "2"})
+	_ = w.Flush()
+	_ = mr.WriteRecords(&lines, []mr.KV{{Key: "be", Value: "1"}, {Key: "question", Value: "1"}})
+	sources := func() []mr.Source {
+		return []mr.Source{mr.NewRecordReader(bytes.NewReader(bin.Bytes())), mr.NewRecordReader(bytes.NewReader(lines.Bytes()))}
+	}
+
+	var out bytes.Buffer
+	if err := convert(&out, sources(), nil); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"k":"be","v":"2"}` + "\n" + `{"k":"be","v":"1"}` + "\n" + `{"k":"question","v":"1"}` + "\n" + `{"k":"to","v":"2"}` + "\n"
+	if out.String() != want {
+		t.Fatalf("records =\n%s\nwant\n%s", out.String(), want)
+	}
+
+	j, err := mr.Lookup("wordcount", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := convert(&out, sources(), j); err != nil {
+		t.Fatal(err)
+	}
+	var counts map[string]int
+	if err := json.Unmarshal(out.Bytes(), &counts); err != nil || counts["be"] != 3 || counts["to"] != 2 || len(counts) != 3 {
+		t.Fatalf("final = %s (%v)", out.String(), err)
+	}
+}
@@ -1,3 +1,6 @@
+import json
+import sys
+
 import matplotlib.pyplot as plt
 
 # Measured times (seconds)
@@ -9,6 +12,20 @@ times_s = {
     "reduce":0.275870,
 }
 
+# Or pass a saved coordinator job (curl .../jobs/<id> > job.json) to plot
+# its task timings instead.
+if len(sys.argv) > 1:
+    with open(sys.argv[1], encoding="utf-8") as f:
+        job = json.load(f)
+    times_s = {}
+    seen = {}
+    for t in job["tasks"]:
+        n = seen.get(t["phase"], 0)
+        seen[t["phase"]] = n + 1
+        numbered = t["phase"] == "map" or (t["phase"] == "reduce" and job.get("reducers", 1) > 1)
+        name = t["phase"] + (str(n) if numbered else "")
+        times_s[name] = t.get("duration_ms", 0) / 1000
+
 labels = list(times_s.keys())
 times_ms = [times_s[k] * 1000 for k in labels]  # convert to ms
 
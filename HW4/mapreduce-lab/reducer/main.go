@@ -1,129 +1,263 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
-	"sort"
-	"strings"
+	"strconv"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"mapreduce-lab/mr"
+	"mapreduce-lab/storage"
 )
 
 type ReduceResponse struct {
-	Out   string `json:"out"`
-	Files int    `json:"files"`
+	Out     string `json:"out"`
+	Files   int    `json:"files"`
+	Records int    `json:"records"`
 }
 
-func parseS3URL(s string) (bucket, key string, err error) {
-	if !strings.HasPrefix(s, "s3://") {
-		return "", "", errors.New("must start with s3://")
-	}
-	rest := strings.TrimPrefix(s, "s3://")
-	parts := strings.SplitN(rest, "/", 2)
-	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
-		return "", "", errors.New("invalid s3 url, expected s3://bucket/key")
-	}
-	return parts[0], parts[1], nil
-}
-
-func s3URL(bucket, key string) string {
-	return "s3://" + bucket + "/" + key
+// memoryConfig bounds how much of a sort by count the reducer holds.
+type memoryConfig struct {
+	Limit int64  // bytes of records buffered before spilling
+	Dir   string // local directory for spilled runs and output being written
 }
 
 func main() {
 	addr := getenv("ADDR", ":8080")
 	region := getenv("AWS_REGION", "us-east-1")
 	outPrefix := getenv("OUT_PREFIX", "mr/reduce")
-
-	ctx := context.Background()
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
-	if err != nil {
-		log.Fatalf("aws config: %v", err)
+	stores := storage.Default(region, getenv("FILE_ROOT", "."))
+	limit, err := strconv.ParseInt(getenv("REDUCE_MEMORY_LIMIT", strconv.Itoa(64<<20)), 10, 64)
+	if err != nil || limit < 1 {
+		log.Fatalf("REDUCE_MEMORY_LIMIT must be a positive number of bytes, got %q", os.Getenv("REDUCE_MEMORY_LIMIT"))
 	}
-	s3c := s3.NewFromConfig(cfg)
+	mem := memoryConfig{Limit: limit, Dir: getenv("SPILL_DIR", os.TempDir())}
 
 	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(200)
 		_, _ = w.Write([]byte("ok"))
 	})
 
-	http.HandleFunc("/reduce", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/reduce", reduceHandler(stores, outPrefix, mem))
+	http.HandleFunc("/merge", mergeHandler(stores, outPrefix, mem))
+
+	log.Printf("reducer listening on %s region=%s outPrefix=%s memoryLimit=%d spillDir=%s", addr, region, outPrefix, mem.Limit, mem.Dir)
+	log.Fatal(http.ListenAndServe(addr, nil))
+}
+
+// reduceHandler serves /reduce?in=<url>&in=<url>...: it runs a job's
+// reduce over the mappers' records, binary or JSON lines, and writes the
+// final output under outPrefix in the inputs' bucket, or to ?out=<url> if
+// given. ?job= and ?arg= pick the job as for the mapper.
+//
+// The output is in key order unless the job orders it (topk). ?order=key or
+// ?order=count (largest value first) reorders it, ?top=N keeps only the
+// first N (by count, with no ?order=), and ?output_format=pairs or tsv
+// writes a JSON array of [key, value] pairs or key<TAB>value lines instead
+// of a JSON object.
+//
+// The mappers' outputs are sorted by key, so the reducer merges them as
+// streams and holds one key's values at a time; the output is written to
+// a local temporary file and then copied to storage. A job with a Top
+// (topk) keeps that many records, ?top=N keeps N, and a full ?order=count
+// sorts through mem like the mapper's spills.
+func reduceHandler(stores *storage.Stores, outPrefix string, mem memoryConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
 		start := time.Now()
-		ins := r.URL.Query()["in"]
-		if len(ins) < 1 {
-			http.Error(w, "provide at least one ?in=s3://bucket/key (repeat ?in=...)", 400)
+		jobName, job, err := mr.FromQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		o, err := mr.OutputFromQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		ins, out, ok := inputsAndOut(w, r, outPrefix, o.Ext())
+		if !ok {
+			return
+		}
+
+		srcs, closeAll, err := openAll(ctx, stores, ins, mr.NewRecordReader)
+		defer closeAll()
+		if err != nil {
+			http.Error(w, "get error: "+err.Error(), 500)
+			return
+		}
+		merged, err := mr.Merge(srcs...)
+		if err != nil {
+			http.Error(w, "bad input: "+err.Error(), inputStatus(err))
+			return
+		}
+		records, err := writeOutput(ctx, stores, out, mem, func(f io.Writer, sp *mr.Spiller) (int, error) {
+			return job.ReduceTo(f, merged, o, sp)
+		})
+		if err != nil {
+			http.Error(w, err.Error(), inputStatus(err))
 			return
 		}
 
-		// Require same bucket for simplicity (you can relax later)
-		firstBucket, _, err := parseS3URL(ins[0])
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ReduceResponse{Out: out.String(), Files: len(ins), Records: records})
+
+		log.Printf("reduce ok job=%s files=%d records=%d order=%s top=%d format=%s out=%s dur=%s",
+			jobName, len(ins), records, o.Order, o.Top, o.Format, out, time.Since(start))
+	}
+}
+
+// inputStatus is 400 for malformed or unsorted input, or an order the
+// values don't allow, which no retry will fix, and 500 for anything else,
+// including an input read cut short (storage.ErrShortRead), so the
+// coordinator retries it.
+func inputStatus(err error) int {
+	if errors.Is(err, mr.ErrBadRecord) || errors.Is(err, mr.ErrNotNumeric) {
+		return 400
+	}
+	return 500
+}
+
+// openAll opens every input as a Source. closeAll closes what was opened,
+// even if a later input failed.
+func openAll(ctx context.Context, stores *storage.Stores, ins []storage.URL, open func(io.Reader) mr.Source) ([]mr.Source, func(), error) {
+	var rcs []io.Closer
+	closeAll := func() {
+		for _, rc := range rcs {
+			rc.Close()
+		}
+	}
+	srcs := make([]mr.Source, len(ins))
+	for i, u := range ins {
+		rc, err := stores.Get(ctx, u)
+		if err != nil {
+			return nil, closeAll, err
+		}
+		rcs = append(rcs, rc)
+		srcs[i] = open(rc)
+	}
+	return srcs, closeAll, nil
+}
+
+// writeOutput runs write into a local temporary file, with a Spiller for
+// it to sort through, then copies the file into u. Its errors are prefixed
+// with the step that failed.
+func writeOutput(ctx context.Context, stores *storage.Stores, u storage.URL, mem memoryConfig, write func(io.Writer, *mr.Spiller) (int, error)) (int, error) {
+	f, err := os.CreateTemp(mem.Dir, "mr-reduce-*")
+	if err != nil {
+		return 0, fmt.Errorf("put error: %w", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	sp := mr.NewSpiller(nil, mem.Limit, mem.Dir)
+	defer sp.Close()
+	n, err := write(f, sp)
+	if err != nil {
+		return 0, fmt.Errorf("reduce error: %w", err)
+	}
+	if err := putFile(ctx, stores, u, f); err != nil {
+		return 0, fmt.Errorf("put error: %w", err)
+	}
+	return n, nil
+}
+
+// putFile copies f, written up to its current offset, into u.
+func putFile(ctx context.Context, stores *storage.Stores, u storage.URL, f *os.File) error {
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return stores.Put(ctx, u, storage.Sized(f, size))
+}
+
+// mergeHandler serves /merge?in=<url>&in=<url>...: it combines the final
+// outputs of reducers that each took one partition into one output. The
+// partitions hold disjoint keys, so this is their union, cut to the job's
+// Top again when it has one. The inputs must be JSON objects, as
+// /reduce writes by default; ?order=, ?top= and ?output_format= apply to
+// the merged output as they do for /reduce.
+func mergeHandler(stores *storage.Stores, outPrefix string, mem memoryConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		start := time.Now()
+		jobName, job, err := mr.FromQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		o, err := mr.OutputFromQuery(r.URL.Query())
 		if err != nil {
 			http.Error(w, err.Error(), 400)
 			return
 		}
+		ins, out, ok := inputsAndOut(w, r, outPrefix, o.Ext())
+		if !ok {
+			return
+		}
 
-		final := map[string]int{}
-		for _, in := range ins {
-			bucket, key, err := parseS3URL(in)
-			if err != nil {
-				http.Error(w, err.Error(), 400)
-				return
-			}
-			if bucket != firstBucket {
-				http.Error(w, "all inputs must be in same bucket for this simple reducer", 400)
-				return
-			}
-
-			obj, err := s3c.GetObject(ctx, &s3.GetObjectInput{Bucket: &bucket, Key: &key})
-			if err != nil {
-				http.Error(w, "s3 get error: "+err.Error(), 500)
-				return
-			}
-			buf := new(bytes.Buffer)
-			_, _ = buf.ReadFrom(obj.Body)
-			obj.Body.Close()
-
-			part := map[string]int{}
-			if err := json.Unmarshal(buf.Bytes(), &part); err != nil {
-				http.Error(w, "bad json in "+in+": "+err.Error(), 400)
-				return
-			}
-			for k, v := range part {
-				final[k] += v
-			}
-		}
-
-		// Write final json
-		outKey := fmt.Sprintf("%s/final_%s.json", outPrefix, time.Now().UTC().Format("20060102T150405Z"))
-		body, _ := json.MarshalIndent(orderKeys(final), "", "  ")
-
-		_, err = s3c.PutObject(ctx, &s3.PutObjectInput{
-			Bucket: &firstBucket,
-			Key:    &outKey,
-			Body:   bytes.NewReader(body),
+		srcs, closeAll, err := openAll(ctx, stores, ins, mr.NewObjectReader)
+		defer closeAll()
+		if err != nil {
+			http.Error(w, "get error: "+err.Error(), 500)
+			return
+		}
+		records, err := writeOutput(ctx, stores, out, mem, func(f io.Writer, sp *mr.Spiller) (int, error) {
+			return job.MergeTo(f, srcs, o, sp)
 		})
 		if err != nil {
-			http.Error(w, "s3 put error: "+err.Error(), 500)
+			http.Error(w, err.Error(), inputStatus(err))
 			return
 		}
 
-		out := s3URL(firstBucket, outKey)
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(ReduceResponse{Out: out, Files: len(ins)})
+		json.NewEncoder(w).Encode(ReduceResponse{Out: out.String(), Files: len(ins), Records: records})
 
-		log.Printf("reduce ok files=%d out=%s dur=%s", len(ins), out, time.Since(start))
-	})
+		log.Printf("merge ok job=%s files=%d records=%d order=%s top=%d format=%s out=%s dur=%s",
+			jobName, len(ins), records, o.Order, o.Top, o.Format, out, time.Since(start))
+	}
+}
 
-	log.Printf("reducer listening on %s region=%s outPrefix=%s", addr, region, outPrefix)
-	log.Fatal(http.ListenAndServe(addr, nil))
+// inputsAndOut parses the repeated ?in= and the optional ?out=, answering
+// 400 itself if they are bad.
+func inputsAndOut(w http.ResponseWriter, r *http.Request, outPrefix, ext string) ([]storage.URL, storage.URL, bool) {
+	ins := r.URL.Query()["in"]
+	if len(ins) < 1 {
+		http.Error(w, "provide at least one ?in=s3://bucket/key (repeat ?in=...)", 400)
+		return nil, storage.URL{}, false
+	}
+
+	// Require same bucket for simplicity (you can relax later)
+	urls := make([]storage.URL, len(ins))
+	for i, in := range ins {
+		u, err := storage.Parse(in)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return nil, storage.URL{}, false
+		}
+		if i > 0 && !u.SameBucket(urls[0]) {
+			http.Error(w, "all inputs must be in same bucket for this simple reducer", 400)
+			return nil, storage.URL{}, false
+		}
+		urls[i] = u
+	}
+	out := urls[0].At(fmt.Sprintf("%s/final_%s%s", outPrefix, time.Now().UTC().Format("20060102T150405Z"), ext))
+	if o := r.URL.Query().Get("out"); o != "" {
+		var err error
+		if out, err = storage.Parse(o); err != nil {
+			http.Error(w, "out: "+err.Error(), 400)
+			return nil, storage.URL{}, false
+		}
+	}
+	return urls, out, true
 }
 
 func getenv(k, def string) string {
@@ -133,17 +267,3 @@ func getenv(k, def string) string {
 	}
 	return v
 }
-
-// Makes output stable for easier diffing / demos
-func orderKeys(m map[string]int) map[string]int {
-	keys := make([]string, 0, len(m))
-	for k := range m {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
-	ordered := make(map[string]int, len(m))
-	for _, k := range keys {
-		ordered[k] = m[k]
-	}
-	return ordered
-}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"mapreduce-lab/mr"
+	"mapreduce-lab/storage"
+)
+
+func TestReduceSumsParts(t *testing.T) {
+	ctx := context.Background()
+	stores := storage.NewStores()
+	stores.Register("mem", storage.NewMemory())
+
+	a, _ := storage.Parse("mem://lab/mr/maps/a.jsonl")
+	b, _ := storage.Parse("mem://lab/mr/maps/b.mrb")
+	_ = stores.Put(ctx, a, strings.NewReader(`{"k":"be","v":"2"}`+"\n"+`{"k":"to","v":"2"}`+"\n"))
+	// The reducer reads each input in whichever format it is in.
+	var bin bytes.Buffer
+	w := mr.NewBinaryWriter(&bin, mr.Snappy)
+	_ = w.Write(mr.KV{Key: "be", Value: "1"})
+	_ = w.Write(mr.KV{Key: "question", Value: "1"})
+	_ = w.Flush()
+	_ = stores.Put(ctx, b, &bin)
+
+	rec := httptest.NewRecorder()
+	reduceHandler(stores, "mr/reduce", testMemory(t))(rec, httptest.NewRequest(http.MethodGet, "/reduce?in="+a.String()+"&in="+b.String(), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var resp ReduceResponse
+	_ = json.NewDecoder(rec.Body).Decode(&resp)
+	out, err := storage.Parse(resp.Out)
+	if err != nil || resp.Files != 2 {
+		t.Fatalf("response %+v (%v)", resp, err)
+	}
+
+	body, err := stores.ReadAll(ctx, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var final map[string]int
+	if err := json.Unmarshal(body, &final); err != nil {
+		t.Fatal(err)
+	}
+	if final["to"] != 2 || final["be"] != 3 || final["question"] != 1 {
+		t.Fatalf("final = %v", final)
+	}
+}
+
+func TestReduceAndMergeTopK(t *testing.T) {
+	ctx := context.Background()
+	stores := storage.NewStores()
+	stores.Register("mem", storage.NewMemory())
+
+	// Two partitions' map output: disjoint words.
+	p0, _ := storage.Parse("mem://lab/m/part-00000.jsonl")
+	p1, _ := storage.Parse("mem://lab/m/part-00001.jsonl")
+	_ = stores.Put(ctx, p0, strings.NewReader(`{"k":"a","v":"5"}`+"\n"+`{"k":"b","v":"1"}`+"\n"+`{"k":"c","v":"3"}`+"\n"))
+	_ = stores.Put(ctx, p1, strings.NewReader(`{"k":"d","v":"4"}`+"\n"+`{"k":"e","v":"2"}`+"\n"+`{"k":"e","v":"7"}`+"\n"))
+
+	serve := func(h http.HandlerFunc, query string) string {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodGet, "/?job=topk&arg=k=2&"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", query, rec.Code, rec.Body)
+		}
+		var resp ReduceResponse
+		_ = json.NewDecoder(rec.Body).Decode(&resp)
+		return resp.Out
+	}
+	r0 := serve(reduceHandler(stores, "mr/reduce", testMemory(t)), "in="+p0.String()+"&out=mem://lab/r/0.json")
+	r1 := serve(reduceHandler(stores, "mr/reduce", testMemory(t)), "in="+p1.String()+"&out=mem://lab/r/1.json")
+	merged := serve(mergeHandler(stores, "mr/reduce", testMemory(t)), "in="+r0+"&in="+r1+"&out=mem://lab/r/final.json")
+
+	u, _ := storage.Parse(merged)
+	body, _ := stores.ReadAll(ctx, u)
+	// Most frequent first.
+	if want := "{\n  \"e\": 9,\n  \"a\": 5\n}"; string(body) != want {
+		t.Fatalf("merged top 2 =\n%s\nwant\n%s", body, want)
+	}
+}
+
+func TestReduceRejectsMixedBuckets(t *testing.T) {
+	stores := storage.NewStores()
+	stores.Register("mem", storage.NewMemory())
+	rec := httptest.NewRecorder()
+	reduceHandler(stores, "mr/reduce", testMemory(t))(rec, httptest.NewRequest(http.MethodGet, "/reduce?in=mem://a/x.json&in=mem://b/y.json", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want 400", rec.Code)
+	}
+}
+
+func TestReduceRejectsUnsortedInput(t *testing.T) {
+	ctx := context.Background()
+	stores := storage.NewStores()
+	stores.Register("mem", storage.NewMemory())
+	for _, body := range []string{
+		`{"k":"to","v":"2"}` + "\n" + `{"k":"be","v":"2"}` + "\n",
+		`{"k":"be","v":`,
+	} {
+		u, _ := storage.Parse("mem://lab/mr/maps/bad.jsonl")
+		_ = stores.Put(ctx, u, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		reduceHandler(stores, "mr/reduce", testMemory(t))(rec, httptest.NewRequest(http.MethodGet, "/reduce?in="+u.String(), nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%q: status %d, want 400: %s", body, rec.Code, rec.Body)
+		}
+	}
+}
+
+// shortReads is a store whose reads fail partway, as a dropped S3
+// connection does.
+type shortReads struct{ *storage.Memory }
+
+func (s shortReads) Get(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	rc, err := s.Memory.Get(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	cut := io.MultiReader(io.LimitReader(rc, 20), iotest.ErrReader(fmt.Errorf("s3 get: %w", storage.ErrShortRead)))
+	return io.NopCloser(cut), nil
+}
+
+func TestReduceShortReadIsRetryable(t *testing.T) {
+	ctx := context.Background()
+	stores := storage.NewStores()
+	stores.Register("mem", shortReads{storage.NewMemory()})
+	u, _ := storage.Parse("mem://lab/mr/maps/a.mrb")
+	var bin bytes.Buffer
+	w := mr.NewBinaryWriter(&bin, mr.NoCompression)
+	_ = w.Write(mr.KV{Key: "be", Value: "1"})
+	_ = w.Write(mr.KV{Key: "to", Value: "1"})
+	_ = w.Flush()
+	_ = stores.Put(ctx, u, &bin)
+
+	rec := httptest.NewRecorder()
+	reduceHandler(stores, "mr/reduce", testMemory(t))(rec, httptest.NewRequest(http.MethodGet, "/reduce?in="+u.String(), nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d, want 500 so the read is retried: %s", rec.Code, rec.Body)
+	}
+}
+
+// testMemory is plenty of memory, spilling to a directory the test removes.
+func testMemory(t *testing.T) memoryConfig {
+	return memoryConfig{Limit: 64 << 20, Dir: t.TempDir()}
+}
+
+func TestReduceOrdersAndFormatsOutput(t *testing.T) {
+	ctx := context.Background()
+	stores := storage.NewStores()
+	stores.Register("mem", storage.NewMemory())
+	in, _ := storage.Parse("mem://lab/m/part-00000.jsonl")
+	_ = stores.Put(ctx, in, strings.NewReader(`{"k":"a","v":"1"}`+"\n"+`{"k":"b","v":"3"}`+"\n"+`{"k":"c","v":"2"}`+"\n"+`{"k":"d","v":"3"}`+"\n"))
+
+	for _, c := range []struct{ query, ext, want string }{
+		{"order=count&output_format=tsv", ".tsv", "b\t3\nd\t3\nc\t2\na\t1\n"},
+		{"top=2&output_format=pairs", ".json", "[\n  [\"b\", 3],\n  [\"d\", 3]\n]"},
+		{"order=key&top=1", ".json", "{\n  \"a\": 1\n}"},
+	} {
+		rec := httptest.NewRecorder()
+		// A limit this small spills every record, so order=count sorts on disk.
+		mem := memoryConfig{Limit: 1, Dir: t.TempDir()}
+		reduceHandler(stores, "mr/reduce", mem)(rec, httptest.NewRequest(http.MethodGet, "/reduce?in="+in.String()+"&"+c.query, nil))
+		var resp ReduceResponse
+		_ = json.NewDecoder(rec.Body).Decode(&resp)
+		if rec.Code != http.StatusOK || !strings.HasSuffix(resp.Out, c.ext) {
+			t.Fatalf("%s: status %d, out %q", c.query, rec.Code, resp.Out)
+		}
+		u, _ := storage.Parse(resp.Out)
+		if body, _ := stores.ReadAll(ctx, u); string(body) != c.want {
+			t.Fatalf("%s: output\n%s\nwant\n%s", c.query, body, c.want)
+		}
+	}
+
+	for _, q := range []string{"order=size", "output_format=csv", "top=x", "job=invertedindex&order=count"} {
+		rec := httptest.NewRecorder()
+		reduceHandler(stores, "mr/reduce", testMemory(t))(rec, httptest.NewRequest(http.MethodGet, "/reduce?in="+in.String()+"&"+q, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status %d, want 400: %s", q, rec.Code, rec.Body)
+		}
+	}
+}
@@ -4,8 +4,8 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
@@ -13,53 +13,59 @@ import (
 	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"mapreduce-lab/storage"
 )
 
 type SplitResponse struct {
 	Chunks []string `json:"chunks"`
 }
 
-func parseS3URL(s string) (bucket, key string, err error) {
-	if !strings.HasPrefix(s, "s3://") {
-		return "", "", errors.New("must start with s3://")
-	}
-	rest := strings.TrimPrefix(s, "s3://")
-	parts := strings.SplitN(rest, "/", 2)
-	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
-		return "", "", errors.New("invalid s3 url, expected s3://bucket/key")
-	}
-	return parts[0], parts[1], nil
-}
-
-func s3URL(bucket, key string) string {
-	return "s3://" + bucket + "/" + key
-}
-
 func main() {
 	addr := getenv("ADDR", ":8080")
 	region := getenv("AWS_REGION", "us-east-1")
 	outPrefix := getenv("OUT_PREFIX", "mr/chunks") // where to write chunks inside bucket
-
-	ctx := context.Background()
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
-	if err != nil {
-		log.Fatalf("aws config: %v", err)
-	}
-	s3c := s3.NewFromConfig(cfg)
+	stores := storage.Default(region, getenv("FILE_ROOT", "."))
 
 	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(200)
 		_, _ = w.Write([]byte("ok"))
 	})
 
-	http.HandleFunc("/split", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/split", splitHandler(stores, outPrefix))
+
+	log.Printf("splitter listening on %s region=%s outPrefix=%s", addr, region, outPrefix)
+	log.Fatal(http.ListenAndServe(addr, nil))
+}
+
+// maxChunks bounds how many chunks ?chunk_bytes= may produce.
+const maxChunks = 10000
+
+// scanBlock is how much of the input is read at a time while looking for
+// the line break that ends a chunk.
+const scanBlock = 64 << 10
+
+// splitHandler serves /split?in=<url>&chunks=N, or &chunk_bytes=B for
+// chunks of about B bytes each. The input can be any storage URL (s3://,
+// file://, mem://); chunks are written next to it, under outPrefix in the
+// same bucket, or as chunkNN.txt under ?out=<url> if given. ?s3= is
+// accepted for ?in=.
+//
+// Chunks end at line breaks, so no line is cut in two, and add back up to
+// the input byte for byte. The input is never read whole: the splitter
+// finds each boundary with small range reads and streams each chunk from
+// a range read of the input into its Put, so memory use does not depend on
+// the input's size.
+func splitHandler(stores *storage.Stores, outPrefix string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
 		start := time.Now()
 		q := r.URL.Query()
-		in := q.Get("s3")
+		in := q.Get("in")
 		if in == "" {
-			http.Error(w, "missing ?s3=s3://bucket/key", 400)
+			in = q.Get("s3")
+		}
+		if in == "" {
+			http.Error(w, "missing ?in=s3://bucket/key", 400)
 			return
 		}
 		n := 3
@@ -71,73 +77,115 @@ func main() {
 			}
 			n = v
 		}
+		var chunkBytes int64
+		if q.Get("chunk_bytes") != "" {
+			v, err := strconv.ParseInt(q.Get("chunk_bytes"), 10, 64)
+			if err != nil || v < 1 {
+				http.Error(w, "invalid chunk_bytes (> 0)", 400)
+				return
+			}
+			if q.Get("chunks") != "" {
+				http.Error(w, "give chunks or chunk_bytes, not both", 400)
+				return
+			}
+			chunkBytes = v
+		}
 
-		inBucket, inKey, err := parseS3URL(in)
+		inURL, err := storage.Parse(in)
 		if err != nil {
 			http.Error(w, err.Error(), 400)
 			return
 		}
+		ts := time.Now().UTC().Format("20060102T150405Z")
+		chunkURL := func(i int) storage.URL {
+			return inURL.At(fmt.Sprintf("%s/%s_%s_chunk%02d.txt", outPrefix, sanitizeBaseName(inURL.Key), ts, i))
+		}
+		if o := q.Get("out"); o != "" {
+			outDir, err := storage.Parse(o)
+			if err != nil {
+				http.Error(w, "out: "+err.Error(), 400)
+				return
+			}
+			chunkURL = func(i int) storage.URL {
+				return outDir.At(fmt.Sprintf("%s/chunk%02d.txt", strings.TrimSuffix(outDir.Key, "/"), i))
+			}
+		}
 
-		obj, err := s3c.GetObject(ctx, &s3.GetObjectInput{
-			Bucket: &inBucket,
-			Key:    &inKey,
-		})
+		size, err := stores.Size(ctx, inURL)
 		if err != nil {
-			http.Error(w, "s3 get error: "+err.Error(), 500)
+			http.Error(w, "get error: "+err.Error(), 500)
 			return
 		}
-		defer obj.Body.Close()
-
-		// Read all (fine for class-sized inputs; for huge files you’d stream)
-		buf := new(bytes.Buffer)
-		_, _ = buf.ReadFrom(obj.Body)
-		data := buf.Bytes()
-		if len(data) == 0 {
+		if size == 0 {
 			http.Error(w, "input file empty", 400)
 			return
 		}
-
-		// Split by lines so chunks are readable + stable
-		lines := strings.Split(string(data), "\n")
-		chunkSize := (len(lines) + n - 1) / n
-
-		ts := time.Now().UTC().Format("20060102T150405Z")
-		baseName := sanitizeBaseName(inKey)
-		outBucket := inBucket
+		if chunkBytes == 0 {
+			chunkBytes = (size + int64(n) - 1) / int64(n)
+		} else if (size+chunkBytes-1)/chunkBytes > maxChunks {
+			http.Error(w, fmt.Sprintf("chunk_bytes too small: over %d chunks", maxChunks), 400)
+			return
+		}
 
 		var outURLs []string
-		for i := 0; i < n; i++ {
-			from := i * chunkSize
-			if from >= len(lines) {
-				break
-			}
-			to := (i + 1) * chunkSize
-			if to > len(lines) {
-				to = len(lines)
-			}
-			chunkText := strings.Join(lines[from:to], "\n")
-
-			outKey := fmt.Sprintf("%s/%s_%s_chunk%02d.txt", outPrefix, baseName, ts, i)
-			_, err := s3c.PutObject(ctx, &s3.PutObjectInput{
-				Bucket: &outBucket,
-				Key:    &outKey,
-				Body:   strings.NewReader(chunkText),
-			})
+		buf := make([]byte, scanBlock)
+		for from := int64(0); from < size; {
+			to, err := lineEnd(ctx, stores, inURL, from+chunkBytes, size, buf)
 			if err != nil {
-				http.Error(w, "s3 put error: "+err.Error(), 500)
+				http.Error(w, "get error: "+err.Error(), 500)
+				return
+			}
+			if err := copyRange(ctx, stores, inURL, from, to-from, chunkURL(len(outURLs))); err != nil {
+				http.Error(w, err.Error(), 500)
 				return
 			}
-			outURLs = append(outURLs, s3URL(outBucket, outKey))
+			outURLs = append(outURLs, chunkURL(len(outURLs)).String())
+			from = to
 		}
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(SplitResponse{Chunks: outURLs})
 
-		log.Printf("split ok input=%s chunks=%d out=%d dur=%s", in, n, len(outURLs), time.Since(start))
-	})
+		log.Printf("split ok input=%s bytes=%d chunk_bytes=%d out=%d dur=%s", in, size, chunkBytes, len(outURLs), time.Since(start))
+	}
+}
 
-	log.Printf("splitter listening on %s region=%s outPrefix=%s", addr, region, outPrefix)
-	log.Fatal(http.ListenAndServe(addr, nil))
+// lineEnd is where a chunk that should end at off really ends: just past
+// the first line break at or after off-1, or at size. It reads the input a
+// block at a time, so a line of any length costs no more than buf.
+func lineEnd(ctx context.Context, stores *storage.Stores, u storage.URL, off, size int64, buf []byte) (int64, error) {
+	for pos := off - 1; pos < size; pos += int64(len(buf)) {
+		block := buf[:min(int64(len(buf)), size-pos)]
+		rc, err := stores.GetRange(ctx, u, pos, int64(len(block)))
+		if err != nil {
+			return 0, err
+		}
+		n, err := io.ReadFull(rc, block)
+		rc.Close()
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return 0, err
+		}
+		if i := bytes.IndexByte(block[:n], '\n'); i >= 0 {
+			return pos + int64(i) + 1, nil
+		}
+		if n == 0 {
+			break
+		}
+	}
+	return size, nil
+}
+
+// copyRange streams n bytes of u from off into out.
+func copyRange(ctx context.Context, stores *storage.Stores, u storage.URL, off, n int64, out storage.URL) error {
+	rc, err := stores.GetRange(ctx, u, off, n)
+	if err != nil {
+		return fmt.Errorf("get error: %w", err)
+	}
+	defer rc.Close()
+	if err := stores.Put(ctx, out, storage.Sized(rc, n)); err != nil {
+		return fmt.Errorf("put error: %w", err)
+	}
+	return nil
 }
 
 func getenv(k, def string) string {
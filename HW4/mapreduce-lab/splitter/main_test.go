@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"mapreduce-lab/storage"
+)
+
+func TestSplitWritesChunksNextToInput(t *testing.T) {
+	ctx := context.Background()
+	stores := storage.NewStores()
+	stores.Register("mem", storage.NewMemory())
+
+	hamlet, err := os.ReadFile("../shakespeare-hamlet.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, _ := storage.Parse("mem://lab/input/hamlet.txt")
+	if err := stores.Put(ctx, in, strings.NewReader(string(hamlet))); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	splitHandler(stores, "mr/chunks")(rec, httptest.NewRequest(http.MethodGet, "/split?in="+in.String()+"&chunks=4", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var resp SplitResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Chunks) != 4 {
+		t.Fatalf("got %d chunks, want 4", len(resp.Chunks))
+	}
+
+	var parts []string
+	for _, c := range resp.Chunks {
+		u, err := storage.Parse(c)
+		if err != nil || !strings.HasPrefix(c, "mem://lab/mr/chunks/hamlet_") {
+			t.Fatalf("chunk url %s (%v)", c, err)
+		}
+		b, err := stores.ReadAll(ctx, u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, string(b))
+	}
+	if strings.Join(parts, "") != string(hamlet) {
+		t.Fatal("chunks do not add back up to the input")
+	}
+}
+
+// rangeOnly is a store that refuses whole-object reads, so a test can tell
+// the splitter never holds the full input.
+type rangeOnly struct {
+	storage.BlobStore
+	maxRange int64
+}
+
+func (r *rangeOnly) Get(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	return nil, errors.New("whole-object read")
+}
+
+func (r *rangeOnly) GetRange(ctx context.Context, bucket, key string, off, n int64) (io.ReadCloser, error) {
+	r.maxRange = max(r.maxRange, n)
+	return r.BlobStore.GetRange(ctx, bucket, key, off, n)
+}
+
+func TestSplitByBytesOnLineBreaks(t *testing.T) {
+	ctx := context.Background()
+	mem := storage.NewMemory()
+	store := &rangeOnly{BlobStore: mem}
+	stores := storage.NewStores()
+	stores.Register("mem", store)
+
+	// Short lines, a line much longer than a chunk, and no final newline.
+	input := strings.Repeat("to be or not to be\n", 60000) + strings.Repeat("x", 150000) + "\nthat is the question"
+	in, _ := storage.Parse("mem://lab/input/long.txt")
+	if err := mem.Put(ctx, in.Bucket, in.Key, strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	splitHandler(stores, "mr/chunks")(rec, httptest.NewRequest(http.MethodGet, "/split?in="+in.String()+"&chunk_bytes=100000&out=mem://lab/out", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var resp SplitResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	var all strings.Builder
+	for i, c := range resp.Chunks {
+		if want := fmt.Sprintf("mem://lab/out/chunk%02d.txt", i); c != want {
+			t.Fatalf("chunk %d at %s, want %s", i, c, want)
+		}
+		u, _ := storage.Parse(c)
+		rc, err := mem.Get(ctx, u.Bucket, u.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(rc)
+		last := i == len(resp.Chunks)-1
+		if !last && (len(b) < 100000 || b[len(b)-1] != '\n') {
+			t.Fatalf("chunk %d is %d bytes ending %q; want at least 100000 ending in a line break", i, len(b), b[len(b)-1])
+		}
+		all.Write(b)
+	}
+	if all.String() != input {
+		t.Fatal("chunks do not add back up to the input")
+	}
+	if len(resp.Chunks) < 5 || len(resp.Chunks) > (len(input)+99999)/100000 {
+		t.Fatalf("%d chunks for %d bytes in 100000-byte chunks", len(resp.Chunks), len(input))
+	}
+	if store.maxRange > int64(len(input))/4 {
+		t.Fatalf("largest read was %d bytes of %d", store.maxRange, len(input))
+	}
+}
+
+func TestSplitRejectsChunksAndChunkBytes(t *testing.T) {
+	stores := storage.NewStores()
+	stores.Register("mem", storage.NewMemory())
+	for _, q := range []string{"chunks=2&chunk_bytes=10", "chunk_bytes=0", "chunk_bytes=x"} {
+		rec := httptest.NewRecorder()
+		splitHandler(stores, "mr/chunks")(rec, httptest.NewRequest(http.MethodGet, "/split?in=mem://lab/in.txt&"+q, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status %d, want 400", q, rec.Code)
+		}
+	}
+}
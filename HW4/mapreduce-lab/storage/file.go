@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// FS is a BlobStore on the local filesystem: bucket/key is the file
+// <Root>/bucket/key.
+type FS struct {
+	Root string
+}
+
+func NewFS(root string) *FS { return &FS{Root: root} }
+
+func (f *FS) path(bucket, key string) (string, error) {
+	rel := filepath.Join(bucket, filepath.FromSlash(key))
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("file://%s/%s escapes the storage root", bucket, key)
+	}
+	return filepath.Join(f.Root, rel), nil
+}
+
+func (f *FS) Get(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	p, err := f.path(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("file://%s/%s: %w", bucket, key, ErrNotFound)
+	}
+	return file, err
+}
+
+func (f *FS) GetRange(ctx context.Context, bucket, key string, off, n int64) (io.ReadCloser, error) {
+	rc, err := f.Get(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	file := rc.(*os.File)
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.NewSectionReader(file, off, n), file}, nil
+}
+
+func (f *FS) Size(ctx context.Context, bucket, key string) (int64, error) {
+	p, err := f.path(bucket, key)
+	if err != nil {
+		return 0, err
+	}
+	fi, err := os.Stat(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, fmt.Errorf("file://%s/%s: %w", bucket, key, ErrNotFound)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// Put writes to a temporary file next to the target and renames it into
+// place.
+func (f *FS) Put(ctx context.Context, bucket, key string, body io.Reader) error {
+	p, err := f.path(bucket, key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), "."+filepath.Base(p)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
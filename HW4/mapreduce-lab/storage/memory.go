@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Memory is a BlobStore held in process memory. It is safe for concurrent
+// use.
+type Memory struct {
+	mu      sync.RWMutex
+	objects map[string][]byte // bucket + "/" + key
+}
+
+func NewMemory() *Memory { return &Memory{objects: make(map[string][]byte)} }
+
+func (m *Memory) object(bucket, key string) ([]byte, error) {
+	m.mu.RLock()
+	b, ok := m.objects[bucket+"/"+key]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("mem://%s/%s: %w", bucket, key, ErrNotFound)
+	}
+	return b, nil
+}
+
+func (m *Memory) Get(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	b, err := m.object(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (m *Memory) GetRange(ctx context.Context, bucket, key string, off, n int64) (io.ReadCloser, error) {
+	b, err := m.object(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(io.NewSectionReader(bytes.NewReader(b), off, n)), nil
+}
+
+func (m *Memory) Size(ctx context.Context, bucket, key string) (int64, error) {
+	b, err := m.object(bucket, key)
+	return int64(len(b)), err
+}
+
+func (m *Memory) Put(ctx context.Context, bucket, key string, body io.Reader) error {
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.objects[bucket+"/"+key] = b
+	m.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// S3 is a BlobStore on Amazon S3.
+type S3 struct {
+	client func() (*s3.Client, error)
+}
+
+// NewS3 loads the default AWS config for region the first time the store
+// is used.
+func NewS3(region string) *S3 {
+	return &S3{client: sync.OnceValues(func() (*s3.Client, error) {
+		cfg, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(region))
+		if err != nil {
+			return nil, fmt.Errorf("aws config: %w", err)
+		}
+		return s3.NewFromConfig(cfg), nil
+	})}
+}
+
+// NewS3FromClient uses an existing client.
+func NewS3FromClient(c *s3.Client) *S3 {
+	return &S3{client: func() (*s3.Client, error) { return c, nil }}
+}
+
+func (s *S3) Get(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	c, err := s.client()
+	if err != nil {
+		return nil, err
+	}
+	obj, err := c.GetObject(ctx, &s3.GetObjectInput{Bucket: &bucket, Key: &key})
+	if err != nil {
+		var nsk *types.NoSuchKey
+		if errors.As(err, &nsk) {
+			return nil, fmt.Errorf("s3://%s/%s: %w", bucket, key, ErrNotFound)
+		}
+		return nil, fmt.Errorf("s3 get: %w", err)
+	}
+	return fullBody(obj.Body, contentLength(obj.ContentLength)), nil
+}
+
+func (s *S3) GetRange(ctx context.Context, bucket, key string, off, n int64) (io.ReadCloser, error) {
+	if n <= 0 {
+		return io.NopCloser(strings.NewReader("")), nil // S3 has no empty range
+	}
+	c, err := s.client()
+	if err != nil {
+		return nil, err
+	}
+	rng := fmt.Sprintf("bytes=%d-%d", off, off+n-1)
+	obj, err := c.GetObject(ctx, &s3.GetObjectInput{Bucket: &bucket, Key: &key, Range: &rng})
+	if err != nil {
+		var nsk *types.NoSuchKey
+		if errors.As(err, &nsk) {
+			return nil, fmt.Errorf("s3://%s/%s: %w", bucket, key, ErrNotFound)
+		}
+		return nil, fmt.Errorf("s3 get: %w", err)
+	}
+	return fullBody(obj.Body, contentLength(obj.ContentLength)), nil
+}
+
+// contentLength is the length S3 promised for a body, or -1 if it gave none.
+func contentLength(n *int64) int64 {
+	if n == nil {
+		return -1
+	}
+	return *n
+}
+
+func (s *S3) Size(ctx context.Context, bucket, key string) (int64, error) {
+	c, err := s.client()
+	if err != nil {
+		return 0, err
+	}
+	head, err := c.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &bucket, Key: &key})
+	if err != nil {
+		var nf *types.NotFound
+		if errors.As(err, &nf) {
+			return 0, fmt.Errorf("s3://%s/%s: %w", bucket, key, ErrNotFound)
+		}
+		return 0, fmt.Errorf("s3 head: %w", err)
+	}
+	return aws.ToInt64(head.ContentLength), nil
+}
+
+func (s *S3) Put(ctx context.Context, bucket, key string, body io.Reader) error {
+	c, err := s.client()
+	if err != nil {
+		return err
+	}
+	in := &s3.PutObjectInput{Bucket: &bucket, Key: &key, Body: body}
+	if sz, ok := body.(interface{ Size() int64 }); ok {
+		in.ContentLength = aws.Int64(sz.Size())
+	}
+	if _, err := c.PutObject(ctx, in); err != nil {
+		return fmt.Errorf("s3 put: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+// Package storage is the object storage the MapReduce services read their
+// inputs from and write their outputs to. Every object is named by a URL of
+// the form scheme://bucket/key, and the scheme picks the backend:
+//
+//	s3://bucket/key    Amazon S3
+//	file://bucket/key  a local directory, <root>/bucket/key
+//	mem://bucket/key   process memory, for tests
+//
+// so the same pipeline runs against S3 in ECS and against the local disk on
+// a laptop.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// ErrNotFound is returned (wrapped) by Get for a missing object.
+var ErrNotFound = errors.New("object not found")
+
+// ErrShortRead is returned (wrapped) by a read that ended before the whole
+// object arrived, e.g. a dropped connection. Reading again may succeed, so
+// callers should not take the object for a truncated one.
+var ErrShortRead = errors.New("object read ended early")
+
+// BlobStore reads and writes objects. Put replaces the object atomically:
+// readers see the old contents or the new, never part of it. Size and
+// GetRange let a caller work through an object too big to hold in memory.
+type BlobStore interface {
+	Get(ctx context.Context, bucket, key string) (io.ReadCloser, error)
+	// GetRange reads n bytes starting at off, or fewer at the end of the
+	// object.
+	GetRange(ctx context.Context, bucket, key string, off, n int64) (io.ReadCloser, error)
+	Size(ctx context.Context, bucket, key string) (int64, error)
+	Put(ctx context.Context, bucket, key string, body io.Reader) error
+}
+
+// Sized is the first n bytes of r, with its length known up front. Put
+// passes the length on to stores that need it (S3 will not take a stream
+// of unknown length), so a stream can be copied without buffering it.
+func Sized(r io.Reader, n int64) io.Reader { return sized{io.LimitReader(r, n), n} }
+
+type sized struct {
+	io.Reader
+	n int64
+}
+
+func (s sized) Size() int64 { return s.n }
+
+// fullBody reads a body that should hold n bytes (n < 0 if unknown). A
+// body that ends early, or fails with io.ErrUnexpectedEOF as net/http does
+// on a short response, fails with ErrShortRead instead.
+func fullBody(rc io.ReadCloser, n int64) io.ReadCloser {
+	return &checkedBody{ReadCloser: rc, left: n}
+}
+
+type checkedBody struct {
+	io.ReadCloser
+	left int64
+}
+
+func (b *checkedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.left -= int64(n)
+	if errors.Is(err, io.ErrUnexpectedEOF) || err == io.EOF && b.left > 0 {
+		return n, fmt.Errorf("%w (%v)", ErrShortRead, err)
+	}
+	return n, err
+}
+
+// URL is a parsed scheme://bucket/key.
+type URL struct {
+	Scheme string
+	Bucket string
+	Key    string
+}
+
+// Parse splits s into scheme, bucket and key; all three are required.
+func Parse(s string) (URL, error) {
+	scheme, rest, ok := strings.Cut(s, "://")
+	if !ok || scheme == "" {
+		return URL{}, fmt.Errorf("invalid url %q, expected scheme://bucket/key", s)
+	}
+	bucket, key, _ := strings.Cut(rest, "/")
+	if bucket == "" || key == "" {
+		return URL{}, fmt.Errorf("invalid url %q, expected %s://bucket/key", s, scheme)
+	}
+	return URL{Scheme: scheme, Bucket: bucket, Key: key}, nil
+}
+
+func (u URL) String() string { return u.Scheme + "://" + u.Bucket + "/" + u.Key }
+
+// At is key in the same store and bucket as u.
+func (u URL) At(key string) URL {
+	u.Key = key
+	return u
+}
+
+// SameBucket reports whether u and v are in the same store and bucket.
+func (u URL) SameBucket(v URL) bool { return u.Scheme == v.Scheme && u.Bucket == v.Bucket }
+
+// Stores routes each URL to the BlobStore registered for its scheme. It is
+// safe for concurrent use.
+type Stores struct {
+	mu       sync.RWMutex
+	byScheme map[string]BlobStore
+}
+
+func NewStores() *Stores { return &Stores{byScheme: make(map[string]BlobStore)} }
+
+// Register makes b serve URLs with the given scheme.
+func (s *Stores) Register(scheme string, b BlobStore) {
+	s.mu.Lock()
+	s.byScheme[scheme] = b
+	s.mu.Unlock()
+}
+
+func (s *Stores) store(u URL) (BlobStore, error) {
+	s.mu.RLock()
+	b, ok := s.byScheme[u.Scheme]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no storage for scheme %q (in %s)", u.Scheme, u)
+	}
+	return b, nil
+}
+
+func (s *Stores) Get(ctx context.Context, u URL) (io.ReadCloser, error) {
+	b, err := s.store(u)
+	if err != nil {
+		return nil, err
+	}
+	return b.Get(ctx, u.Bucket, u.Key)
+}
+
+func (s *Stores) GetRange(ctx context.Context, u URL, off, n int64) (io.ReadCloser, error) {
+	b, err := s.store(u)
+	if err != nil {
+		return nil, err
+	}
+	return b.GetRange(ctx, u.Bucket, u.Key, off, n)
+}
+
+func (s *Stores) Size(ctx context.Context, u URL) (int64, error) {
+	b, err := s.store(u)
+	if err != nil {
+		return 0, err
+	}
+	return b.Size(ctx, u.Bucket, u.Key)
+}
+
+func (s *Stores) Put(ctx context.Context, u URL, body io.Reader) error {
+	b, err := s.store(u)
+	if err != nil {
+		return err
+	}
+	return b.Put(ctx, u.Bucket, u.Key, body)
+}
+
+// ReadAll gets the whole object at u.
+func (s *Stores) ReadAll(ctx context.Context, u URL) ([]byte, error) {
+	rc, err := s.Get(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// Default serves s3:// from S3 in region (the client is only created on
+// first use, so nothing needs AWS credentials until then), file:// from
+// the directory fileRoot, and mem:// from a fresh Memory.
+func Default(region, fileRoot string) *Stores {
+	s := NewStores()
+	s.Register("s3", NewS3(region))
+	s.Register("file", NewFS(fileRoot))
+	s.Register("mem", NewMemory())
+	return s
+}
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	u, err := Parse("s3://my-bucket/input/hamlet.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u != (URL{"s3", "my-bucket", "input/hamlet.txt"}) {
+		t.Fatalf("Parse = %+v", u)
+	}
+	if got := u.At("mr/out.json").String(); got != "s3://my-bucket/mr/out.json" {
+		t.Fatalf("At = %s", got)
+	}
+	for _, bad := range []string{"", "my-bucket/key", "s3://", "s3://bucket", "s3://bucket/", "s3:///key"} {
+		if _, err := Parse(bad); err == nil {
+			t.Fatalf("Parse(%q) = nil error", bad)
+		}
+	}
+}
+
+func TestStoresRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	s := NewStores()
+	s.Register("file", NewFS(t.TempDir()))
+	s.Register("mem", NewMemory())
+
+	for _, raw := range []string{"file://b/dir/obj.txt", "mem://b/dir/obj.txt"} {
+		u, _ := Parse(raw)
+		if _, err := s.Get(ctx, u); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("%s: Get before Put = %v, want ErrNotFound", raw, err)
+		}
+		for _, body := range []string{"first", "second"} {
+			if err := s.Put(ctx, u, strings.NewReader(body)); err != nil {
+				t.Fatalf("%s: %v", raw, err)
+			}
+			got, err := s.ReadAll(ctx, u)
+			if err != nil || string(got) != body {
+				t.Fatalf("%s: ReadAll = %q, %v; want %q", raw, got, err, body)
+			}
+		}
+	}
+
+	if _, err := s.Get(ctx, URL{"gs", "b", "k"}); err == nil {
+		t.Fatal("unregistered scheme accepted")
+	}
+}
+
+func TestRangeReads(t *testing.T) {
+	ctx := context.Background()
+	s := NewStores()
+	s.Register("file", NewFS(t.TempDir()))
+	s.Register("mem", NewMemory())
+
+	for _, raw := range []string{"file://b/obj.txt", "mem://b/obj.txt"} {
+		u, _ := Parse(raw)
+		if _, err := s.Size(ctx, u); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("%s: Size before Put = %v, want ErrNotFound", raw, err)
+		}
+		if err := s.Put(ctx, u, Sized(strings.NewReader("0123456789 and more"), 10)); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := s.Size(ctx, u); err != nil || n != 10 {
+			t.Fatalf("%s: Size = %d, %v; want 10", raw, n, err)
+		}
+		for _, c := range []struct {
+			off, n int64
+			want   string
+		}{{0, 4, "0123"}, {4, 3, "456"}, {8, 5, "89"}, {10, 1, ""}} {
+			rc, err := s.GetRange(ctx, u, c.off, c.n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil || string(got) != c.want {
+				t.Fatalf("%s: GetRange(%d, %d) = %q, %v; want %q", raw, c.off, c.n, got, err, c.want)
+			}
+		}
+	}
+}
+
+func TestFSStaysUnderRoot(t *testing.T) {
+	f := NewFS(t.TempDir())
+	if err := f.Put(context.Background(), "b", "../../escape.txt", strings.NewReader("x")); err == nil {
+		t.Fatal("key escaping the root accepted")
+	}
+}
+
+// failAfter returns data, then err.
+type failAfter struct {
+	data string
+	err  error
+}
+
+func (f *failAfter) Read(p []byte) (int, error) {
+	if f.data == "" {
+		return 0, f.err
+	}
+	n := copy(p, f.data)
+	f.data = f.data[n:]
+	return n, nil
+}
+
+func TestFullBodyReportsShortReads(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		body io.Reader
+		n    int64
+	}{
+		{"dropped connection", &failAfter{"abc", io.ErrUnexpectedEOF}, 10},
+		{"ends before its length", strings.NewReader("abc"), 10},
+	} {
+		_, err := io.ReadAll(fullBody(io.NopCloser(tc.body), tc.n))
+		if !errors.Is(err, ErrShortRead) || errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("%s: err = %v, want ErrShortRead only", tc.name, err)
+		}
+	}
+	for _, n := range []int64{3, -1} {
+		if b, err := io.ReadAll(fullBody(io.NopCloser(strings.NewReader("abc")), n)); string(b) != "abc" || err != nil {
+			t.Fatalf("length %d: %q, %v; want the whole body", n, b, err)
+		}
+	}
+}
@@ -2,7 +2,10 @@ module text/main
 
 go 1.24.0
 
-require github.com/gin-gonic/gin v1.10.1
+require (
+	github.com/gin-gonic/gin v1.10.1
+	github.com/klauspost/compress v1.17.11
+)
 
 require (
 	github.com/bytedance/sonic v1.11.6 // indirect
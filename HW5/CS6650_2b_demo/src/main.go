@@ -1,6 +1,7 @@
 package main
 
 import (
+    "log"
     "net/http"
 
     "github.com/gin-gonic/gin"
@@ -27,12 +28,21 @@ func main() {
     router.GET("/albums/:id", getAlbumByID)
     router.POST("/albums", postAlbums)
 
-    router.Run(":8080")
+    // gin.Engine is a plain http.Handler, so the shared compression
+    // middleware wraps it like any other mux.
+    log.Fatal(http.ListenAndServe(":8080", withCompression(router, compressMinBytes)))
 }
 
-// getAlbums responds with the list of all albums as JSON.
+// getAlbums responds with the list of all albums as JSON, streamed one
+// album at a time rather than encoded as one indented blob.
 func getAlbums(c *gin.Context) {
-    c.IndentedJSON(http.StatusOK, albums)
+    out := startJSONArray(c.Writer, http.StatusOK, "")
+    for _, a := range albums {
+        if err := out.add(a); err != nil {
+            return
+        }
+    }
+    _ = out.close("")
 }
 
 // postAlbums adds an album from JSON received in the request body.
@@ -47,7 +57,7 @@ func postAlbums(c *gin.Context) {
 
     // Add the new album to the slice.
     albums = append(albums, newAlbum)
-    c.IndentedJSON(http.StatusCreated, newAlbum)
+    c.JSON(http.StatusCreated, newAlbum)
 }
 
 // getAlbumByID locates the album whose ID value matches the id
@@ -59,9 +69,9 @@ func getAlbumByID(c *gin.Context) {
     // an album whose ID value matches the parameter.
     for _, a := range albums {
         if a.ID == id {
-            c.IndentedJSON(http.StatusOK, a)
+            c.JSON(http.StatusOK, a)
             return
         }
     }
-    c.IndentedJSON(http.StatusNotFound, gin.H{"message": "album not found"})
+    c.JSON(http.StatusNotFound, gin.H{"message": "album not found"})
 }
\ No newline at end of file
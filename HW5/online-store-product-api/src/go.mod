@@ -1,3 +1,5 @@
 module online-store-product-api
 
 go 1.22
+
+require github.com/klauspost/compress v1.17.11
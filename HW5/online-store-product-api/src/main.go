@@ -247,5 +247,5 @@ func main() {
 
 	addr := ":8080"
 	log.Printf("listening on %s", addr)
-	log.Fatal(http.ListenAndServe(addr, withLogging(mux)))
+	log.Fatal(http.ListenAndServe(addr, withLogging(withCompression(mux, compressMinBytes))))
 }
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"text/main/faults"
+)
+
+// adminToken, when set (ADMIN_TOKEN), must be sent as "Authorization:
+// Bearer <token>" to use the /admin/ endpoints. Unset leaves them open, which
+// is only fine inside the demo VPC.
+var adminToken = os.Getenv("ADMIN_TOKEN")
+
+func withAdminToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if adminToken != "" {
+			got := []byte(r.Header.Get("Authorization"))
+			if subtle.ConstantTimeCompare(got, []byte("Bearer "+adminToken)) != 1 {
+				http.Error(w, "admin token required", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newFaultInjector returns the injector for chaos experiments on any route;
+// rules are managed at /admin/faults and can be preloaded from FAULTS (the
+// same JSON list PUT takes). The admin endpoints themselves are never
+// faulted, so a bad rule can always be removed.
+func newFaultInjector() *faults.Injector {
+	in := faults.New()
+	in.Skip = func(r *http.Request) bool { return strings.HasPrefix(r.URL.Path, "/admin/") }
+	return in
+}
+
+func faultRulesFromEnv(in *faults.Injector) error {
+	v := os.Getenv("FAULTS")
+	if v == "" {
+		return nil
+	}
+	var rules []faults.Rule
+	if err := json.Unmarshal([]byte(v), &rules); err != nil {
+		return fmt.Errorf("FAULTS must be a JSON list of rules: %v", err)
+	}
+	return in.SetRules(rules)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWithAdminToken(t *testing.T) {
+	defer func(old string) { adminToken = old }(adminToken)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	h := withAdminToken(ok)
+
+	for _, tc := range []struct {
+		token, auth string
+		want        int
+	}{
+		{"", "", http.StatusOK},
+		{"s3cret", "", http.StatusUnauthorized},
+		{"s3cret", "Bearer wrong", http.StatusUnauthorized},
+		{"s3cret", "Bearer s3cret", http.StatusOK},
+	} {
+		adminToken = tc.token
+		req := httptest.NewRequest(http.MethodGet, "/admin/faults", nil)
+		if tc.auth != "" {
+			req.Header.Set("Authorization", tc.auth)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Fatalf("token %q, auth %q: status %d, want %d", tc.token, tc.auth, rec.Code, tc.want)
+		}
+	}
+}
+
+func TestFaultsFromEnvApplyToSearch(t *testing.T) {
+	t.Setenv("FAULTS", `[{"name":"flaky-search","route":"GET /products/search","header":"X-Chaos=on","errors":[{"status":503,"rate":1}]}]`)
+	in := newFaultInjector()
+	if err := faultRulesFromEnv(in); err != nil {
+		t.Fatal(err)
+	}
+	_, mux := setupStoreForTest(10)
+	h := in.Middleware(mux)
+
+	req := httptest.NewRequest(http.MethodGet, "/products/search?q=a", nil)
+	req.Header.Set("X-Chaos", "on")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("opted-in search = %d, want 503", rec.Code)
+	}
+	if w := doRequest(t, h, http.MethodGet, "/products/search?q=a", ""); w.Code != http.StatusOK {
+		t.Fatalf("plain search = %d, want 200", w.Code)
+	}
+
+	t.Setenv("FAULTS", `{"name":"not a list"}`)
+	if err := faultRulesFromEnv(newFaultInjector()); err == nil || !strings.Contains(err.Error(), "FAULTS") {
+		t.Fatalf("bad FAULTS error = %v", err)
+	}
+}
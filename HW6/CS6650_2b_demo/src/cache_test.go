@@ -0,0 +1,194 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func newTestCache(ttl time.Duration, maxBytes int64) (*searchCache, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1_700_000_000, 0)}
+	c := newSearchCache(ttl, maxBytes)
+	c.now = clock.now
+	return c, clock
+}
+
+func respWith(names ...string) func() SearchResponse {
+	return func() SearchResponse {
+		var ps []Product
+		for i, n := range names {
+			ps = append(ps, Product{ID: i + 1, Name: n})
+		}
+		return SearchResponse{Products: ps, TotalFound: len(ps)}
+	}
+}
+
+func TestSearchCacheKeyNormalizes(t *testing.T) {
+	if a, b := searchCacheKey("  Electronics Deals "), searchCacheKey("electronics deals"); a != b {
+		t.Fatalf("keys differ: %q vs %q", a, b)
+	}
+	// The search matches "electronics  deals" literally, so it is a
+	// different query.
+	if a, b := searchCacheKey("electronics  deals"), searchCacheKey("electronics deals"); a == b {
+		t.Fatalf("keys for different inner whitespace both = %q", a)
+	}
+}
+
+func TestSearchCacheHitAndTTL(t *testing.T) {
+	c, clock := newTestCache(time.Minute, 1<<20)
+
+	if _, how := c.get("q=a", respWith("x")); how != cacheMiss {
+		t.Fatalf("first get = %s, want MISS", how)
+	}
+	if r, how := c.get("q=a", respWith("other")); how != cacheHit || r.Products[0].Name != "x" {
+		t.Fatalf("second get = %s %+v, want HIT with cached x", how, r)
+	}
+
+	clock.t = clock.t.Add(2 * time.Minute)
+	if r, how := c.get("q=a", respWith("fresh")); how != cacheMiss || r.Products[0].Name != "fresh" {
+		t.Fatalf("get after ttl = %s %+v, want MISS with fresh", how, r)
+	}
+	if s := c.snapshot(); s.Hits != 1 || s.Misses != 2 || s.Expirations != 1 {
+		t.Fatalf("stats = %+v", s)
+	}
+}
+
+func TestSearchCacheEvictsLRUByBytes(t *testing.T) {
+	entry := int64(len("q=a")) + approxResponseSize(respWith("x")())
+	c, _ := newTestCache(time.Minute, 2*entry)
+
+	c.get("q=a", respWith("x"))
+	c.get("q=b", respWith("x"))
+	c.get("q=a", respWith("x")) // a is now most recently used
+	c.get("q=c", respWith("x")) // evicts b
+
+	if _, how := c.get("q=a", respWith("x")); how != cacheHit {
+		t.Fatalf("q=a = %s, want HIT", how)
+	}
+	if _, how := c.get("q=b", respWith("x")); how != cacheMiss {
+		t.Fatalf("q=b = %s, want MISS after eviction", how)
+	}
+	if s := c.snapshot(); s.Bytes > s.MaxBytes || s.Evictions == 0 {
+		t.Fatalf("stats = %+v", s)
+	}
+}
+
+func TestSearchCacheInvalidate(t *testing.T) {
+	c, _ := newTestCache(time.Minute, 1<<20)
+	c.get("q=a", respWith("old"))
+	c.invalidate()
+
+	if r, how := c.get("q=a", respWith("new")); how != cacheMiss || r.Products[0].Name != "new" {
+		t.Fatalf("get after invalidate = %s %+v, want MISS with new", how, r)
+	}
+}
+
+func TestSearchCacheDropsResultComputedBeforeInvalidate(t *testing.T) {
+	c, _ := newTestCache(time.Minute, 1<<20)
+	c.get("q=a", func() SearchResponse {
+		c.invalidate() // a write lands while the scan is running
+		return respWith("stale")()
+	})
+
+	if r, how := c.get("q=a", respWith("new")); how != cacheMiss || r.Products[0].Name != "new" {
+		t.Fatalf("get = %s %+v, want MISS with new", how, r)
+	}
+}
+
+func TestSearchCacheCollapsesConcurrentMisses(t *testing.T) {
+	c, _ := newTestCache(time.Minute, 1<<20)
+
+	var computes atomic.Int32
+	release := make(chan struct{})
+	compute := func() SearchResponse {
+		computes.Add(1)
+		<-release
+		return respWith("x")()
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if r, _ := c.get("q=a", compute); len(r.Products) != 1 {
+				t.Errorf("got %+v", r)
+			}
+		}()
+	}
+	// Wait until every goroutine is either computing or waiting on it.
+	for {
+		s := c.snapshot()
+		if s.Misses+s.Shared == 10 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if n := computes.Load(); n != 1 {
+		t.Fatalf("compute ran %d times, want 1", n)
+	}
+}
+
+func TestSearchCacheWaitersRecomputeAfterPanic(t *testing.T) {
+	c, _ := newTestCache(time.Minute, 1<<20)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer func() { _ = recover() }()
+		c.get("q=a", func() SearchResponse {
+			close(started)
+			<-release
+			panic("scan failed")
+		})
+	}()
+	<-started
+
+	done := make(chan struct{})
+	var r SearchResponse
+	var how cacheResult
+	go func() {
+		defer close(done)
+		r, how = c.get("q=a", respWith("x"))
+	}()
+	// Wait until the second get is waiting on the first.
+	for c.snapshot().Shared == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	<-done
+
+	if how != cacheMiss || len(r.Products) != 1 {
+		t.Fatalf("waiter got %s %+v, want MISS with x", how, r)
+	}
+}
+
+func TestSearchInvalidatedByWrite(t *testing.T) {
+	_, h := setupStoreForTest(10)
+
+	w := doRequest(t, h, http.MethodGet, "/products/search?q=zebra", "")
+	if w.Header().Get("X-Cache") != "MISS" || decodeSearch(t, w).TotalFound != 0 {
+		t.Fatalf("first search: X-Cache=%s body=%s", w.Header().Get("X-Cache"), w.Body.String())
+	}
+	if w := doRequest(t, h, http.MethodGet, "/products/search?q=Zebra", ""); w.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("repeat search X-Cache = %s, want HIT", w.Header().Get("X-Cache"))
+	}
+
+	doRequest(t, h, http.MethodPost, "/products", `{"name":"Zebra Lamp"}`)
+
+	w = doRequest(t, h, http.MethodGet, "/products/search?q=zebra", "")
+	if w.Header().Get("X-Cache") != "MISS" || decodeSearch(t, w).TotalFound != 1 {
+		t.Fatalf("search after write: X-Cache=%s body=%s", w.Header().Get("X-Cache"), w.Body.String())
+	}
+	if w := doRequest(t, h, http.MethodGet, "/debug/cache", ""); w.Code != http.StatusOK {
+		t.Fatalf("GET /debug/cache status = %d", w.Code)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	cases := map[string]string{
+		"":                        "",
+		"identity":                "",
+		"gzip":                    "gzip",
+		"gzip, deflate, br, zstd": "zstd",
+		"zstd;q=0.5, gzip":        "gzip",
+		"gzip;q=0, zstd;q=0":      "",
+		"*":                       "zstd",
+		"*;q=0.1, gzip;q=0.8":     "gzip",
+	}
+	for header, want := range cases {
+		if got := negotiateEncoding(header); got != want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func serveCompressed(h http.HandlerFunc, acceptEncoding string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	w := httptest.NewRecorder()
+	withCompression(h, 100).ServeHTTP(w, req)
+	return w
+}
+
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var r io.Reader = w.Body
+	switch w.Header().Get("Content-Encoding") {
+	case "gzip":
+		zr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = zr
+	case "zstd":
+		zr, err := zstd.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestCompressionAboveThreshold(t *testing.T) {
+	body := strings.Repeat("product ", 50)
+	h := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		_, _ = io.WriteString(w, body[:10])
+		_, _ = io.WriteString(w, body[10:])
+	}
+
+	for _, enc := range []string{"gzip", "zstd"} {
+		w := serveCompressed(h, enc)
+		if w.Code != http.StatusCreated || w.Header().Get("Content-Encoding") != enc {
+			t.Fatalf("%s: status=%d encoding=%q", enc, w.Code, w.Header().Get("Content-Encoding"))
+		}
+		if got := decodeBody(t, w); got != body {
+			t.Fatalf("%s: decoded body = %q", enc, got)
+		}
+		if w.Header().Get("Vary") != "Accept-Encoding" {
+			t.Fatalf("%s: missing Vary header", enc)
+		}
+	}
+}
+
+func TestCompressionSkipsSmallAndUnaccepted(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) { _, _ = io.WriteString(w, "ok") }
+	if w := serveCompressed(h, "gzip"); w.Header().Get("Content-Encoding") != "" || w.Body.String() != "ok" {
+		t.Fatalf("small body: encoding=%q body=%q", w.Header().Get("Content-Encoding"), w.Body.String())
+	}
+
+	big := strings.Repeat("x", 500)
+	h = func(w http.ResponseWriter, r *http.Request) { _, _ = io.WriteString(w, big) }
+	if w := serveCompressed(h, "br"); w.Header().Get("Content-Encoding") != "" || w.Body.String() != big {
+		t.Fatalf("unaccepted encoding: encoding=%q", w.Header().Get("Content-Encoding"))
+	}
+}
+
+func TestCompressionNoContent(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+	if w := serveCompressed(h, "gzip"); w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+		t.Fatalf("status=%d body=%q", w.Code, w.Body.String())
+	}
+}
+
+func TestStreamedListingCompressed(t *testing.T) {
+	_, mux := setupStoreForTest(1200)
+	h := withCompression(mux, compressMinBytes)
+
+	req := httptest.NewRequest(http.MethodGet, "/products?offset=100&limit=1000", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("status=%d encoding=%q", w.Code, w.Header().Get("Content-Encoding"))
+	}
+	var page ProductPage
+	if err := json.Unmarshal([]byte(decodeBody(t, w)), &page); err != nil {
+		t.Fatalf("bad listing json: %v", err)
+	}
+	if page.Total != 1200 || len(page.Products) != 1000 || page.Products[0].ID != 101 || page.Products[999].ID != 1100 {
+		t.Fatalf("page total=%d len=%d first=%d", page.Total, len(page.Products), page.Products[0].ID)
+	}
+}
+
+func TestListingPastEndAndBadParams(t *testing.T) {
+	_, h := setupStoreForTest(5)
+
+	w := doRequest(t, h, http.MethodGet, "/products?offset=10", "")
+	var page ProductPage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil || len(page.Products) != 0 {
+		t.Fatalf("past-end listing = %q (%v)", w.Body.String(), err)
+	}
+	for _, q := range []string{"offset=-1", "limit=0", "limit=abc"} {
+		if w := doRequest(t, h, http.MethodGet, "/products?"+q, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("GET /products?%s status = %d, want %d", q, w.Code, http.StatusBadRequest)
+		}
+	}
+}
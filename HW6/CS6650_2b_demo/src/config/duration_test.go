@@ -0,0 +1,23 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDurationJSON(t *testing.T) {
+	b, err := json.Marshal(Duration(250 * time.Millisecond))
+	if err != nil || string(b) != `"250ms"` {
+		t.Fatalf("Marshal = %s, %v", b, err)
+	}
+	var d Duration
+	if err := json.Unmarshal([]byte(`"1m30s"`), &d); err != nil || time.Duration(d) != 90*time.Second {
+		t.Fatalf("Unmarshal = %v, %v", time.Duration(d), err)
+	}
+	for _, bad := range []string{`250`, `"soon"`} {
+		if err := json.Unmarshal([]byte(bad), &d); err == nil {
+			t.Fatalf("Unmarshal(%s) accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+// Package faults injects failures into an HTTP service for chaos
+// experiments: added latency drawn from a distribution, error statuses,
+// connection resets, corrupted response bodies and handler panics. Rules
+// are scoped by route, request header and/or query parameter and can be
+// replaced at runtime through Injector.AdminHandler, so an experiment needs
+// no code change or redeploy.
+//
+// Each service that uses the package has its own copy of it, kept byte for
+// byte the same; MidtermMastery/CS6650_2b_demo/src/shared_copies_test.go
+// checks that.
+package faults
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"text/main/config"
+)
+
+// Latency delays a share of requests by a random amount.
+type Latency struct {
+	Rate float64 `json:"rate"` // share of matching requests delayed, default 1
+	// Dist is fixed (Min), uniform (Min..Max), normal (Mean, StdDev) or
+	// exponential (Mean). Draws are clamped to [Min, Max] when those are set.
+	Dist   string          `json:"dist"`
+	Min    config.Duration `json:"min,omitempty"`
+	Max    config.Duration `json:"max,omitempty"`
+	Mean   config.Duration `json:"mean,omitempty"`
+	StdDev config.Duration `json:"stddev,omitempty"`
+}
+
+// StatusRate answers a share of requests with Status instead of running the
+// handler.
+type StatusRate struct {
+	Status int     `json:"status"`
+	Rate   float64 `json:"rate"`
+}
+
+// Rule is one fault experiment. Every rule that matches a request applies,
+// in the order given; within a rule the latency comes first, then a panic,
+// a reset, an error status, and finally body corruption.
+type Rule struct {
+	Name string `json:"name,omitempty"`
+	// Route is "METHOD /path", "/path", a prefix ending in "/" or empty for
+	// every route.
+	Route string `json:"route,omitempty"`
+	// Header limits the rule to requests carrying it: "X-Chaos" (any value)
+	// or "X-Chaos=on".
+	Header string `json:"header,omitempty"`
+	// Query limits the rule to requests with a query parameter: "mode"
+	// (any value) or "mode=slow".
+	Query string `json:"query,omitempty"`
+
+	Latency     *Latency     `json:"latency,omitempty"`
+	Errors      []StatusRate `json:"errors,omitempty"`
+	ResetRate   float64      `json:"reset_rate,omitempty"`   // drop the connection with no response
+	CorruptRate float64      `json:"corrupt_rate,omitempty"` // flip bytes in the response body
+	PanicRate   float64      `json:"panic_rate,omitempty"`
+}
+
+func (r *Rule) validate() error {
+	rate := func(name string, v float64) error {
+		if v < 0 || v > 1 {
+			return fmt.Errorf("rule %q: %s must be between 0 and 1", r.Name, name)
+		}
+		return nil
+	}
+	if r.Route != "" {
+		_, path, _ := splitRoute(r.Route)
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("rule %q: route path must start with /", r.Name)
+		}
+	}
+	if l := r.Latency; l != nil {
+		if l.Rate == 0 {
+			l.Rate = 1
+		}
+		if err := rate("latency.rate", l.Rate); err != nil {
+			return err
+		}
+		switch l.Dist {
+		case "fixed", "uniform", "normal", "exponential":
+		default:
+			return fmt.Errorf("rule %q: latency.dist must be fixed, uniform, normal or exponential", r.Name)
+		}
+		if l.Min < 0 || l.Max < 0 || l.Mean < 0 || l.StdDev < 0 || (l.Max > 0 && l.Max < l.Min) {
+			return fmt.Errorf("rule %q: bad latency bounds", r.Name)
+		}
+	}
+	for _, e := range r.Errors {
+		if e.Status < 400 || e.Status > 599 {
+			return fmt.Errorf("rule %q: error status must be 4xx or 5xx, got %d", r.Name, e.Status)
+		}
+		if err := rate("errors.rate", e.Rate); err != nil {
+			return err
+		}
+	}
+	if err := rate("reset_rate", r.ResetRate); err != nil {
+		return err
+	}
+	if err := rate("corrupt_rate", r.CorruptRate); err != nil {
+		return err
+	}
+	return rate("panic_rate", r.PanicRate)
+}
+
+func splitRoute(route string) (method, path string, prefix bool) {
+	path = strings.TrimSpace(route)
+	if m, p, ok := strings.Cut(path, " "); ok {
+		method, path = m, strings.TrimSpace(p)
+	}
+	return method, path, strings.HasSuffix(path, "/")
+}
+
+func (r *Rule) matches(req *http.Request) bool {
+	if r.Route != "" {
+		method, path, prefix := splitRoute(r.Route)
+		if method != "" && method != req.Method {
+			return false
+		}
+		if prefix && !strings.HasPrefix(req.URL.Path, path) || !prefix && req.URL.Path != path {
+			return false
+		}
+	}
+	if r.Header != "" {
+		name, want, hasValue := strings.Cut(r.Header, "=")
+		got := req.Header.Values(name)
+		if len(got) == 0 || hasValue && got[0] != want {
+			return false
+		}
+	}
+	if r.Query != "" {
+		name, want, hasValue := strings.Cut(r.Query, "=")
+		got, ok := req.URL.Query()[name]
+		if !ok || hasValue && got[0] != want {
+			return false
+		}
+	}
+	return true
+}
+
+// Counts is how many faults of each kind have been injected.
+type Counts struct {
+	Delayed   uint64 `json:"delayed"`
+	Errors    uint64 `json:"errors"`
+	Resets    uint64 `json:"resets"`
+	Corrupted uint64 `json:"corrupted"`
+	Panics    uint64 `json:"panics"`
+}
+
+// Injector holds the active rules. It is safe for concurrent use; rules
+// can be swapped while requests are in flight.
+type Injector struct {
+	rules atomic.Pointer[[]Rule]
+
+	// Rand returns a value in [0, 1). Default math/rand.
+	Rand func() float64
+	// Skip, if set, exempts requests from every rule (e.g. the admin
+	// endpoint itself, so a 100% reset rule can still be removed).
+	Skip func(*http.Request) bool
+
+	mu     sync.Mutex
+	counts Counts
+}
+
+func New() *Injector {
+	in := &Injector{Rand: rand.Float64}
+	in.rules.Store(&[]Rule{})
+	return in
+}
+
+// SetRules validates and installs rules, replacing the current ones.
+func (in *Injector) SetRules(rules []Rule) error {
+	for i := range rules {
+		if err := rules[i].validate(); err != nil {
+			return err
+		}
+	}
+	if rules == nil {
+		rules = []Rule{}
+	}
+	in.rules.Store(&rules)
+	return nil
+}
+
+func (in *Injector) Rules() []Rule { return *in.rules.Load() }
+
+func (in *Injector) Counts() Counts {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.counts
+}
+
+func (in *Injector) count(f func(*Counts)) {
+	in.mu.Lock()
+	f(&in.counts)
+	in.mu.Unlock()
+}
+
+func (in *Injector) hit(rate float64) bool {
+	return rate > 0 && in.Rand() < rate
+}
+
+// Middleware applies the matching rules to each request before (or instead
+// of) calling next. Put it outside anything that wraps the ResponseWriter
+// without an Unwrap method, so resets reach the real connection.
+func (in *Injector) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rules := in.Rules()
+		if len(rules) == 0 || (in.Skip != nil && in.Skip(r)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		corrupt := false
+		for i := range rules {
+			rule := &rules[i]
+			if !rule.matches(r) {
+				continue
+			}
+			if l := rule.Latency; l != nil && in.hit(l.Rate) {
+				in.count(func(c *Counts) { c.Delayed++ })
+				if !sleep(r.Context(), in.draw(l)) {
+					return // client gave up during the delay
+				}
+			}
+			if in.hit(rule.PanicRate) {
+				in.count(func(c *Counts) { c.Panics++ })
+				panic(fmt.Sprintf("fault injection: panic (rule %q)", rule.Name))
+			}
+			if in.hit(rule.ResetRate) {
+				in.count(func(c *Counts) { c.Resets++ })
+				reset(w)
+				return
+			}
+			for _, e := range rule.Errors {
+				if in.hit(e.Rate) {
+					in.count(func(c *Counts) { c.Errors++ })
+					http.Error(w, fmt.Sprintf("fault injection: %d (rule %q)", e.Status, rule.Name), e.Status)
+					return
+				}
+			}
+			if in.hit(rule.CorruptRate) {
+				corrupt = true
+			}
+		}
+
+		if corrupt {
+			in.count(func(c *Counts) { c.Corrupted++ })
+			w = &corruptWriter{ResponseWriter: w, rand: in.Rand}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// draw picks a delay from l's distribution.
+func (in *Injector) draw(l *Latency) time.Duration {
+	var d float64
+	switch l.Dist {
+	case "fixed":
+		d = float64(l.Min)
+	case "uniform":
+		d = float64(l.Min) + in.Rand()*float64(l.Max-l.Min)
+	case "normal":
+		// Box-Muller from two uniform draws.
+		u1, u2 := max(in.Rand(), 1e-12), in.Rand()
+		d = float64(l.Mean) + float64(l.StdDev)*math.Sqrt(-2*math.Log(u1))*math.Cos(2*math.Pi*u2)
+	case "exponential":
+		d = -math.Log(1-in.Rand()) * float64(l.Mean)
+	}
+	d = math.Max(d, float64(l.Min))
+	if l.Max > 0 {
+		d = math.Min(d, float64(l.Max))
+	}
+	return time.Duration(d)
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// reset closes the connection with an RST and no response. If the
+// connection can't be taken over (HTTP/2), the handler is aborted instead,
+// which also leaves the client without a response.
+func reset(w http.ResponseWriter) {
+	conn, _, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	if tc, ok := conn.(interface{ SetLinger(int) error }); ok {
+		_ = tc.SetLinger(0)
+	}
+	_ = conn.Close()
+}
+
+// corruptWriter flips one random byte in every write of the body.
+type corruptWriter struct {
+	http.ResponseWriter
+	rand func() float64
+}
+
+func (cw *corruptWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return cw.ResponseWriter.Write(p)
+	}
+	bad := make([]byte, len(p))
+	copy(bad, p)
+	bad[int(cw.rand()*float64(len(bad)))] ^= 0xFF
+	return cw.ResponseWriter.Write(bad)
+}
+
+func (cw *corruptWriter) Unwrap() http.ResponseWriter { return cw.ResponseWriter }
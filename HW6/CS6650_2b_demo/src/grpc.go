@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"text/main/searchpb"
+)
+
+// grpcAddr is where the gRPC API listens (GRPC_ADDR, default :9090). It
+// serves the same store and search cache as the HTTP API.
+func grpcAddr() string {
+	if v := os.Getenv("GRPC_ADDR"); v != "" {
+		return v
+	}
+	return ":9090"
+}
+
+// streamBatch is how many products StreamSearch checks per store lock.
+const streamBatch = 1024
+
+type searchServer struct {
+	searchpb.UnimplementedProductSearchServer
+	store *productStore
+	cache *searchCache
+}
+
+func newGRPCServer(store *productStore, cache *searchCache) *grpc.Server {
+	srv := grpc.NewServer()
+	searchpb.RegisterProductSearchServer(srv, &searchServer{store: store, cache: cache})
+	return srv
+}
+
+func (s *searchServer) Search(ctx context.Context, req *searchpb.SearchRequest) (*searchpb.SearchResponse, error) {
+	start := time.Now()
+	resp, _ := searchProducts(s.store, s.cache, strings.TrimSpace(req.GetQ()))
+
+	out := &searchpb.SearchResponse{
+		Products:   make([]*searchpb.Product, len(resp.Products)),
+		TotalFound: int32(resp.TotalFound),
+	}
+	for i, p := range resp.Products {
+		out.Products[i] = toProto(p)
+	}
+	out.SearchTime = time.Since(start).String()
+	return out, nil
+}
+
+func (s *searchServer) Get(ctx context.Context, req *searchpb.GetRequest) (*searchpb.Product, error) {
+	if req.GetId() < 1 {
+		return nil, status.Error(codes.InvalidArgument, "id must be a positive integer")
+	}
+	p, ok := s.store.get(int(req.GetId()))
+	if !ok {
+		return nil, status.Error(codes.NotFound, errProductNotFound.Error())
+	}
+	return toProto(p), nil
+}
+
+// StreamSearch walks the whole catalog a batch at a time (not just the first
+// 100 products) and sends every match, stopping early at req.Limit or when
+// the client goes away.
+func (s *searchServer) StreamSearch(req *searchpb.SearchRequest, stream searchpb.ProductSearch_StreamSearchServer) error {
+	if req.GetLimit() < 0 {
+		return status.Error(codes.InvalidArgument, "limit must be >= 0")
+	}
+	q := strings.TrimSpace(req.GetQ())
+	limit := int(req.GetLimit())
+
+	sent := 0
+	var buf []Product
+	for pos := 0; pos >= 0; {
+		if err := stream.Context().Err(); err != nil {
+			return status.FromContextError(err).Err()
+		}
+		buf, pos = s.store.scan(q, pos, streamBatch, buf[:0])
+		for _, p := range buf {
+			if err := stream.Send(toProto(p)); err != nil {
+				return err
+			}
+			sent++
+			if limit > 0 && sent >= limit {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+func toProto(p Product) *searchpb.Product {
+	return &searchpb.Product{
+		Id:          int64(p.ID),
+		Name:        p.Name,
+		Category:    p.Category,
+		Description: p.Description,
+		Brand:       p.Brand,
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"text/main/searchpb"
+)
+
+// setupGRPCForTest serves the gRPC API over an in-process bufconn listener.
+func setupGRPCForTest(t *testing.T, n int) (*productStore, searchpb.ProductSearchClient) {
+	t.Helper()
+	store := newProductStore()
+	seedProducts(store, n)
+	cache := newSearchCache(time.Minute, 1<<20)
+	store.onWrite = cache.invalidate
+
+	lis := bufconn.Listen(1 << 20)
+	srv := newGRPCServer(store, cache)
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return store, searchpb.NewProductSearchClient(conn)
+}
+
+func TestGRPCSearchMatchesHTTPSemantics(t *testing.T) {
+	_, client := setupGRPCForTest(t, 1000)
+
+	resp, err := client.Search(context.Background(), &searchpb.SearchRequest{Q: "Electronics"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 8 categories round-robin: 100 checked -> 12 or 13 electronics.
+	if resp.TotalFound < 12 || resp.TotalFound > 13 || len(resp.Products) != int(resp.TotalFound) {
+		t.Fatalf("total_found=%d products=%d", resp.TotalFound, len(resp.Products))
+	}
+	for _, p := range resp.Products {
+		if p.Category != "Electronics" {
+			t.Fatalf("non-matching product %v", p)
+		}
+	}
+	if resp.SearchTime == "" {
+		t.Fatal("search_time not set")
+	}
+}
+
+func TestGRPCGet(t *testing.T) {
+	store, client := setupGRPCForTest(t, 10)
+
+	p, err := client.Get(context.Background(), &searchpb.GetRequest{Id: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := store.get(7)
+	if p.Id != 7 || p.Name != want.Name || p.Brand != want.Brand {
+		t.Fatalf("Get(7) = %v, want %+v", p, want)
+	}
+
+	_, err = client.Get(context.Background(), &searchpb.GetRequest{Id: 99})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("Get(99) err = %v, want NotFound", err)
+	}
+	_, err = client.Get(context.Background(), &searchpb.GetRequest{Id: 0})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Get(0) err = %v, want InvalidArgument", err)
+	}
+}
+
+func TestGRPCSeesHTTPWrites(t *testing.T) {
+	store, client := setupGRPCForTest(t, 10)
+
+	if _, err := client.Search(context.Background(), &searchpb.SearchRequest{Q: "zebra"}); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = store.add(Product{Name: "Zebra Lamp"})
+
+	resp, err := client.Search(context.Background(), &searchpb.SearchRequest{Q: "zebra"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.TotalFound != 1 {
+		t.Fatalf("search after write total_found = %d, want 1", resp.TotalFound)
+	}
+}
+
+func collectStream(t *testing.T, client searchpb.ProductSearchClient, req *searchpb.SearchRequest) []*searchpb.Product {
+	t.Helper()
+	stream, err := client.StreamSearch(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []*searchpb.Product
+	for {
+		p, err := stream.Recv()
+		if err == io.EOF {
+			return out
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, p)
+	}
+}
+
+func TestGRPCStreamSearch(t *testing.T) {
+	_, client := setupGRPCForTest(t, 5000)
+
+	// Beyond the first 100: every Books product in the catalog, in order.
+	all := collectStream(t, client, &searchpb.SearchRequest{Q: "books"})
+	if len(all) != 5000/8 {
+		t.Fatalf("streamed %d products, want %d", len(all), 5000/8)
+	}
+	for i := 1; i < len(all); i++ {
+		if all[i].Id <= all[i-1].Id || all[i].Category != "Books" {
+			t.Fatalf("stream out of order or non-matching at %d: %v", i, all[i])
+		}
+	}
+
+	limited := collectStream(t, client, &searchpb.SearchRequest{Q: "books", Limit: 10})
+	if len(limited) != 10 {
+		t.Fatalf("limited stream sent %d, want 10", len(limited))
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTempFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadJSONL(t *testing.T) {
+	path := writeTempFile(t, "products.jsonl", `{"id":7,"name":"Kettle","category":"Home","brand":"Nova"}
+
+{"name":"Toaster","category":"Home","brand":"Zen"}
+`)
+	l, err := datasetConfig{File: path}.loader()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := newProductStore()
+	stats, err := loadProducts(store, l)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if stats.Products != 2 {
+		t.Fatalf("loaded %d products, want 2", stats.Products)
+	}
+	if p, ok := store.get(8); !ok || p.Name != "Toaster" {
+		t.Fatalf("get(8) = %+v, %v; want auto-assigned Toaster", p, ok)
+	}
+}
+
+func TestLoadJSONLBadLine(t *testing.T) {
+	path := writeTempFile(t, "products.jsonl", "{\"name\":\"ok\"}\n{broken\n")
+	_, err := loadProducts(newProductStore(), jsonlLoader{path: path})
+	if err == nil {
+		t.Fatal("expected an error for a malformed line")
+	}
+}
+
+func TestLoadCSV(t *testing.T) {
+	path := writeTempFile(t, "products.csv", "brand,name,id,category\nNova,\"Lamp, Desk\",3,Home\nZen,Mug,,Kitchen\n")
+	l, err := datasetConfig{File: path}.loader()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := newProductStore()
+	if _, err := loadProducts(store, l); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if p, _ := store.get(3); p.Name != "Lamp, Desk" || p.Brand != "Nova" || p.Category != "Home" {
+		t.Fatalf("get(3) = %+v", p)
+	}
+	if p, _ := store.get(4); p.Name != "Mug" {
+		t.Fatalf("get(4) = %+v, want Mug", p)
+	}
+}
+
+func TestLoaderUnknownFormat(t *testing.T) {
+	if _, err := (datasetConfig{File: "products.txt"}).loader(); err == nil {
+		t.Fatal("expected an error for an unknown extension")
+	}
+	if _, err := (datasetConfig{File: "products.txt", Format: "csv"}).loader(); err != nil {
+		t.Fatalf("explicit format: %v", err)
+	}
+}
+
+func TestSyntheticZipfReproducibleAndSkewed(t *testing.T) {
+	gen := func(seed int64) []Product {
+		var out []Product
+		l := syntheticLoader{count: 5000, seed: seed, zipfS: 1.5, brands: defaultBrands, categories: defaultCategories}
+		_ = l.Load(func(p Product) error {
+			out = append(out, p)
+			return nil
+		})
+		return out
+	}
+
+	a, b := gen(42), gen(42)
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("same seed differs at %d: %+v vs %+v", i, a[i], b[i])
+		}
+	}
+
+	counts := map[string]int{}
+	for _, p := range a {
+		counts[p.Brand]++
+	}
+	first, last := counts[defaultBrands[0]], counts[defaultBrands[len(defaultBrands)-1]]
+	if first <= 3*last {
+		t.Fatalf("brand counts not skewed: %s=%d, %s=%d", defaultBrands[0], first, defaultBrands[len(defaultBrands)-1], last)
+	}
+
+	c := gen(43)
+	same := true
+	for i := range a {
+		if a[i] != c[i] {
+			same = false
+			break
+		}
+	}
+	if same {
+		t.Fatal("different seeds produced identical datasets")
+	}
+}
+
+func TestDatasetConfigFromEnv(t *testing.T) {
+	t.Setenv("PRODUCTS_COUNT", "50")
+	t.Setenv("PRODUCTS_ZIPF_S", "1.2")
+	t.Setenv("PRODUCTS_BRANDS", "A, B ,,C")
+
+	cfg, err := datasetConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Count != 50 || cfg.ZipfS != 1.2 || len(cfg.Brands) != 3 || cfg.Brands[1] != "B" {
+		t.Fatalf("cfg = %+v", cfg)
+	}
+
+	t.Setenv("PRODUCTS_ZIPF_S", "0.5")
+	if _, err := datasetConfigFromEnv(); err == nil {
+		t.Fatal("expected an error for PRODUCTS_ZIPF_S <= 1")
+	}
+}
@@ -2,11 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 )
 
@@ -18,19 +20,68 @@ type Product struct {
 	Brand       string `json:"brand"`
 }
 
+// ProductPage is the body of GET /products. Products is last so the
+// listing can be streamed after the fields known up front.
+type ProductPage struct {
+	Offset   int       `json:"offset"`
+	Limit    int       `json:"limit"`
+	Total    int       `json:"total"`
+	Products []Product `json:"products"`
+}
+
 type SearchResponse struct {
 	Products   []Product `json:"products"`
 	TotalFound int       `json:"total_found"`
 	SearchTime string    `json:"search_time,omitempty"`
 }
 
-var (
-	store sync.Map // key: int, value: Product
-)
-
 func main() {
-	seedProducts(100_000)
+	cfg, err := datasetConfigFromEnv()
+	if err != nil {
+		log.Fatalf("dataset config: %v", err)
+	}
+	loader, err := cfg.loader()
+	if err != nil {
+		log.Fatalf("dataset config: %v", err)
+	}
+
+	cache, err := searchCacheFromEnv()
+	if err != nil {
+		log.Fatalf("search cache config: %v", err)
+	}
+
+	store := newProductStore()
+	stats, err := loadProducts(store, loader)
+	if err != nil {
+		log.Fatalf("load products: %v", err)
+	}
+	log.Printf("loaded %d products from %s in %s (heap +%.1f MiB, %.1f MiB live)",
+		stats.Products, loader, stats.Duration,
+		float64(stats.HeapDelta)/(1<<20), float64(stats.HeapTotal)/(1<<20))
+	store.onWrite = cache.invalidate
+
+	lis, err := net.Listen("tcp", grpcAddr())
+	if err != nil {
+		log.Fatalf("grpc listen: %v", err)
+	}
+	go func() {
+		log.Printf("gRPC ProductSearch listening on %s", lis.Addr())
+		log.Fatal(newGRPCServer(store, cache).Serve(lis))
+	}()
+
+	faultInjector := newFaultInjector()
+	if err := faultRulesFromEnv(faultInjector); err != nil {
+		log.Fatalf("fault injection config: %v", err)
+	}
+	mux := newMux(store, cache)
+	mux.Handle("/admin/faults", withAdminToken(faultInjector.AdminHandler()))
 
+	addr := ":8080"
+	log.Printf("Product search service listening on %s (loaded %d products)", addr, store.len())
+	log.Fatal(http.ListenAndServe(addr, withLogging(faultInjector.Middleware(withCompression(mux, compressMinBytes)))))
+}
+
+func newMux(store *productStore, cache *searchCache) *http.ServeMux {
 	mux := http.NewServeMux()
 
 	// Health endpoint (required for ALB health checks in Part III)
@@ -40,83 +91,191 @@ func main() {
 	})
 
 	// Search endpoint: /products/search?q=...
-	mux.HandleFunc("/products/search", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("GET /products/search", func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 		q := strings.TrimSpace(r.URL.Query().Get("q"))
-		qLower := strings.ToLower(q)
 
-		checked := 0      // MUST count EVERY product checked
-		totalMatches := 0 // total matches found among checked
-		results := make([]Product, 0, 20)
+		resp, how := searchProducts(store, cache, q)
+		resp.SearchTime = time.Since(start).String()
 
-		// Critical requirement: check EXACTLY 100 products then stop.
-		store.Range(func(_, v any) bool {
-			if checked >= 100 {
-				return false // stop iteration
-			}
+		w.Header().Set("X-Cache", string(how))
+		writeJSON(w, http.StatusOK, resp)
+	})
 
-			p := v.(Product)
-			checked++ // count EVERY product checked, not just matches
+	// Listing endpoint: /products?offset=0&limit=1000. Large pages are
+	// streamed batch by batch instead of being built up first.
+	mux.HandleFunc("GET /products", func(w http.ResponseWriter, r *http.Request) {
+		offset, limit, ok := pageParams(w, r)
+		if !ok {
+			return
+		}
+		streamProducts(w, store, offset, limit)
+	})
 
-			// Case-insensitive match on name OR category
-			if qLower == "" ||
-				strings.Contains(strings.ToLower(p.Name), qLower) ||
-				strings.Contains(strings.ToLower(p.Category), qLower) {
+	// Cache hit/miss counters, entry count and byte usage.
+	mux.HandleFunc("GET /debug/cache", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, cache.snapshot())
+	})
 
-				totalMatches++
-				if len(results) < 20 {
-					results = append(results, p)
-				}
-			}
-			return true
-		})
+	// Write API: changes land in the store (and its search fields) under one
+	// lock, so the next search already sees them.
+	mux.HandleFunc("GET /products/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+		p, found := store.get(id)
+		if !found {
+			http.Error(w, errProductNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, p)
+	})
 
-		resp := SearchResponse{
-			Products:   results,
-			TotalFound: totalMatches,
-			SearchTime: time.Since(start).String(),
+	mux.HandleFunc("POST /products", func(w http.ResponseWriter, r *http.Request) {
+		p, ok := decodeProduct(w, r)
+		if !ok {
+			return
 		}
+		created, err := store.add(p)
+		if errors.Is(err, errProductExists) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeJSON(w, http.StatusCreated, created)
+	})
 
-		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(resp)
+	mux.HandleFunc("PUT /products/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+		p, ok := decodeProduct(w, r)
+		if !ok {
+			return
+		}
+		if p.ID != 0 && p.ID != id {
+			http.Error(w, "body id must match path id", http.StatusBadRequest)
+			return
+		}
+		p.ID = id
+		if err := store.update(p); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, p)
 	})
 
-	addr := ":8080"
-	log.Printf("Product search service listening on %s (loaded 100k products)", addr)
-	log.Fatal(http.ListenAndServe(addr, withLogging(mux)))
+	mux.HandleFunc("DELETE /products/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+		if err := store.remove(id); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	return mux
 }
 
-func withLogging(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		t0 := time.Now()
-		next.ServeHTTP(w, r)
-		log.Printf("%s %s (%s)", r.Method, r.URL.Path, time.Since(t0))
+// searchProducts is the search behind both the HTTP and gRPC APIs.
+func searchProducts(store *productStore, cache *searchCache, q string) (SearchResponse, cacheResult) {
+	return cache.get(searchCacheKey(q), func() SearchResponse {
+		// Critical requirement: check EXACTLY 100 products then stop.
+		results, totalMatches, _ := store.search(q, 100, 20)
+		return SearchResponse{Products: results, TotalFound: totalMatches}
 	})
 }
 
-func seedProducts(n int) {
-	brands := []string{"Alpha", "Bravo", "Cyan", "Delta", "Echo", "Nova", "Zen", "Kappa"}
-	categories := []string{"Electronics", "Books", "Home", "Clothing", "Sports", "Toys", "Beauty", "Grocery"}
-	descs := []string{
-		"Everyday quality item",
-		"High performance option",
-		"Budget-friendly pick",
-		"Premium build and feel",
-		"Popular choice for most users",
+func writeJSON(w http.ResponseWriter, status int, payload any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(payload)
+}
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 100_000
+)
+
+func pageParams(w http.ResponseWriter, r *http.Request) (offset, limit int, ok bool) {
+	q := r.URL.Query()
+	offset, limit = 0, defaultListLimit
+	var err error
+	if v := q.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			http.Error(w, "offset must be a non-negative integer", http.StatusBadRequest)
+			return 0, 0, false
+		}
 	}
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxListLimit {
+			http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxListLimit), http.StatusBadRequest)
+			return 0, 0, false
+		}
+	}
+	return offset, limit, true
+}
 
-	for i := 1; i <= n; i++ {
-		brand := brands[i%len(brands)]
-		category := categories[i%len(categories)]
-		desc := descs[i%len(descs)]
-
-		p := Product{
-			ID:          i,
-			Name:        "Product " + brand + " " + strconv.Itoa(i),
-			Category:    category,
-			Description: desc,
-			Brand:       brand,
+// streamProducts writes a ProductPage, copying products out of the store a
+// batch at a time. It's not a snapshot: writes can land between batches.
+func streamProducts(w http.ResponseWriter, store *productStore, offset, limit int) {
+	prefix := fmt.Sprintf(`{"offset":%d,"limit":%d,"total":%d,"products":`, offset, limit, store.len())
+	out := startJSONArray(w, http.StatusOK, prefix)
+
+	buf := make([]Product, 256)
+	for sent := 0; sent < limit; {
+		batch := store.page(offset+sent, buf[:min(len(buf), limit-sent)])
+		if len(batch) == 0 {
+			break
+		}
+		for _, p := range batch {
+			if err := out.add(p); err != nil {
+				return // client went away
+			}
 		}
-		store.Store(i, p)
+		sent += len(batch)
+	}
+	_ = out.close("}")
+}
+
+func pathID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.Error(w, "id must be a positive integer", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+func decodeProduct(w http.ResponseWriter, r *http.Request) (Product, bool) {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	var p Product
+	if err := dec.Decode(&p); err != nil {
+		http.Error(w, "body must be a JSON product", http.StatusBadRequest)
+		return Product{}, false
+	}
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return Product{}, false
 	}
+	if p.ID < 0 {
+		http.Error(w, "id must be a positive integer", http.StatusBadRequest)
+		return Product{}, false
+	}
+	return p, true
+}
+
+func withLogging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t0 := time.Now()
+		next.ServeHTTP(w, r)
+		log.Printf("%s %s (%s)", r.Method, r.URL.Path, time.Since(t0))
+	})
 }
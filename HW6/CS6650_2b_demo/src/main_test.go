@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func setupStoreForTest(n int) (*productStore, http.Handler) {
+	store := newProductStore()
+	seedProducts(store, n)
+	cache := newSearchCache(time.Minute, 1<<20)
+	store.onWrite = cache.invalidate
+	return store, newMux(store, cache)
+}
+
+func doRequest(t *testing.T, h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func decodeSearch(t *testing.T, w *httptest.ResponseRecorder) SearchResponse {
+	t.Helper()
+	var resp SearchResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("bad search json %q: %v", w.Body.String(), err)
+	}
+	return resp
+}
+
+func TestCreateProductVisibleInSearch(t *testing.T) {
+	_, h := setupStoreForTest(10)
+
+	w := doRequest(t, h, http.MethodPost, "/products", `{"name":"Widget Zebra","category":"Garden","brand":"Nova"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /products status = %d, want %d (%s)", w.Code, http.StatusCreated, w.Body.String())
+	}
+	var created Product
+	_ = json.Unmarshal(w.Body.Bytes(), &created)
+	if created.ID != 11 {
+		t.Fatalf("created id = %d, want 11", created.ID)
+	}
+
+	resp := decodeSearch(t, doRequest(t, h, http.MethodGet, "/products/search?q=zebra", ""))
+	if resp.TotalFound != 1 || len(resp.Products) != 1 || resp.Products[0].ID != 11 {
+		t.Fatalf("search after create = %+v, want product 11", resp)
+	}
+}
+
+func TestCreateProductConflict(t *testing.T) {
+	_, h := setupStoreForTest(3)
+
+	w := doRequest(t, h, http.MethodPost, "/products", `{"id":2,"name":"Dup"}`)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("POST duplicate id status = %d, want %d", w.Code, http.StatusConflict)
+	}
+}
+
+func TestCreateProductInvalid(t *testing.T) {
+	_, h := setupStoreForTest(3)
+
+	for _, body := range []string{`not-json`, `{"name":""}`, `{"name":"x","color":"red"}`} {
+		w := doRequest(t, h, http.MethodPost, "/products", body)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("POST %s status = %d, want %d", body, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestUpdateProductVisibleInSearch(t *testing.T) {
+	_, h := setupStoreForTest(10)
+
+	w := doRequest(t, h, http.MethodPut, "/products/4", `{"name":"Renamed Quokka","category":"Toys"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT /products/4 status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	resp := decodeSearch(t, doRequest(t, h, http.MethodGet, "/products/search?q=quokka", ""))
+	if resp.TotalFound != 1 || resp.Products[0].ID != 4 {
+		t.Fatalf("search after update = %+v, want product 4", resp)
+	}
+
+	w = doRequest(t, h, http.MethodPut, "/products/99", `{"name":"Ghost"}`)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("PUT missing status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	w = doRequest(t, h, http.MethodPut, "/products/4", `{"id":5,"name":"Mismatch"}`)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("PUT mismatched id status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestDeleteProduct(t *testing.T) {
+	store, h := setupStoreForTest(10)
+
+	w := doRequest(t, h, http.MethodDelete, "/products/3", "")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE /products/3 status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if w := doRequest(t, h, http.MethodGet, "/products/3", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("GET deleted product status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := doRequest(t, h, http.MethodDelete, "/products/3", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("second DELETE status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	// Later products keep their order and stay reachable by ID.
+	resp := decodeSearch(t, doRequest(t, h, http.MethodGet, "/products/search", ""))
+	if resp.TotalFound != 9 || resp.Products[2].ID != 4 {
+		t.Fatalf("search after delete = %+v, want 9 products with id 4 third", resp)
+	}
+	if p, ok := store.get(10); !ok || p.ID != 10 {
+		t.Fatalf("get(10) after delete = %+v, %v", p, ok)
+	}
+}
+
+// TestConcurrentWritesAndSearches is meant for `go test -race`: writers
+// create, update and delete products while readers search, and every search
+// must still come back internally consistent.
+func TestConcurrentWritesAndSearches(t *testing.T) {
+	_, h := setupStoreForTest(200)
+
+	var wg sync.WaitGroup
+	for wr := 0; wr < 4; wr++ {
+		wg.Add(1)
+		go func(wr int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				id := 1000 + wr*100 + i
+				doRequest(t, h, http.MethodPost, "/products", fmt.Sprintf(`{"id":%d,"name":"Racer %d","category":"Sports"}`, id, id))
+				doRequest(t, h, http.MethodPut, fmt.Sprintf("/products/%d", 1+(wr*50+i)%200), `{"name":"Updated Racer","category":"Sports"}`)
+				if i%2 == 0 {
+					doRequest(t, h, http.MethodDelete, fmt.Sprintf("/products/%d", id), "")
+				}
+			}
+		}(wr)
+	}
+
+	for rd := 0; rd < 4; rd++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				w := doRequest(t, h, http.MethodGet, "/products/search?q=racer", "")
+				if w.Code != http.StatusOK {
+					t.Errorf("search status = %d", w.Code)
+					return
+				}
+				var resp SearchResponse
+				if err := json.NewDecoder(bytes.NewReader(w.Body.Bytes())).Decode(&resp); err != nil {
+					t.Errorf("bad search json: %v", err)
+					return
+				}
+				if resp.TotalFound > 100 || len(resp.Products) > 20 || len(resp.Products) > resp.TotalFound {
+					t.Errorf("inconsistent search response: %+v", resp)
+					return
+				}
+				for _, p := range resp.Products {
+					if !strings.Contains(strings.ToLower(p.Name), "racer") {
+						t.Errorf("search returned non-matching product %+v", p)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,7 @@
+// Package searchpb holds the generated protobuf and gRPC code for the
+// ProductSearch service. Regenerate after editing search.proto with:
+//
+//	go generate ./searchpb
+package searchpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative search.proto
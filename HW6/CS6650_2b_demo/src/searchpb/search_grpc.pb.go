@@ -0,0 +1,209 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.28.3
+// source: search.proto
+
+// gRPC mirror of the HTTP search API, served from the same in-memory store.
+
+package searchpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	ProductSearch_Search_FullMethodName       = "/productsearch.v1.ProductSearch/Search"
+	ProductSearch_Get_FullMethodName          = "/productsearch.v1.ProductSearch/Get"
+	ProductSearch_StreamSearch_FullMethodName = "/productsearch.v1.ProductSearch/StreamSearch"
+)
+
+// ProductSearchClient is the client API for ProductSearch service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ProductSearchClient interface {
+	// Search has the same semantics as GET /products/search: exactly 100
+	// products checked, at most 20 returned.
+	Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error)
+	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*Product, error)
+	// StreamSearch scans the whole catalog and streams every match.
+	StreamSearch(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Product], error)
+}
+
+type productSearchClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewProductSearchClient(cc grpc.ClientConnInterface) ProductSearchClient {
+	return &productSearchClient{cc}
+}
+
+func (c *productSearchClient) Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SearchResponse)
+	err := c.cc.Invoke(ctx, ProductSearch_Search_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *productSearchClient) Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*Product, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Product)
+	err := c.cc.Invoke(ctx, ProductSearch_Get_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *productSearchClient) StreamSearch(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Product], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ProductSearch_ServiceDesc.Streams[0], ProductSearch_StreamSearch_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SearchRequest, Product]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ProductSearch_StreamSearchClient = grpc.ServerStreamingClient[Product]
+
+// ProductSearchServer is the server API for ProductSearch service.
+// All implementations must embed UnimplementedProductSearchServer
+// for forward compatibility.
+type ProductSearchServer interface {
+	// Search has the same semantics as GET /products/search: exactly 100
+	// products checked, at most 20 returned.
+	Search(context.Context, *SearchRequest) (*SearchResponse, error)
+	Get(context.Context, *GetRequest) (*Product, error)
+	// StreamSearch scans the whole catalog and streams every match.
+	StreamSearch(*SearchRequest, grpc.ServerStreamingServer[Product]) error
+	mustEmbedUnimplementedProductSearchServer()
+}
+
+// UnimplementedProductSearchServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedProductSearchServer struct{}
+
+func (UnimplementedProductSearchServer) Search(context.Context, *SearchRequest) (*SearchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Search not implemented")
+}
+func (UnimplementedProductSearchServer) Get(context.Context, *GetRequest) (*Product, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedProductSearchServer) StreamSearch(*SearchRequest, grpc.ServerStreamingServer[Product]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamSearch not implemented")
+}
+func (UnimplementedProductSearchServer) mustEmbedUnimplementedProductSearchServer() {}
+func (UnimplementedProductSearchServer) testEmbeddedByValue()                       {}
+
+// UnsafeProductSearchServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ProductSearchServer will
+// result in compilation errors.
+type UnsafeProductSearchServer interface {
+	mustEmbedUnimplementedProductSearchServer()
+}
+
+func RegisterProductSearchServer(s grpc.ServiceRegistrar, srv ProductSearchServer) {
+	// If the following call pancis, it indicates UnimplementedProductSearchServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&ProductSearch_ServiceDesc, srv)
+}
+
+func _ProductSearch_Search_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProductSearchServer).Search(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ProductSearch_Search_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProductSearchServer).Search(ctx, req.(*SearchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ProductSearch_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProductSearchServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ProductSearch_Get_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProductSearchServer).Get(ctx, req.(*GetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ProductSearch_StreamSearch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SearchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ProductSearchServer).StreamSearch(m, &grpc.GenericServerStream[SearchRequest, Product]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ProductSearch_StreamSearchServer = grpc.ServerStreamingServer[Product]
+
+// ProductSearch_ServiceDesc is the grpc.ServiceDesc for ProductSearch service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ProductSearch_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "productsearch.v1.ProductSearch",
+	HandlerType: (*ProductSearchServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Search",
+			Handler:    _ProductSearch_Search_Handler,
+		},
+		{
+			MethodName: "Get",
+			Handler:    _ProductSearch_Get_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamSearch",
+			Handler:       _ProductSearch_StreamSearch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "search.proto",
+}
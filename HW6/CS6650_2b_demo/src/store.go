@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+var (
+	errProductNotFound = errors.New("product not found")
+	errProductExists   = errors.New("product already exists")
+)
+
+// indexedProduct keeps the lowercased search fields next to the product so
+// the scan doesn't call strings.ToLower on every check.
+type indexedProduct struct {
+	Product
+	nameLower     string
+	categoryLower string
+}
+
+// matches reports whether qLower (already lowercased) is in the name or
+// category. An empty query matches everything.
+func (p *indexedProduct) matches(qLower string) bool {
+	return qLower == "" ||
+		strings.Contains(p.nameLower, qLower) ||
+		strings.Contains(p.categoryLower, qLower)
+}
+
+func indexProduct(p Product) indexedProduct {
+	return indexedProduct{
+		Product:       p,
+		nameLower:     strings.ToLower(p.Name),
+		categoryLower: strings.ToLower(p.Category),
+	}
+}
+
+// productStore is the in-memory catalog. Products live in insertion order
+// (so "check exactly 100 products" always scans the same ones) and byID maps
+// an ID to its position. One RWMutex guards both, so a write is applied to
+// the slice and the index together and the next search sees all of it.
+type productStore struct {
+	mu     sync.RWMutex
+	items  []indexedProduct
+	byID   map[int]int // product ID -> index in items
+	nextID int
+
+	// onWrite, if set, runs after every successful write while the write
+	// lock is still held, so anything derived from the store (the search
+	// cache) is invalidated before the next reader can see the change.
+	onWrite func()
+}
+
+func newProductStore() *productStore {
+	return &productStore{byID: make(map[int]int), nextID: 1}
+}
+
+// add inserts p. A zero ID means "assign the next free one".
+func (s *productStore) add(p Product) (Product, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p.ID == 0 {
+		p.ID = s.nextID
+	}
+	if _, ok := s.byID[p.ID]; ok {
+		return Product{}, errProductExists
+	}
+	if p.ID >= s.nextID {
+		s.nextID = p.ID + 1
+	}
+	s.byID[p.ID] = len(s.items)
+	s.items = append(s.items, indexProduct(p))
+	s.written()
+	return p, nil
+}
+
+// update replaces the product with p.ID in place, keeping its scan position.
+func (s *productStore) update(p Product) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.byID[p.ID]
+	if !ok {
+		return errProductNotFound
+	}
+	s.items[i] = indexProduct(p)
+	s.written()
+	return nil
+}
+
+// remove deletes a product and shifts the rest down so scan order is kept.
+// That's O(n), which is fine for a 100k catalog with occasional writes.
+func (s *productStore) remove(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.byID[id]
+	if !ok {
+		return errProductNotFound
+	}
+	copy(s.items[i:], s.items[i+1:])
+	s.items[len(s.items)-1] = indexedProduct{}
+	s.items = s.items[:len(s.items)-1]
+	delete(s.byID, id)
+	for j := i; j < len(s.items); j++ {
+		s.byID[s.items[j].ID] = j
+	}
+	s.written()
+	return nil
+}
+
+func (s *productStore) written() {
+	if s.onWrite != nil {
+		s.onWrite()
+	}
+}
+
+func (s *productStore) get(id int) (Product, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i, ok := s.byID[id]
+	if !ok {
+		return Product{}, false
+	}
+	return s.items[i].Product, true
+}
+
+// page copies up to len(buf) products starting at position from into buf
+// and returns the filled part. Listings call it batch by batch, so the lock
+// is only held for one batch and writes can interleave between batches.
+func (s *productStore) page(from int, buf []Product) []Product {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n := 0
+	for i := from; i < len(s.items) && n < len(buf); i++ {
+		buf[n] = s.items[i].Product
+		n++
+	}
+	return buf[:n]
+}
+
+func (s *productStore) len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.items)
+}
+
+// search checks at most maxChecked products (in store order) and returns up
+// to maxResults of those whose name or category contains q, case-insensitive.
+// An empty q matches everything.
+func (s *productStore) search(q string, maxChecked, maxResults int) (results []Product, totalFound, checked int) {
+	qLower := strings.ToLower(q)
+	results = make([]Product, 0, maxResults)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for i := range s.items {
+		if checked >= maxChecked {
+			break
+		}
+		checked++ // count EVERY product checked, not just matches
+
+		if p := &s.items[i]; p.matches(qLower) {
+			totalFound++
+			if len(results) < maxResults {
+				results = append(results, p.Product)
+			}
+		}
+	}
+	return results, totalFound, checked
+}
+
+// scan is the uncapped search used for streaming: it checks up to batch
+// products starting at position from, appends the matches to dst, and
+// returns them with the position to continue from (-1 at the end). Each
+// call holds the read lock for one batch only.
+func (s *productStore) scan(q string, from, batch int, dst []Product) ([]Product, int) {
+	qLower := strings.ToLower(q)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	end := min(from+batch, len(s.items))
+	for i := from; i < end; i++ {
+		if p := &s.items[i]; p.matches(qLower) {
+			dst = append(dst, p.Product)
+		}
+	}
+	if end >= len(s.items) {
+		return dst, -1
+	}
+	return dst, end
+}
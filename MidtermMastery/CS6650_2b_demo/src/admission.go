@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"text/main/admission"
+)
+
+// admissionControl sheds requests when the server is overloaded; nil turns
+// shedding off. main configures it from the SHED_* env vars.
+var admissionControl *admission.Controller
+
+// shedRetryAfter is what shed clients are told to wait.
+var shedRetryAfter = time.Second
+
+// admissionFromEnv reads SHED_MAX_INFLIGHT (default 100, 0 = no shedding),
+// SHED_MAX_QUEUE (default SHED_MAX_INFLIGHT), SHED_LOW_SHARE (0.5),
+// SHED_TARGET (5ms), SHED_INTERVAL (100ms) and SHED_RETRY_AFTER (1s).
+func admissionFromEnv() (*admission.Controller, time.Duration, error) {
+	cfg := admission.Config{MaxInFlight: 100}
+	retryAfter := time.Second
+
+	var err error
+	intVar := func(name string, dst *int) {
+		if v := os.Getenv(name); v != "" && err == nil {
+			if *dst, err = strconv.Atoi(v); err != nil || *dst < 0 {
+				err = fmt.Errorf("%s must be a non-negative integer, got %q", name, v)
+			}
+		}
+	}
+	durVar := func(name string, dst *time.Duration) {
+		if v := os.Getenv(name); v != "" && err == nil {
+			if *dst, err = time.ParseDuration(v); err != nil || *dst < 0 {
+				err = fmt.Errorf("%s must be a non-negative duration, got %q", name, v)
+			}
+		}
+	}
+	intVar("SHED_MAX_INFLIGHT", &cfg.MaxInFlight)
+	intVar("SHED_MAX_QUEUE", &cfg.MaxQueue)
+	durVar("SHED_TARGET", &cfg.Target)
+	durVar("SHED_INTERVAL", &cfg.Interval)
+	durVar("SHED_RETRY_AFTER", &retryAfter)
+	if v := os.Getenv("SHED_LOW_SHARE"); v != "" && err == nil {
+		if cfg.LowShare, err = strconv.ParseFloat(v, 64); err != nil || cfg.LowShare <= 0 || cfg.LowShare > 1 {
+			err = fmt.Errorf("SHED_LOW_SHARE must be in (0, 1], got %q", v)
+		}
+	}
+	if err != nil || cfg.MaxInFlight == 0 {
+		return nil, retryAfter, err
+	}
+	return admission.New(cfg), retryAfter, nil
+}
+
+// infrastructurePath reports whether path is one of the endpoints that are
+// never shed or rate limited: health checks, /version, the debug, admin and
+// internal endpoints, and the simulated downstream (which stands in for a
+// separate service).
+func infrastructurePath(p string) bool {
+	return p == "/health" || p == "/version" || p == "/downstream" || isAdminPath(p) ||
+		strings.HasPrefix(p, "/debug/") || strings.HasPrefix(p, "/internal/")
+}
+
+func isAdminPath(p string) bool { return strings.HasPrefix(p, "/admin/") }
+
+// requestPriority classes a request for admission. Infrastructure paths are
+// critical; everything else takes its class from X-Priority (critical,
+// normal or low; default normal).
+func requestPriority(r *http.Request) admission.Priority {
+	if infrastructurePath(r.URL.Path) {
+		return admission.Critical
+	}
+	return admission.ParsePriority(r.Header.Get("X-Priority"))
+}
+
+// withAdmission answers 429 with Retry-After instead of taking on work the
+// server can't get to in time, and 503 with Retry-After when the request's
+// own deadline passes while it is queued.
+func withAdmission(next http.Handler, c *admission.Controller, retryAfter time.Duration) http.Handler {
+	if c == nil {
+		return next
+	}
+	secs := strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		release, err := c.Admit(r.Context(), requestPriority(r))
+		if err != nil {
+			switch ctxErr := r.Context().Err(); {
+			case errors.Is(ctxErr, context.Canceled):
+				return // client gave up while queued; nobody to answer
+			case errors.Is(ctxErr, context.DeadlineExceeded):
+				// The client is still there; its deadline passed in the queue.
+				w.Header().Set("Retry-After", secs)
+				http.Error(w, "request deadline passed while queued, retry later", http.StatusServiceUnavailable)
+				return
+			}
+			w.Header().Set("Retry-After", secs)
+			http.Error(w, "server overloaded, retry later", http.StatusTooManyRequests)
+			return
+		}
+		defer release()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func admissionStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if admissionControl == nil {
+		writeJSON(w, http.StatusOK, map[string]bool{"enabled": false})
+		return
+	}
+	writeJSON(w, http.StatusOK, admissionControl.Snapshot())
+}
@@ -0,0 +1,235 @@
+// Package admission decides whether the server takes on a request at all.
+// Up to MaxInFlight requests run at once; the rest wait in a short queue.
+// The queue timeout follows CoDel: while the queue keeps draining it is
+// Interval, but once the queue has not been empty for a whole Interval the
+// server is behind, and waiters are shed after only Target. Low-priority
+// requests are shed as soon as the server is partly busy, and critical ones
+// (health checks, admin calls) are always admitted.
+package admission
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrShed is returned by Admit when a request is turned away.
+var ErrShed = errors.New("request shed: server overloaded")
+
+type Priority int
+
+const (
+	Low Priority = iota
+	Normal
+	Critical
+)
+
+func (p Priority) String() string {
+	switch p {
+	case Low:
+		return "low"
+	case Critical:
+		return "critical"
+	}
+	return "normal"
+}
+
+// ParsePriority maps a header value to a Priority. Anything unknown is
+// Normal.
+func ParsePriority(s string) Priority {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "low", "sheddable", "batch":
+		return Low
+	case "critical":
+		return Critical
+	}
+	return Normal
+}
+
+// Config tunes a Controller. Zero fields take the defaults noted.
+type Config struct {
+	// MaxInFlight is how many requests run at once. Default 100.
+	MaxInFlight int
+	// MaxQueue is how many requests may wait for a slot. Default
+	// MaxInFlight.
+	MaxQueue int
+	// LowShare is the share of MaxInFlight low-priority requests may find
+	// busy and still be admitted. Default 0.5.
+	LowShare float64
+	// Target is the queue wait allowed while overloaded; Interval is both
+	// the wait allowed otherwise and how long the queue must stay non-empty
+	// to count as overloaded. Defaults 5ms and 100ms.
+	Target   time.Duration
+	Interval time.Duration
+	// Now is the clock. Default time.Now.
+	Now func() time.Time
+}
+
+// Controller is safe for concurrent use.
+type Controller struct {
+	cfg    Config
+	lowCap int
+
+	mu        sync.Mutex
+	inflight  int
+	queue     []*waiter
+	lastEmpty time.Time // last time the queue was seen empty
+	stats     Stats
+}
+
+type waiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+// Stats counts admission decisions.
+type Stats struct {
+	Admitted         uint64 `json:"admitted"`
+	ShedLowPriority  uint64 `json:"shed_low_priority"`
+	ShedQueueFull    uint64 `json:"shed_queue_full"`
+	ShedQueueTimeout uint64 `json:"shed_queue_timeout"`
+	Cancelled        uint64 `json:"cancelled"` // gave up while queued
+}
+
+func New(cfg Config) *Controller {
+	if cfg.MaxInFlight <= 0 {
+		cfg.MaxInFlight = 100
+	}
+	if cfg.MaxQueue <= 0 {
+		cfg.MaxQueue = cfg.MaxInFlight
+	}
+	if cfg.LowShare <= 0 || cfg.LowShare > 1 {
+		cfg.LowShare = 0.5
+	}
+	if cfg.Target <= 0 {
+		cfg.Target = 5 * time.Millisecond
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 100 * time.Millisecond
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	lowCap := max(int(cfg.LowShare*float64(cfg.MaxInFlight)), 1)
+	return &Controller{cfg: cfg, lowCap: lowCap, lastEmpty: cfg.Now()}
+}
+
+// Admit lets a request in, possibly after queueing, or returns ErrShed (or
+// ctx's error if the caller gave up first). release must be called exactly
+// once when the request is done.
+func (c *Controller) Admit(ctx context.Context, p Priority) (release func(), err error) {
+	c.mu.Lock()
+	now := c.cfg.Now()
+	if len(c.queue) == 0 {
+		c.lastEmpty = now
+	}
+
+	switch {
+	case p == Critical, c.inflight < c.cfg.MaxInFlight && len(c.queue) == 0 && (p != Low || c.inflight < c.lowCap):
+		c.inflight++
+		c.stats.Admitted++
+		c.mu.Unlock()
+		return c.releaseFunc(), nil
+	case p == Low && c.inflight >= c.lowCap:
+		c.stats.ShedLowPriority++
+		c.mu.Unlock()
+		return nil, ErrShed
+	case len(c.queue) >= c.cfg.MaxQueue:
+		c.stats.ShedQueueFull++
+		c.mu.Unlock()
+		return nil, ErrShed
+	}
+
+	timeout := c.cfg.Interval
+	if c.overloaded(now) {
+		timeout = c.cfg.Target
+	}
+	w := &waiter{ready: make(chan struct{})}
+	c.queue = append(c.queue, w)
+	c.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-w.ready:
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if w.granted {
+		c.stats.Admitted++
+		return c.releaseFunc(), nil
+	}
+	c.removeWaiter(w)
+	if err := ctx.Err(); err != nil {
+		c.stats.Cancelled++
+		return nil, err
+	}
+	c.stats.ShedQueueTimeout++
+	return nil, ErrShed
+}
+
+// overloaded reports whether the queue has stood for a whole Interval.
+// Callers hold c.mu.
+func (c *Controller) overloaded(now time.Time) bool {
+	return len(c.queue) > 0 && now.Sub(c.lastEmpty) >= c.cfg.Interval
+}
+
+func (c *Controller) releaseFunc() func() {
+	var once sync.Once
+	return func() { once.Do(c.release) }
+}
+
+func (c *Controller) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.inflight--
+	for len(c.queue) > 0 && c.inflight < c.cfg.MaxInFlight {
+		w := c.queue[0]
+		c.queue = c.queue[1:]
+		w.granted = true
+		c.inflight++
+		close(w.ready)
+	}
+	if len(c.queue) == 0 {
+		c.lastEmpty = c.cfg.Now()
+	}
+}
+
+func (c *Controller) removeWaiter(w *waiter) {
+	for i, q := range c.queue {
+		if q == w {
+			c.queue = append(c.queue[:i], c.queue[i+1:]...)
+			break
+		}
+	}
+	if len(c.queue) == 0 {
+		c.lastEmpty = c.cfg.Now()
+	}
+}
+
+// Snapshot is a point-in-time view for metrics and debug endpoints.
+type Snapshot struct {
+	Stats
+	InFlight    int  `json:"in_flight"`
+	Queued      int  `json:"queued"`
+	MaxInFlight int  `json:"max_in_flight"`
+	Overloaded  bool `json:"overloaded"`
+}
+
+func (c *Controller) Snapshot() Snapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Snapshot{
+		Stats:       c.stats,
+		InFlight:    c.inflight,
+		Queued:      len(c.queue),
+		MaxInFlight: c.cfg.MaxInFlight,
+		Overloaded:  c.overloaded(c.cfg.Now()),
+	}
+}
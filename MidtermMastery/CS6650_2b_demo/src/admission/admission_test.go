@@ -0,0 +1,153 @@
+package admission
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.t = c.t.Add(d)
+	c.mu.Unlock()
+}
+
+func mustAdmit(t *testing.T, c *Controller, p Priority) func() {
+	t.Helper()
+	release, err := c.Admit(context.Background(), p)
+	if err != nil {
+		t.Fatalf("Admit(%s): %v", p, err)
+	}
+	return release
+}
+
+func TestCriticalIsNeverShed(t *testing.T) {
+	c := New(Config{MaxInFlight: 1, MaxQueue: 1})
+	mustAdmit(t, c, Normal)
+	for i := 0; i < 5; i++ {
+		mustAdmit(t, c, Critical)
+	}
+	if s := c.Snapshot(); s.InFlight != 6 {
+		t.Fatalf("in flight = %d, want 6", s.InFlight)
+	}
+}
+
+func TestLowPriorityShedFirst(t *testing.T) {
+	c := New(Config{MaxInFlight: 4, LowShare: 0.5})
+	mustAdmit(t, c, Low)
+	mustAdmit(t, c, Normal)
+
+	if _, err := c.Admit(context.Background(), Low); err != ErrShed {
+		t.Fatalf("low at half load err = %v, want ErrShed", err)
+	}
+	mustAdmit(t, c, Normal)
+	if s := c.Snapshot(); s.ShedLowPriority != 1 || s.Admitted != 3 {
+		t.Fatalf("snapshot = %+v", s)
+	}
+}
+
+func TestQueueFullSheds(t *testing.T) {
+	c := New(Config{MaxInFlight: 1, MaxQueue: 1, Interval: time.Second})
+	release := mustAdmit(t, c, Normal)
+
+	queued := make(chan error, 1)
+	go func() {
+		r, err := c.Admit(context.Background(), Normal)
+		if err == nil {
+			r()
+		}
+		queued <- err
+	}()
+	waitFor(t, func() bool { return c.Snapshot().Queued == 1 })
+
+	if _, err := c.Admit(context.Background(), Normal); err != ErrShed {
+		t.Fatalf("admit with full queue err = %v, want ErrShed", err)
+	}
+	release()
+	if err := <-queued; err != nil {
+		t.Fatalf("queued request: %v", err)
+	}
+	if s := c.Snapshot(); s.ShedQueueFull != 1 || s.Admitted != 2 || s.InFlight != 0 {
+		t.Fatalf("snapshot = %+v", s)
+	}
+}
+
+func TestStandingQueueShortensWait(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	c := New(Config{MaxInFlight: 1, MaxQueue: 10, Target: time.Millisecond, Interval: time.Hour, Now: clock.Now})
+	mustAdmit(t, c, Normal)
+
+	// A waiter keeps the queue non-empty; with Interval an hour it would
+	// wait that long.
+	go func() { _, _ = c.Admit(context.Background(), Normal) }()
+	waitFor(t, func() bool { return c.Snapshot().Queued == 1 })
+
+	clock.Advance(2 * time.Hour) // the queue has now stood for a whole Interval
+	if !c.Snapshot().Overloaded {
+		t.Fatal("not overloaded after a standing queue")
+	}
+	start := time.Now()
+	if _, err := c.Admit(context.Background(), Normal); err != ErrShed {
+		t.Fatalf("admit while overloaded err = %v, want ErrShed", err)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Fatalf("waited %v while overloaded, want about Target", waited)
+	}
+	if s := c.Snapshot(); s.ShedQueueTimeout != 1 {
+		t.Fatalf("snapshot = %+v", s)
+	}
+}
+
+func TestCancelledWhileQueued(t *testing.T) {
+	c := New(Config{MaxInFlight: 1, Interval: time.Hour})
+	mustAdmit(t, c, Normal)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.Admit(ctx, Normal); err != context.Canceled {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if s := c.Snapshot(); s.Cancelled != 1 || s.Queued != 0 {
+		t.Fatalf("snapshot = %+v", s)
+	}
+}
+
+func TestReleaseIsIdempotent(t *testing.T) {
+	c := New(Config{MaxInFlight: 2})
+	release := mustAdmit(t, c, Normal)
+	release()
+	release()
+	if s := c.Snapshot(); s.InFlight != 0 {
+		t.Fatalf("in flight = %d after double release, want 0", s.InFlight)
+	}
+}
+
+func TestParsePriority(t *testing.T) {
+	for in, want := range map[string]Priority{"critical": Critical, " LOW ": Low, "batch": Low, "": Normal, "urgent": Normal} {
+		if got := ParsePriority(in); got != want {
+			t.Fatalf("ParsePriority(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"text/main/admission"
+)
+
+func TestWithAdmissionSheds429(t *testing.T) {
+	c := admission.New(admission.Config{MaxInFlight: 2, LowShare: 0.5})
+	release, _ := c.Admit(t.Context(), admission.Normal) // one slot busy
+	defer release()
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	h := withAdmission(ok, c, 1500*time.Millisecond)
+
+	req := httptest.NewRequest(http.MethodGet, "/products/search?q=a", nil)
+	req.Header.Set("X-Priority", "low")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "2" {
+		t.Fatalf("low priority = %d Retry-After %q, want 429 and 2", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	for _, path := range []string{"/health", "/debug/admission", "/products/search"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s = %d, want 200", path, rec.Code)
+		}
+	}
+}
+
+func TestWithAdmissionAnswersDeadlineWhileQueued(t *testing.T) {
+	c := admission.New(admission.Config{MaxInFlight: 1, Interval: time.Minute})
+	release, _ := c.Admit(t.Context(), admission.Normal) // the only slot busy
+	defer release()
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	h := withAdmission(ok, c, time.Second)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 20*time.Millisecond)
+	defer cancel()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/products/search?q=a", nil).WithContext(ctx))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "1" {
+		t.Fatalf("deadline in queue = %d Retry-After %q, want 503 and 1", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	// A client that went away gets nothing written.
+	ctx, cancel = context.WithCancel(t.Context())
+	cancel()
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/products/search?q=a", nil).WithContext(ctx))
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 || rec.Header().Get("Retry-After") != "" {
+		t.Fatalf("cancelled in queue = %d %q, want nothing written", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRequestPriority(t *testing.T) {
+	for _, tc := range []struct {
+		path, header string
+		want         admission.Priority
+	}{
+		{"/health", "low", admission.Critical},
+		{"/debug/breaker", "", admission.Critical},
+		{"/products/search", "", admission.Normal},
+		{"/products/search", "critical", admission.Critical},
+		{"/products", "low", admission.Low},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		req.Header.Set("X-Priority", tc.header)
+		if got := requestPriority(req); got != tc.want {
+			t.Fatalf("requestPriority(%s, %q) = %s, want %s", tc.path, tc.header, got, tc.want)
+		}
+	}
+}
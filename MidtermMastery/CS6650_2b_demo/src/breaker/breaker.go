@@ -0,0 +1,294 @@
+// Package breaker is a circuit breaker for calls to a downstream dependency.
+//
+// Closed: calls go through; the breaker trips to open after too many
+// consecutive failures or when the failure rate over a sliding window gets
+// too high. Open: calls fail fast with ErrOpen until OpenTimeout passes.
+// Half-open: at most HalfOpenMaxProbes calls are let through at once; one
+// failure re-opens the breaker and SuccessesToClose successes close it.
+package breaker
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrOpen is returned by Allow while the breaker is open.
+	ErrOpen = errors.New("circuit breaker open")
+	// ErrTooManyProbes is returned by Allow in half-open when every probe
+	// slot is taken.
+	ErrTooManyProbes = errors.New("circuit breaker half-open: too many probes")
+)
+
+type State int
+
+const (
+	Closed State = iota
+	Open
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half_open"
+	}
+	return "unknown"
+}
+
+// Config tunes a Breaker. Zero fields take the defaults noted below, which
+// match the breaker this package replaced (plus a one-probe half-open limit).
+type Config struct {
+	// ConsecutiveFailures trips the breaker after this many failures in a
+	// row. Default 10; negative disables.
+	ConsecutiveFailures int
+
+	// FailureRate (0..1] trips the breaker when at least that fraction of
+	// the calls in the last Window failed, once the window holds at least
+	// MinRequests calls. 0 disables rate tripping.
+	FailureRate float64
+	Window      time.Duration // default 10s
+	Buckets     int           // window resolution, default 10
+	MinRequests int           // default 20
+
+	// OpenTimeout is how long the breaker stays open before probing.
+	// Default 10s.
+	OpenTimeout time.Duration
+	// HalfOpenMaxProbes caps concurrent calls in half-open. Default 1.
+	HalfOpenMaxProbes int
+	// SuccessesToClose is how many probe successes close the breaker.
+	// Default 5.
+	SuccessesToClose int
+
+	// OnStateChange, if set, is called after every transition, outside the
+	// breaker's lock.
+	OnStateChange func(from, to State)
+	// Now is the clock; tests inject a fake one. Default time.Now.
+	Now func() time.Time
+}
+
+func (c Config) withDefaults() Config {
+	if c.ConsecutiveFailures == 0 {
+		c.ConsecutiveFailures = 10
+	}
+	if c.Window <= 0 {
+		c.Window = 10 * time.Second
+	}
+	if c.Buckets <= 0 {
+		c.Buckets = 10
+	}
+	if c.MinRequests <= 0 {
+		c.MinRequests = 20
+	}
+	if c.OpenTimeout <= 0 {
+		c.OpenTimeout = 10 * time.Second
+	}
+	if c.HalfOpenMaxProbes <= 0 {
+		c.HalfOpenMaxProbes = 1
+	}
+	if c.SuccessesToClose <= 0 {
+		c.SuccessesToClose = 5
+	}
+	if c.Now == nil {
+		c.Now = time.Now
+	}
+	return c
+}
+
+// Breaker is safe for concurrent use.
+type Breaker struct {
+	cfg Config
+
+	mu          sync.Mutex
+	state       State
+	generation  uint64 // bumped on every transition; stale Permits are ignored
+	consecutive int    // consecutive failures while closed
+	window      *window
+	openUntil   time.Time
+	probes      int // half-open calls in flight
+	probeOK     int // half-open successes so far
+}
+
+func New(cfg Config) *Breaker {
+	cfg = cfg.withDefaults()
+	return &Breaker{cfg: cfg, window: newWindow(cfg.Window, cfg.Buckets)}
+}
+
+// Permit is one admitted call. Finish it with exactly one of Success,
+// Failure or Ignore. Results that arrive after the breaker has changed state
+// are dropped, so a slow call from before a trip can't close it again.
+type Permit struct {
+	b          *Breaker
+	generation uint64
+	probe      bool
+}
+
+// Allow admits a call or returns ErrOpen / ErrTooManyProbes.
+func (b *Breaker) Allow() (Permit, error) {
+	b.mu.Lock()
+	from := b.state
+	now := b.cfg.Now()
+
+	if b.state == Open && !now.Before(b.openUntil) {
+		b.setState(HalfOpen, now)
+	}
+
+	var (
+		p   Permit
+		err error
+	)
+	switch b.state {
+	case Open:
+		err = ErrOpen
+	case HalfOpen:
+		if b.probes >= b.cfg.HalfOpenMaxProbes {
+			err = ErrTooManyProbes
+			break
+		}
+		b.probes++
+		p = Permit{b: b, generation: b.generation, probe: true}
+	default:
+		p = Permit{b: b, generation: b.generation}
+	}
+	to := b.state
+	b.mu.Unlock()
+
+	b.notify(from, to)
+	return p, err
+}
+
+// Success records a successful call.
+func (p Permit) Success() { p.finish(outcomeSuccess) }
+
+// Failure records a failed call.
+func (p Permit) Failure() { p.finish(outcomeFailure) }
+
+// Ignore releases the permit without counting it either way, e.g. when the
+// call never reached the dependency.
+func (p Permit) Ignore() { p.finish(outcomeIgnore) }
+
+type outcome int
+
+const (
+	outcomeSuccess outcome = iota
+	outcomeFailure
+	outcomeIgnore
+)
+
+func (p Permit) finish(o outcome) {
+	b := p.b
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	from := b.state
+	if p.generation == b.generation {
+		b.record(o, p.probe)
+	}
+	to := b.state
+	b.mu.Unlock()
+
+	b.notify(from, to)
+}
+
+// record applies an outcome from the current generation. Callers hold b.mu.
+func (b *Breaker) record(o outcome, probe bool) {
+	now := b.cfg.Now()
+
+	if probe {
+		b.probes--
+		switch o {
+		case outcomeFailure:
+			b.setState(Open, now)
+		case outcomeSuccess:
+			b.probeOK++
+			if b.probeOK >= b.cfg.SuccessesToClose {
+				b.setState(Closed, now)
+			}
+		}
+		return
+	}
+
+	switch o {
+	case outcomeSuccess:
+		b.consecutive = 0
+		b.window.add(now, false)
+	case outcomeFailure:
+		b.consecutive++
+		b.window.add(now, true)
+		if b.shouldTrip(now) {
+			b.setState(Open, now)
+		}
+	}
+}
+
+func (b *Breaker) shouldTrip(now time.Time) bool {
+	if b.cfg.ConsecutiveFailures > 0 && b.consecutive >= b.cfg.ConsecutiveFailures {
+		return true
+	}
+	if b.cfg.FailureRate > 0 {
+		total, failures := b.window.counts(now)
+		if total >= b.cfg.MinRequests && float64(failures) >= b.cfg.FailureRate*float64(total) {
+			return true
+		}
+	}
+	return false
+}
+
+// setState moves to s and resets the per-state counters. Callers hold b.mu.
+func (b *Breaker) setState(s State, now time.Time) {
+	b.state = s
+	b.generation++
+	b.consecutive = 0
+	b.probes = 0
+	b.probeOK = 0
+	switch s {
+	case Open:
+		b.openUntil = now.Add(b.cfg.OpenTimeout)
+	case Closed:
+		b.window.reset()
+	}
+}
+
+func (b *Breaker) notify(from, to State) {
+	if from != to && b.cfg.OnStateChange != nil {
+		b.cfg.OnStateChange(from, to)
+	}
+}
+
+// Snapshot is a point-in-time view for metrics and debug endpoints.
+type Snapshot struct {
+	State               string `json:"state"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	WindowRequests      int    `json:"window_requests"`
+	WindowFailures      int    `json:"window_failures"`
+	HalfOpenProbes      int    `json:"half_open_probes"`
+}
+
+// State returns the current state. An open breaker whose timeout has passed
+// still reports open until the next Allow moves it to half-open.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *Breaker) Snapshot() Snapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	total, failures := b.window.counts(b.cfg.Now())
+	return Snapshot{
+		State:               b.state.String(),
+		ConsecutiveFailures: b.consecutive,
+		WindowRequests:      total,
+		WindowFailures:      failures,
+		HalfOpenProbes:      b.probes,
+	}
+}
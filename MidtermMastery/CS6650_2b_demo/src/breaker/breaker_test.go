@@ -0,0 +1,266 @@
+package breaker
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.t = c.t.Add(d)
+	c.mu.Unlock()
+}
+
+func newTestBreaker(cfg Config) (*Breaker, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1_700_000_000, 0)}
+	cfg.Now = clock.Now
+	return New(cfg), clock
+}
+
+func mustAllow(t *testing.T, b *Breaker) Permit {
+	t.Helper()
+	p, err := b.Allow()
+	if err != nil {
+		t.Fatalf("Allow: %v (state %s)", err, b.State())
+	}
+	return p
+}
+
+func fail(t *testing.T, b *Breaker, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		mustAllow(t, b).Failure()
+	}
+}
+
+func TestDefaultsMatchOldBreaker(t *testing.T) {
+	b, clock := newTestBreaker(Config{})
+
+	fail(t, b, 9)
+	if b.State() != Closed {
+		t.Fatalf("state after 9 failures = %s, want closed", b.State())
+	}
+	fail(t, b, 1)
+	if _, err := b.Allow(); err != ErrOpen {
+		t.Fatalf("Allow after 10 failures err = %v, want ErrOpen", err)
+	}
+
+	clock.Advance(10 * time.Second)
+	for i := 0; i < 5; i++ {
+		mustAllow(t, b).Success()
+	}
+	if b.State() != Closed {
+		t.Fatalf("state after 5 probe successes = %s, want closed", b.State())
+	}
+}
+
+func TestSuccessResetsConsecutiveFailures(t *testing.T) {
+	b, _ := newTestBreaker(Config{ConsecutiveFailures: 3})
+	fail(t, b, 2)
+	mustAllow(t, b).Success()
+	fail(t, b, 2)
+	if b.State() != Closed {
+		t.Fatalf("state = %s, want closed (failures were not consecutive)", b.State())
+	}
+	fail(t, b, 1)
+	if b.State() != Open {
+		t.Fatalf("state = %s, want open", b.State())
+	}
+}
+
+func TestHalfOpenLimitsConcurrentProbes(t *testing.T) {
+	b, clock := newTestBreaker(Config{ConsecutiveFailures: 1, OpenTimeout: time.Second, HalfOpenMaxProbes: 2, SuccessesToClose: 3})
+	fail(t, b, 1)
+	clock.Advance(time.Second)
+
+	p1 := mustAllow(t, b)
+	p2 := mustAllow(t, b)
+	if _, err := b.Allow(); err != ErrTooManyProbes {
+		t.Fatalf("third probe err = %v, want ErrTooManyProbes", err)
+	}
+	if b.State() != HalfOpen {
+		t.Fatalf("state = %s, want half_open", b.State())
+	}
+
+	p1.Success() // frees a slot
+	p3 := mustAllow(t, b)
+	p2.Success()
+	p3.Success()
+	if b.State() != Closed {
+		t.Fatalf("state after 3 probe successes = %s, want closed", b.State())
+	}
+}
+
+func TestHalfOpenFailureReopens(t *testing.T) {
+	b, clock := newTestBreaker(Config{ConsecutiveFailures: 1, OpenTimeout: time.Second})
+	fail(t, b, 1)
+	clock.Advance(time.Second)
+
+	mustAllow(t, b).Failure()
+	if _, err := b.Allow(); err != ErrOpen {
+		t.Fatalf("Allow after failed probe err = %v, want ErrOpen", err)
+	}
+	clock.Advance(999 * time.Millisecond)
+	if _, err := b.Allow(); err != ErrOpen {
+		t.Fatalf("Allow before new timeout err = %v, want ErrOpen", err)
+	}
+	clock.Advance(time.Millisecond)
+	mustAllow(t, b)
+}
+
+func TestIgnoreReleasesProbeWithoutCounting(t *testing.T) {
+	b, clock := newTestBreaker(Config{ConsecutiveFailures: 1, OpenTimeout: time.Second, SuccessesToClose: 1})
+	fail(t, b, 1)
+	clock.Advance(time.Second)
+
+	mustAllow(t, b).Ignore()
+	if b.State() != HalfOpen {
+		t.Fatalf("state after ignored probe = %s, want half_open", b.State())
+	}
+	mustAllow(t, b).Success()
+	if b.State() != Closed {
+		t.Fatalf("state = %s, want closed", b.State())
+	}
+}
+
+func TestStaleResultsIgnored(t *testing.T) {
+	b, clock := newTestBreaker(Config{ConsecutiveFailures: 2, OpenTimeout: time.Second, SuccessesToClose: 1})
+	slow := mustAllow(t, b) // started while closed
+	fail(t, b, 2)
+	clock.Advance(time.Second)
+	probe := mustAllow(t, b)
+
+	slow.Success() // from the closed generation: must not count as a probe
+	if b.State() != HalfOpen {
+		t.Fatalf("state after stale success = %s, want half_open", b.State())
+	}
+	probe.Success()
+	if b.State() != Closed {
+		t.Fatalf("state = %s, want closed", b.State())
+	}
+}
+
+func TestFailureRateOverSlidingWindow(t *testing.T) {
+	b, _ := newTestBreaker(Config{
+		ConsecutiveFailures: -1,
+		FailureRate:         0.5,
+		Window:              10 * time.Second,
+		Buckets:             10,
+		MinRequests:         10,
+	})
+
+	// Alternating results never hit a consecutive limit, but 5/10 = 50%.
+	for i := 0; i < 9; i++ {
+		p := mustAllow(t, b)
+		if i%2 == 0 {
+			p.Failure()
+		} else {
+			p.Success()
+		}
+	}
+	if b.State() != Closed {
+		t.Fatalf("state below MinRequests = %s, want closed", b.State())
+	}
+	mustAllow(t, b).Success() // 10 calls, 5 failures, but rate is only checked on a failure
+	if b.State() != Closed {
+		t.Fatalf("trip must happen on a failure, state = %s", b.State())
+	}
+	mustAllow(t, b).Failure() // 11 calls, 6 failures
+	if b.State() != Open {
+		t.Fatalf("state at 6/11 failures = %s, want open", b.State())
+	}
+}
+
+func TestFailureRateForgetsOldBuckets(t *testing.T) {
+	b, clock := newTestBreaker(Config{
+		ConsecutiveFailures: -1,
+		FailureRate:         0.5,
+		Window:              10 * time.Second,
+		MinRequests:         4,
+	})
+
+	fail(t, b, 3)
+	for i := 0; i < 3; i++ {
+		mustAllow(t, b).Success()
+	}
+	clock.Advance(11 * time.Second) // the old calls slide out of the window
+
+	for i := 0; i < 3; i++ {
+		mustAllow(t, b).Success()
+	}
+	mustAllow(t, b).Failure() // 1/4 in the window
+	if b.State() != Closed {
+		t.Fatalf("state = %s, want closed once old failures aged out", b.State())
+	}
+	if s := b.Snapshot(); s.WindowRequests != 4 || s.WindowFailures != 1 {
+		t.Fatalf("snapshot = %+v, want 4 requests / 1 failure", s)
+	}
+}
+
+func TestStateChangeCallbacks(t *testing.T) {
+	type change struct{ from, to State }
+	var got []change
+	b, clock := newTestBreaker(Config{
+		ConsecutiveFailures: 1,
+		OpenTimeout:         time.Second,
+		SuccessesToClose:    1,
+		OnStateChange: func(from, to State) {
+			got = append(got, change{from, to})
+		},
+	})
+
+	fail(t, b, 1)
+	clock.Advance(time.Second)
+	mustAllow(t, b).Success()
+
+	want := []change{{Closed, Open}, {Open, HalfOpen}, {HalfOpen, Closed}}
+	if len(got) != len(want) {
+		t.Fatalf("transitions = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("transitions = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestConcurrentUse(t *testing.T) {
+	b, clock := newTestBreaker(Config{ConsecutiveFailures: 5, OpenTimeout: time.Millisecond, HalfOpenMaxProbes: 3})
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				p, err := b.Allow()
+				if err != nil {
+					clock.Advance(time.Millisecond)
+					continue
+				}
+				if (g+i)%3 == 0 {
+					p.Failure()
+				} else {
+					p.Success()
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if s := b.Snapshot(); s.HalfOpenProbes < 0 || s.HalfOpenProbes > 3 {
+		t.Fatalf("probe accounting off: %+v", s)
+	}
+}
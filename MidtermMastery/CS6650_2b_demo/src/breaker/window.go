@@ -0,0 +1,58 @@
+package breaker
+
+import "time"
+
+// window counts calls and failures over the last `size` of time, split into
+// fixed buckets. A bucket is reused (and cleared) once its slot comes round
+// again, so memory stays constant however many calls are recorded.
+type window struct {
+	bucketDur time.Duration
+	buckets   []bucket
+}
+
+type bucket struct {
+	epoch    int64 // which bucketDur-sized slice of time this bucket holds
+	total    int
+	failures int
+}
+
+func newWindow(size time.Duration, n int) *window {
+	d := size / time.Duration(n)
+	if d <= 0 {
+		d = 1
+	}
+	return &window{bucketDur: d, buckets: make([]bucket, n)}
+}
+
+func (w *window) epoch(now time.Time) int64 {
+	return now.UnixNano() / int64(w.bucketDur)
+}
+
+func (w *window) add(now time.Time, failed bool) {
+	e := w.epoch(now)
+	b := &w.buckets[e%int64(len(w.buckets))]
+	if b.epoch != e {
+		*b = bucket{epoch: e}
+	}
+	b.total++
+	if failed {
+		b.failures++
+	}
+}
+
+// counts sums the buckets that still fall inside the window.
+func (w *window) counts(now time.Time) (total, failures int) {
+	e := w.epoch(now)
+	oldest := e - int64(len(w.buckets)) + 1
+	for _, b := range w.buckets {
+		if b.epoch >= oldest && b.epoch <= e {
+			total += b.total
+			failures += b.failures
+		}
+	}
+	return total, failures
+}
+
+func (w *window) reset() {
+	clear(w.buckets)
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// searchCache is an in-process LRU of search results keyed by the normalized
+// query. Entries expire after ttl and the cache is bounded by an estimate of
+// the bytes it holds. Concurrent misses for the same key share one
+// computation, and any product write drops everything (a write can change
+// the answer to any query).
+type searchCache struct {
+	ttl      time.Duration
+	maxBytes int64
+	now      func() time.Time
+
+	mu       sync.Mutex
+	ll       *list.List // front = most recently used
+	items    map[string]*list.Element
+	inflight map[string]*cacheCall
+	bytes    int64
+	gen      uint64 // bumped by invalidate; results computed under an older gen are not stored
+	stats    cacheStats
+}
+
+type cacheEntry struct {
+	key     string
+	resp    SearchResponse
+	size    int64
+	expires time.Time
+}
+
+// cacheCall is one in-flight computation that later identical misses wait on.
+type cacheCall struct {
+	done chan struct{}
+	resp SearchResponse
+	ok   bool // false if compute panicked, so resp is not an answer
+}
+
+type cacheStats struct {
+	Hits          uint64 `json:"hits"`
+	Misses        uint64 `json:"misses"`
+	Shared        uint64 `json:"shared"` // misses served by another request's computation
+	Evictions     uint64 `json:"evictions"`
+	Expirations   uint64 `json:"expirations"`
+	Invalidations uint64 `json:"invalidations"`
+}
+
+// cacheResult says how a lookup was answered; handlers echo it in X-Cache.
+type cacheResult string
+
+const (
+	cacheHit    cacheResult = "HIT"
+	cacheMiss   cacheResult = "MISS"
+	cacheShared cacheResult = "SHARED"
+	cacheBypass cacheResult = "BYPASS"
+)
+
+func newSearchCache(ttl time.Duration, maxBytes int64) *searchCache {
+	return &searchCache{
+		ttl:      ttl,
+		maxBytes: maxBytes,
+		now:      time.Now,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+		inflight: make(map[string]*cacheCall),
+	}
+}
+
+// searchCacheFromEnv reads SEARCH_CACHE_TTL (a Go duration, default 30s; 0
+// turns the cache off) and SEARCH_CACHE_MAX_BYTES (default 16 MiB).
+func searchCacheFromEnv() (*searchCache, error) {
+	ttl := 30 * time.Second
+	maxBytes := int64(16 << 20)
+
+	if v := os.Getenv("SEARCH_CACHE_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("SEARCH_CACHE_TTL must be a non-negative duration, got %q", v)
+		}
+		ttl = d
+	}
+	if v := os.Getenv("SEARCH_CACHE_MAX_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("SEARCH_CACHE_MAX_BYTES must be a non-negative integer, got %q", v)
+		}
+		maxBytes = n
+	}
+	return newSearchCache(ttl, maxBytes), nil
+}
+
+// searchCacheKey normalizes the query exactly as the search does: trimmed
+// and case-insensitive. Inner whitespace is kept, since the search matches
+// it literally.
+func searchCacheKey(q string) string {
+	return "q=" + strings.ToLower(strings.TrimSpace(q))
+}
+
+func (c *searchCache) enabled() bool {
+	return c != nil && c.ttl > 0 && c.maxBytes > 0
+}
+
+// get returns the cached response for key, or runs compute once for all
+// concurrent callers asking for the same missing key and caches the result.
+func (c *searchCache) get(key string, compute func() SearchResponse) (SearchResponse, cacheResult) {
+	if !c.enabled() {
+		return compute(), cacheBypass
+	}
+
+	c.mu.Lock()
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*cacheEntry)
+		if c.now().Before(e.expires) {
+			c.ll.MoveToFront(el)
+			c.stats.Hits++
+			c.mu.Unlock()
+			return e.resp, cacheHit
+		}
+		c.removeElement(el)
+		c.stats.Expirations++
+	}
+	if call, ok := c.inflight[key]; ok {
+		c.stats.Shared++
+		c.mu.Unlock()
+		<-call.done
+		if !call.ok {
+			// The computation panicked; run it again rather than serve
+			// its empty response as a real result.
+			return c.get(key, compute)
+		}
+		return call.resp, cacheShared
+	}
+	c.stats.Misses++
+	call := &cacheCall{done: make(chan struct{})}
+	c.inflight[key] = call
+	gen := c.gen
+	c.mu.Unlock()
+
+	// If compute panics, release the waiters (call.ok is still false, so
+	// they compute again) and let the panic carry on to the caller.
+	defer func() {
+		c.mu.Lock()
+		if c.inflight[key] == call {
+			delete(c.inflight, key)
+		}
+		c.mu.Unlock()
+		close(call.done)
+	}()
+
+	call.resp = compute()
+	call.ok = true
+
+	c.mu.Lock()
+	if gen == c.gen {
+		c.add(key, call.resp)
+	}
+	c.mu.Unlock()
+	return call.resp, cacheMiss
+}
+
+// add stores resp and evicts from the LRU tail until the cache fits. Callers
+// hold c.mu.
+func (c *searchCache) add(key string, resp SearchResponse) {
+	size := int64(len(key)) + approxResponseSize(resp)
+	if size > c.maxBytes {
+		return // would evict everything else and still not fit
+	}
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+	e := &cacheEntry{key: key, resp: resp, size: size, expires: c.now().Add(c.ttl)}
+	c.items[key] = c.ll.PushFront(e)
+	c.bytes += size
+
+	for c.bytes > c.maxBytes {
+		c.removeElement(c.ll.Back())
+		c.stats.Evictions++
+	}
+}
+
+func (c *searchCache) removeElement(el *list.Element) {
+	e := c.ll.Remove(el).(*cacheEntry)
+	delete(c.items, e.key)
+	c.bytes -= e.size
+}
+
+// invalidate drops every entry and detaches in-flight computations, so a
+// request arriving after a write never gets an answer computed before it.
+func (c *searchCache) invalidate() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	c.stats.Invalidations++
+	if len(c.items) == 0 && len(c.inflight) == 0 {
+		return
+	}
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+	c.inflight = make(map[string]*cacheCall)
+	c.bytes = 0
+}
+
+type cacheSnapshot struct {
+	cacheStats
+	Enabled  bool    `json:"enabled"`
+	Entries  int     `json:"entries"`
+	Bytes    int64   `json:"bytes"`
+	MaxBytes int64   `json:"max_bytes"`
+	TTL      string  `json:"ttl"`
+	HitRate  float64 `json:"hit_rate"`
+}
+
+func (c *searchCache) snapshot() cacheSnapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := cacheSnapshot{
+		cacheStats: c.stats,
+		Enabled:    c.enabled(),
+		Entries:    len(c.items),
+		Bytes:      c.bytes,
+		MaxBytes:   c.maxBytes,
+		TTL:        c.ttl.String(),
+	}
+	if total := s.Hits + s.Misses + s.Shared; total > 0 {
+		s.HitRate = float64(s.Hits+s.Shared) / float64(total)
+	}
+	return s
+}
+
+// approxResponseSize estimates the heap a cached response pins: string
+// bytes plus fixed struct/header overhead. It only needs to be roughly right
+// for the byte limit to mean something.
+func approxResponseSize(resp SearchResponse) int64 {
+	const entryOverhead = 128  // list element, map slot, cacheEntry
+	const productOverhead = 88 // int + 4 string headers
+	n := int64(entryOverhead + len(resp.SearchTime))
+	for _, p := range resp.Products {
+		n += productOverhead + int64(len(p.Name)+len(p.Category)+len(p.Description)+len(p.Brand))
+	}
+	return n
+}
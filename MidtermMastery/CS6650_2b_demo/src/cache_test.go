@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSearchCatalogCachesUntilWrite(t *testing.T) {
+	catalog = newProductStore()
+	resultCache = newSearchCache(time.Minute, 1<<20)
+	catalog.onWrite = resultCache.invalidate
+	defer func() {
+		catalog = newProductStore()
+		resultCache = nil
+	}()
+	_, _ = catalog.add(Product{Name: "Desk", Category: "Home"})
+
+	if _, how := searchCatalog("home"); how != cacheMiss {
+		t.Fatalf("first search = %s, want MISS", how)
+	}
+	if out, how := searchCatalog("  HOME "); how != cacheHit || out.TotalFound != 1 {
+		t.Fatalf("repeat search = %s %+v, want HIT with 1 result", how, out)
+	}
+
+	_, _ = catalog.add(Product{Name: "Lamp", Category: "Home"})
+	if out, how := searchCatalog("home"); how != cacheMiss || out.TotalFound != 2 {
+		t.Fatalf("search after write = %s %+v, want MISS with 2 results", how, out)
+	}
+	if s := resultCache.snapshot(); s.Hits != 1 || s.Misses != 2 || s.Invalidations != 2 {
+		t.Fatalf("stats = %+v", s)
+	}
+}
+
+func TestSearchCatalogWithoutCache(t *testing.T) {
+	resultCache = nil
+	if _, how := searchCatalog("home"); how != cacheBypass {
+		t.Fatalf("search with nil cache = %s, want BYPASS", how)
+	}
+}
+
+func TestSearchCacheWaitersRecomputeAfterPanic(t *testing.T) {
+	c := newSearchCache(time.Minute, 1<<20)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer func() { _ = recover() }()
+		c.get("q=a", func() SearchResponse {
+			close(started)
+			<-release
+			panic("scan failed")
+		})
+	}()
+	<-started
+
+	done := make(chan struct{})
+	var out SearchResponse
+	var how cacheResult
+	go func() {
+		defer close(done)
+		out, how = c.get("q=a", func() SearchResponse {
+			return SearchResponse{Products: []Product{{ID: 1, Name: "Desk"}}, TotalFound: 1}
+		})
+	}()
+	// Wait until the second get is waiting on the first.
+	for c.snapshot().Shared == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	<-done
+
+	if how != cacheMiss || out.TotalFound != 1 {
+		t.Fatalf("waiter got %s %+v, want MISS with 1 result", how, out)
+	}
+}
@@ -0,0 +1,214 @@
+// This file is kept byte for byte the same in every service that has it;
+// MidtermMastery/CS6650_2b_demo/src/shared_copies_test.go checks that.
+
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressMinBytes is the smallest body worth compressing; below it the
+// encoding overhead is bigger than the saving.
+const compressMinBytes = 1024
+
+var (
+	gzipPool = sync.Pool{New: func() any {
+		zw, _ := gzip.NewWriterLevel(nil, gzip.BestSpeed)
+		return zw
+	}}
+	zstdPool = sync.Pool{New: func() any {
+		zw, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(1))
+		return zw
+	}}
+)
+
+// withCompression encodes responses with zstd or gzip, whichever the client
+// prefers in Accept-Encoding (zstd wins a tie). The first minSize bytes are
+// held back: a response that ends before that goes out as is. A handler that
+// flushes early (a streamed listing) is compressed from that point on.
+func withCompression(next http.Handler, minSize int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		enc := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if enc == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: enc, minSize: minSize}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiateEncoding picks "zstd", "gzip" or "" (identity) from an
+// Accept-Encoding header, honouring q-values and "*".
+func negotiateEncoding(header string) string {
+	q := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		weight := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				weight = f
+			}
+		}
+		q[name] = weight
+	}
+	weight := func(enc string) float64 {
+		if w, ok := q[enc]; ok {
+			return w
+		}
+		return q["*"]
+	}
+
+	best, bestQ := "", 0.0
+	for _, enc := range []string{"zstd", "gzip"} {
+		if w := weight(enc); w > bestQ {
+			best, bestQ = enc, w
+		}
+	}
+	return best
+}
+
+// compressWriter buffers the start of a response until it knows whether it's
+// big enough to compress, then either streams through an encoder or writes
+// the buffer out untouched.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+
+	status      int
+	buf         []byte
+	enc         io.WriteCloser // set once compression has started
+	passthrough bool           // decided not to compress
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	switch {
+	case cw.enc != nil:
+		return cw.enc.Write(p)
+	case cw.passthrough:
+		return cw.ResponseWriter.Write(p)
+	}
+
+	if !cw.compressible() {
+		cw.startPassthrough()
+		return cw.ResponseWriter.Write(p)
+	}
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= cw.minSize {
+		if err := cw.startCompression(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush commits to compressing whatever is buffered: a handler only flushes
+// mid-response when it is streaming something large.
+func (cw *compressWriter) Flush() {
+	if cw.enc == nil && !cw.passthrough {
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		if cw.compressible() {
+			_ = cw.startCompression()
+		} else {
+			cw.startPassthrough()
+		}
+	}
+	if f, ok := cw.enc.(interface{ Flush() error }); ok {
+		_ = f.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter { return cw.ResponseWriter }
+
+func (cw *compressWriter) compressible() bool {
+	h := cw.Header()
+	return h.Get("Content-Encoding") == "" &&
+		cw.status != http.StatusNoContent && cw.status != http.StatusNotModified
+}
+
+func (cw *compressWriter) startPassthrough() {
+	cw.passthrough = true
+	if cw.status != 0 {
+		cw.ResponseWriter.WriteHeader(cw.status)
+	}
+	if len(cw.buf) > 0 {
+		_, _ = cw.ResponseWriter.Write(cw.buf)
+		cw.buf = nil
+	}
+}
+
+func (cw *compressWriter) startCompression() error {
+	h := cw.Header()
+	h.Set("Content-Encoding", cw.encoding)
+	h.Del("Content-Length")
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	switch cw.encoding {
+	case "zstd":
+		zw := zstdPool.Get().(*zstd.Encoder)
+		zw.Reset(cw.ResponseWriter)
+		cw.enc = zw
+	default:
+		zw := gzipPool.Get().(*gzip.Writer)
+		zw.Reset(cw.ResponseWriter)
+		cw.enc = zw
+	}
+
+	buf := cw.buf
+	cw.buf = nil
+	_, err := cw.enc.Write(buf)
+	return err
+}
+
+// close finishes the response: small bodies are written raw, compressed ones
+// get their encoder closed and returned to the pool.
+func (cw *compressWriter) close() {
+	switch {
+	case cw.enc != nil:
+		_ = cw.enc.Close()
+		switch zw := cw.enc.(type) {
+		case *zstd.Encoder:
+			zw.Reset(nil)
+			zstdPool.Put(zw)
+		case *gzip.Writer:
+			zw.Reset(nil)
+			gzipPool.Put(zw)
+		}
+	case !cw.passthrough:
+		if cw.status != 0 {
+			cw.ResponseWriter.WriteHeader(cw.status)
+		}
+		if len(cw.buf) > 0 {
+			_, _ = cw.ResponseWriter.Write(cw.buf)
+		}
+	}
+}
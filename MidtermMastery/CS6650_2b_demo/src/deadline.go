@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"text/main/config"
+)
+
+// requestTimeoutFromEnv reads REQUEST_TIMEOUT, the default bound on every
+// request that doesn't bring a tighter deadline of its own. 0 (the default)
+// means no server-side limit. It is a runtime setting, changeable at
+// /admin/config.
+func requestTimeoutFromEnv() (config.Duration, error) {
+	v := os.Getenv("REQUEST_TIMEOUT")
+	if v == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("REQUEST_TIMEOUT must be a non-negative duration, got %q", v)
+	}
+	return config.Duration(d), nil
+}
+
+// requestDeadline works out when a request has to be answered by: the
+// earliest of X-Request-Deadline (RFC 3339 time or Unix milliseconds),
+// X-Request-Timeout (a Go duration like 250ms, or plain milliseconds) and
+// the server default. ok is false when none of them applies.
+func requestDeadline(h http.Header, now time.Time, fallback time.Duration) (deadline time.Time, ok bool, err error) {
+	earliest := func(t time.Time) {
+		if !ok || t.Before(deadline) {
+			deadline, ok = t, true
+		}
+	}
+
+	if v := h.Get("X-Request-Deadline"); v != "" {
+		t, perr := time.Parse(time.RFC3339Nano, v)
+		if perr != nil {
+			ms, nerr := strconv.ParseInt(v, 10, 64)
+			if nerr != nil {
+				return time.Time{}, false, fmt.Errorf("X-Request-Deadline must be an RFC 3339 time or Unix milliseconds, got %q", v)
+			}
+			t = time.UnixMilli(ms)
+		}
+		earliest(t)
+	}
+	if v := h.Get("X-Request-Timeout"); v != "" {
+		d, perr := time.ParseDuration(v)
+		if perr != nil {
+			ms, nerr := strconv.Atoi(v)
+			if nerr != nil {
+				return time.Time{}, false, fmt.Errorf("X-Request-Timeout must be a duration or milliseconds, got %q", v)
+			}
+			d = time.Duration(ms) * time.Millisecond
+		}
+		if d < 0 {
+			return time.Time{}, false, fmt.Errorf("X-Request-Timeout must not be negative, got %q", v)
+		}
+		earliest(now.Add(d))
+	}
+	if fallback > 0 {
+		earliest(now.Add(fallback))
+	}
+	return deadline, ok, nil
+}
+
+// withDeadline puts the request's deadline on its context, so every
+// downstream call made with r.Context() gets only what is left of the
+// budget. The context is also cancelled when the client goes away.
+func withDeadline(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, ok, err := requestDeadline(r.Header, time.Now(), time.Duration(liveRuntime().settings.RequestTimeout))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if ok {
+			ctx, cancel := context.WithDeadline(r.Context(), deadline)
+			defer cancel()
+			r = r.WithContext(ctx)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestDeadline(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name     string
+		deadline string
+		timeout  string
+		fallback time.Duration
+		want     time.Duration // from now; -1 = no deadline
+	}{
+		{"none", "", "", 0, -1},
+		{"fallback only", "", "", time.Second, time.Second},
+		{"timeout duration", "", "250ms", 0, 250 * time.Millisecond},
+		{"timeout millis", "", "300", 0, 300 * time.Millisecond},
+		{"rfc3339 deadline", now.Add(2 * time.Second).Format(time.RFC3339Nano), "", 0, 2 * time.Second},
+		{"unix millis deadline", "1704110401500", "", 0, 1500 * time.Millisecond},
+		{"earliest wins", now.Add(2 * time.Second).Format(time.RFC3339), "100ms", time.Second, 100 * time.Millisecond},
+		{"header can't extend fallback", "", "5s", time.Second, time.Second},
+	} {
+		h := http.Header{}
+		if tc.deadline != "" {
+			h.Set("X-Request-Deadline", tc.deadline)
+		}
+		if tc.timeout != "" {
+			h.Set("X-Request-Timeout", tc.timeout)
+		}
+		got, ok, err := requestDeadline(h, now, tc.fallback)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if tc.want < 0 {
+			if ok {
+				t.Fatalf("%s: got deadline %v, want none", tc.name, got)
+			}
+			continue
+		}
+		if !ok || got.Sub(now) != tc.want {
+			t.Fatalf("%s: deadline = now+%v (ok=%v), want now+%v", tc.name, got.Sub(now), ok, tc.want)
+		}
+	}
+}
+
+func TestRequestDeadlineRejectsGarbage(t *testing.T) {
+	for _, h := range []http.Header{
+		{"X-Request-Deadline": {"tomorrow"}},
+		{"X-Request-Timeout": {"soon"}},
+		{"X-Request-Timeout": {"-5ms"}},
+	} {
+		if _, _, err := requestDeadline(h, time.Now(), 0); err == nil {
+			t.Fatalf("requestDeadline(%v) = nil error", h)
+		}
+	}
+}
+
+func TestWithDeadlineSetsContextDeadline(t *testing.T) {
+	var got time.Duration
+	h := withDeadline(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d, ok := r.Context().Deadline()
+		if !ok {
+			t.Fatal("no deadline on request context")
+		}
+		got = time.Until(d)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/products/search", nil)
+	req.Header.Set("X-Request-Timeout", "200ms")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if got <= 0 || got > 200*time.Millisecond {
+		t.Fatalf("remaining budget = %v, want (0, 200ms]", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/products/search", nil)
+	req.Header.Set("X-Request-Timeout", "later")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("bad header status = %d, want 400", rec.Code)
+	}
+}
+
+func TestFixedSearchReturns504WithPartialResults(t *testing.T) {
+	catalog = newProductStore()
+	resultCache = nil
+	defer func() { catalog = newProductStore() }()
+	_, _ = catalog.add(Product{Name: "Desk", Category: "Home"})
+
+	// A budget that is already spent: the downstream call is never made,
+	// but the local results still go back with the 504.
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Millisecond))
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/products/search?q=home", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	searchHandler_FIXED(rec, req)
+
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, want 504", rec.Code)
+	}
+	out := decodeSearchResponse(t, rec)
+	if !out.Partial || out.TotalFound != 1 || out.Downstream != "deadline_exceeded" {
+		t.Fatalf("body = %+v, want partial results with 1 match and deadline_exceeded", out)
+	}
+}
+
+func decodeSearchResponse(t *testing.T, rec *httptest.ResponseRecorder) SearchResponse {
+	t.Helper()
+	var out SearchResponse
+	if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+		t.Fatalf("decode %q: %v", rec.Body.String(), err)
+	}
+	return out
+}
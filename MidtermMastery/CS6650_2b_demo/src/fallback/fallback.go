@@ -0,0 +1,146 @@
+// Package fallback gives a dependency something to answer with when it
+// can't be reached. A Dependency remembers the last good result of its call
+// and, when a call fails, serves that (if it isn't too old) or else runs its
+// fallback functions in order. Every Result says where it came from, so
+// handlers can flag degraded answers to their clients.
+package fallback
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Source says where a Result's value came from.
+type Source string
+
+const (
+	Live  Source = "live"  // the dependency answered
+	Stale Source = "stale" // last known good answer
+	None  Source = "none"  // nothing worked; Value is the zero value
+)
+
+// Func produces a substitute value after the call failed with err.
+type Func[T any] func(ctx context.Context, err error) (T, error)
+
+// Named is a fallback function with the name reported as its Source.
+type Named[T any] struct {
+	Name string
+	Fn   Func[T]
+}
+
+// Config tunes a Dependency. Zero fields take the defaults noted.
+type Config[T any] struct {
+	// MaxStale is the oldest last-known-good value that may be served.
+	// 0 disables the stale cache.
+	MaxStale time.Duration
+	// Fallbacks run in order after the stale cache misses.
+	Fallbacks []Named[T]
+	// Now is the clock. Default time.Now.
+	Now func() time.Time
+}
+
+// Result is the outcome of Dependency.Do.
+type Result[T any] struct {
+	Value  T
+	Source Source
+	// Age is how old a stale value is.
+	Age time.Duration
+	// Err is the live call's error; it is set whenever Source isn't Live,
+	// even if a fallback produced a value.
+	Err error
+}
+
+// Degraded reports whether the value is anything but a live answer.
+func (r Result[T]) Degraded() bool { return r.Source != Live }
+
+// Dependency is safe for concurrent use.
+type Dependency[T any] struct {
+	name string
+	cfg  Config[T]
+
+	mu       sync.Mutex
+	last     T
+	lastAt   time.Time
+	haveLast bool
+	counts   map[Source]uint64
+}
+
+func New[T any](name string, cfg Config[T]) *Dependency[T] {
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	return &Dependency[T]{name: name, cfg: cfg, counts: make(map[Source]uint64)}
+}
+
+func (d *Dependency[T]) Name() string { return d.name }
+
+// Do runs call and, if it fails, the stale cache and then each fallback
+// until one produces a value.
+func (d *Dependency[T]) Do(ctx context.Context, call func(ctx context.Context) (T, error)) Result[T] {
+	v, err := call(ctx)
+	if err == nil {
+		d.mu.Lock()
+		d.last, d.lastAt, d.haveLast = v, d.cfg.Now(), true
+		d.counts[Live]++
+		d.mu.Unlock()
+		return Result[T]{Value: v, Source: Live}
+	}
+
+	if stale, age, ok := d.stale(); ok {
+		d.count(Stale)
+		return Result[T]{Value: stale, Source: Stale, Age: age, Err: err}
+	}
+	for _, fb := range d.cfg.Fallbacks {
+		if fv, ferr := fb.Fn(ctx, err); ferr == nil {
+			d.count(Source(fb.Name))
+			return Result[T]{Value: fv, Source: Source(fb.Name), Err: err}
+		}
+	}
+	d.count(None)
+	var zero T
+	return Result[T]{Value: zero, Source: None, Err: err}
+}
+
+func (d *Dependency[T]) stale() (T, time.Duration, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var zero T
+	if !d.haveLast || d.cfg.MaxStale <= 0 {
+		return zero, 0, false
+	}
+	age := d.cfg.Now().Sub(d.lastAt)
+	if age > d.cfg.MaxStale {
+		return zero, 0, false
+	}
+	return d.last, age, true
+}
+
+func (d *Dependency[T]) count(s Source) {
+	d.mu.Lock()
+	d.counts[s]++
+	d.mu.Unlock()
+}
+
+// Snapshot is a point-in-time view for metrics and debug endpoints.
+type Snapshot struct {
+	Name string `json:"name"`
+	// Served counts results by Source (live, stale, a fallback's name, none).
+	Served map[Source]uint64 `json:"served"`
+	// LastGoodAge is how old the last known good value is; empty if none.
+	LastGoodAge string `json:"last_good_age,omitempty"`
+	MaxStale    string `json:"max_stale"`
+}
+
+func (d *Dependency[T]) Snapshot() Snapshot {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := Snapshot{Name: d.name, Served: make(map[Source]uint64, len(d.counts)), MaxStale: d.cfg.MaxStale.String()}
+	for k, v := range d.counts {
+		s.Served[k] = v
+	}
+	if d.haveLast {
+		s.LastGoodAge = d.cfg.Now().Sub(d.lastAt).Round(time.Millisecond).String()
+	}
+	return s
+}
@@ -0,0 +1,75 @@
+package fallback
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) Now() time.Time { return c.t }
+
+var errDown = errors.New("down")
+
+func ok(v string) func(context.Context) (string, error) {
+	return func(context.Context) (string, error) { return v, nil }
+}
+
+func fail(context.Context) (string, error) { return "", errDown }
+
+func TestLiveResultIsRemembered(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	d := New("dep", Config[string]{MaxStale: time.Minute, Now: clock.Now})
+
+	if r := d.Do(context.Background(), ok("fresh")); r.Source != Live || r.Value != "fresh" || r.Degraded() {
+		t.Fatalf("live call = %+v", r)
+	}
+	clock.t = clock.t.Add(30 * time.Second)
+	r := d.Do(context.Background(), fail)
+	if r.Source != Stale || r.Value != "fresh" || r.Age != 30*time.Second || !r.Degraded() || r.Err != errDown {
+		t.Fatalf("failed call = %+v, want the 30s old value", r)
+	}
+}
+
+func TestTooStaleFallsThroughToFallbacks(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	var gotErr error
+	d := New("dep", Config[string]{
+		MaxStale: time.Minute,
+		Now:      clock.Now,
+		Fallbacks: []Named[string]{
+			{Name: "broken", Fn: func(context.Context, error) (string, error) { return "", errors.New("no") }},
+			{Name: "default", Fn: func(_ context.Context, err error) (string, error) { gotErr = err; return "default", nil }},
+		},
+	})
+	d.Do(context.Background(), ok("fresh"))
+	clock.t = clock.t.Add(2 * time.Minute)
+
+	r := d.Do(context.Background(), fail)
+	if r.Source != "default" || r.Value != "default" || gotErr != errDown {
+		t.Fatalf("result = %+v (fallback saw %v), want the default fallback", r, gotErr)
+	}
+}
+
+func TestNothingWorks(t *testing.T) {
+	d := New("dep", Config[string]{MaxStale: time.Minute})
+	r := d.Do(context.Background(), fail)
+	if r.Source != None || r.Value != "" || r.Err != errDown {
+		t.Fatalf("result = %+v, want none", r)
+	}
+
+	s := d.Snapshot()
+	if s.Served[None] != 1 || s.LastGoodAge != "" {
+		t.Fatalf("snapshot = %+v", s)
+	}
+}
+
+func TestStaleDisabled(t *testing.T) {
+	d := New("dep", Config[string]{})
+	d.Do(context.Background(), ok("fresh"))
+	if r := d.Do(context.Background(), fail); r.Source != None {
+		t.Fatalf("source = %s with MaxStale 0, want none", r.Source)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDegradedSearchCarriesFallbackHeaders(t *testing.T) {
+	catalog = newProductStore()
+	resultCache = nil
+	defer func() { catalog = newProductStore(); downstreamDep = newDownstreamDep(0) }()
+	_, _ = catalog.add(Product{Name: "Desk", Category: "Home"})
+
+	// Seed a last known good answer, then let the real call fail fast: the
+	// request's deadline has already passed.
+	downstreamDep = newDownstreamDep(time.Minute)
+	downstreamDep.Do(context.Background(), func(context.Context) (json.RawMessage, error) {
+		return json.RawMessage(`{"ok":true}`), nil
+	})
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Millisecond))
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/products/search?q=home", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	searchHandler_FIXED(rec, req)
+
+	out := decodeSearchResponse(t, rec)
+	if !out.Degraded || out.Fallback != "stale" || string(out.DownstreamData) != `{"ok":true}` {
+		t.Fatalf("body = %+v, want stale downstream data", out)
+	}
+	if got := rec.Header().Get("X-Degraded"); got != "downstream=stale; age=0" {
+		t.Fatalf("X-Degraded = %q", got)
+	}
+	if got := rec.Header().Get("Warning"); got != `110 - "Response is Stale"` {
+		t.Fatalf("Warning = %q", got)
+	}
+}
+
+func TestDownstreamDefaultFallback(t *testing.T) {
+	dep := newDownstreamDep(0)
+	res := dep.Do(context.Background(), func(context.Context) (json.RawMessage, error) {
+		return nil, errors.New("breaker open")
+	})
+	if res.Source != "default" || string(res.Value) != string(downstreamDefault) {
+		t.Fatalf("result = %+v, want the default fallback", res)
+	}
+
+	rec := httptest.NewRecorder()
+	markDegraded(rec, "downstream", res.Source, 0)
+	if got := rec.Header().Get("X-Degraded"); got != "downstream=default" {
+		t.Fatalf("X-Degraded = %q", got)
+	}
+	if got := rec.Header().Get("Warning"); got != `199 - "downstream unavailable, served default"` {
+		t.Fatalf("Warning = %q", got)
+	}
+}
@@ -0,0 +1,69 @@
+package faults
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// adminView is what GET returns.
+type adminView struct {
+	Rules  []Rule `json:"rules"`
+	Counts Counts `json:"counts"`
+}
+
+// AdminHandler manages the rules at runtime:
+//
+//	GET     current rules and injection counts
+//	PUT     replace all rules with the JSON list in the body
+//	POST    append the JSON rule in the body
+//	DELETE  remove every rule
+func (in *Injector) AdminHandler() http.Handler {
+	var mu sync.Mutex // serializes read-modify-write of the rule list
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var rules []Rule
+			if !decode(w, r, &rules) {
+				return
+			}
+			if err := in.SetRules(rules); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		case http.MethodPost:
+			var rule Rule
+			if !decode(w, r, &rule) {
+				return
+			}
+			rules := append(append([]Rule(nil), in.Rules()...), rule)
+			if err := in.SetRules(rules); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		case http.MethodDelete:
+			_ = in.SetRules(nil)
+		default:
+			w.Header().Set("Allow", "GET, PUT, POST, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(adminView{Rules: in.Rules(), Counts: in.Counts()})
+	})
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
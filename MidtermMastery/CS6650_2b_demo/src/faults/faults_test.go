@@ -0,0 +1,200 @@
+package faults
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"text/main/config"
+)
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	_, _ = w.Write([]byte(`{"ok":true}`))
+})
+
+func newInjector(t *testing.T, rand float64, rules ...Rule) *Injector {
+	t.Helper()
+	in := New()
+	in.Rand = func() float64 { return rand }
+	if err := in.SetRules(rules); err != nil {
+		t.Fatal(err)
+	}
+	return in
+}
+
+func serve(h http.Handler, method, target string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestErrorRateByStatus(t *testing.T) {
+	rule := Rule{Route: "GET /products/search", Errors: []StatusRate{{Status: 503, Rate: 0.2}, {Status: 500, Rate: 0.5}}}
+
+	h := newInjector(t, 0.1, rule).Middleware(okHandler) // 0.1 < 0.2: first status fires
+	if rec := serve(h, "GET", "/products/search"); rec.Code != 503 {
+		t.Fatalf("status = %d, want 503", rec.Code)
+	}
+	h = newInjector(t, 0.3, rule).Middleware(okHandler) // misses 503, hits 500
+	if rec := serve(h, "GET", "/products/search"); rec.Code != 500 {
+		t.Fatalf("status = %d, want 500", rec.Code)
+	}
+	h = newInjector(t, 0.9, rule).Middleware(okHandler)
+	if rec := serve(h, "GET", "/products/search"); rec.Code != 200 {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+}
+
+func TestScopeByRouteAndHeader(t *testing.T) {
+	in := newInjector(t, 0, Rule{Route: "/products/", Header: "X-Chaos=on", Errors: []StatusRate{{Status: 500, Rate: 1}}})
+	h := in.Middleware(okHandler)
+
+	if rec := serve(h, "GET", "/products/7", "X-Chaos", "on"); rec.Code != 500 {
+		t.Fatalf("scoped request = %d, want 500", rec.Code)
+	}
+	if rec := serve(h, "GET", "/products/7", "X-Chaos", "off"); rec.Code != 200 {
+		t.Fatalf("other header value = %d, want 200", rec.Code)
+	}
+	if rec := serve(h, "GET", "/health", "X-Chaos", "on"); rec.Code != 200 {
+		t.Fatalf("other route = %d, want 200", rec.Code)
+	}
+	if c := in.Counts(); c.Errors != 1 {
+		t.Fatalf("counts = %+v", c)
+	}
+}
+
+func TestScopeByQuery(t *testing.T) {
+	h := newInjector(t, 0, Rule{Route: "/downstream", Query: "mode=fail", Errors: []StatusRate{{Status: 503, Rate: 1}}}).Middleware(okHandler)
+
+	if rec := serve(h, "GET", "/downstream?mode=fail"); rec.Code != 503 {
+		t.Fatalf("scoped request = %d, want 503", rec.Code)
+	}
+	for _, target := range []string{"/downstream?mode=slow", "/downstream"} {
+		if rec := serve(h, "GET", target); rec.Code != 200 {
+			t.Fatalf("%s = %d, want 200", target, rec.Code)
+		}
+	}
+}
+
+func TestLatencyDistributions(t *testing.T) {
+	in := New()
+	ms := func(n int) config.Duration { return config.Duration(time.Duration(n) * time.Millisecond) }
+	for _, tc := range []struct {
+		l    Latency
+		rand float64
+		want time.Duration
+	}{
+		{Latency{Dist: "fixed", Min: ms(50)}, 0.7, 50 * time.Millisecond},
+		{Latency{Dist: "uniform", Min: ms(10), Max: ms(30)}, 0.5, 20 * time.Millisecond},
+		{Latency{Dist: "exponential", Mean: ms(100), Max: ms(120)}, 0.99, 120 * time.Millisecond},
+		{Latency{Dist: "normal", Mean: ms(40), StdDev: ms(10), Min: ms(40)}, 0.5, 40 * time.Millisecond},
+	} {
+		in.Rand = func() float64 { return tc.rand }
+		if got := in.draw(&tc.l); got != tc.want {
+			t.Fatalf("%s draw = %v, want %v", tc.l.Dist, got, tc.want)
+		}
+	}
+}
+
+func TestLatencyDelaysRequest(t *testing.T) {
+	h := newInjector(t, 0, Rule{Latency: &Latency{Dist: "fixed", Min: config.Duration(20 * time.Millisecond)}}).Middleware(okHandler)
+	start := time.Now()
+	if rec := serve(h, "GET", "/"); rec.Code != 200 {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	if took := time.Since(start); took < 20*time.Millisecond {
+		t.Fatalf("took %v, want at least 20ms", took)
+	}
+}
+
+func TestCorruptBody(t *testing.T) {
+	h := newInjector(t, 0, Rule{CorruptRate: 1}).Middleware(okHandler)
+	rec := serve(h, "GET", "/")
+	if body := rec.Body.String(); body == `{"ok":true}` || len(body) != len(`{"ok":true}`) {
+		t.Fatalf("body = %q, want same length but corrupted", body)
+	}
+}
+
+func TestPanic(t *testing.T) {
+	h := newInjector(t, 0, Rule{PanicRate: 1}).Middleware(okHandler)
+	defer func() {
+		if recover() == nil {
+			t.Fatal("no panic")
+		}
+	}()
+	serve(h, "GET", "/")
+}
+
+func TestResetDropsConnection(t *testing.T) {
+	in := newInjector(t, 0, Rule{Route: "/boom", ResetRate: 1})
+	srv := httptest.NewServer(in.Middleware(okHandler))
+	defer srv.Close()
+
+	if _, err := http.Get(srv.URL + "/boom"); err == nil {
+		t.Fatal("request on a reset connection succeeded")
+	}
+	resp, err := http.Get(srv.URL + "/fine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if c := in.Counts(); c.Resets != 1 {
+		t.Fatalf("counts = %+v", c)
+	}
+}
+
+func TestAdminHandler(t *testing.T) {
+	in := New()
+	admin := in.AdminHandler()
+	do := func(method, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, httptest.NewRequest(method, "/admin/faults", strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := do("PUT", `[{"name":"slow","route":"/downstream","latency":{"dist":"uniform","min":"250ms","max":"600ms"}}]`); rec.Code != 200 {
+		t.Fatalf("PUT = %d %s", rec.Code, rec.Body)
+	}
+	if rec := do("POST", `{"name":"flaky","errors":[{"status":503,"rate":0.2}]}`); rec.Code != 200 {
+		t.Fatalf("POST = %d %s", rec.Code, rec.Body)
+	}
+	var view adminView
+	rec := do("GET", "")
+	if err := json.NewDecoder(rec.Body).Decode(&view); err != nil {
+		t.Fatal(err)
+	}
+	if len(view.Rules) != 2 || view.Rules[0].Latency.Rate != 1 || view.Rules[0].Latency.Max != config.Duration(600*time.Millisecond) {
+		t.Fatalf("rules = %+v", view.Rules)
+	}
+
+	for _, bad := range []string{`{"errors":[{"status":200,"rate":1}]}`, `{"panic_rate":2}`, `{"latency":{"dist":"zipf"}}`, `{"bogus":1}`} {
+		if rec := do("POST", bad); rec.Code != http.StatusBadRequest {
+			t.Fatalf("POST %s = %d, want 400", bad, rec.Code)
+		}
+	}
+	if len(in.Rules()) != 2 {
+		t.Fatal("a rejected rule changed the rule list")
+	}
+
+	do("DELETE", "")
+	if len(in.Rules()) != 0 {
+		t.Fatalf("rules after DELETE = %v", in.Rules())
+	}
+}
+
+func TestSkipExemptsRequests(t *testing.T) {
+	in := newInjector(t, 0, Rule{Errors: []StatusRate{{Status: 500, Rate: 1}}})
+	in.Skip = func(r *http.Request) bool { return strings.HasPrefix(r.URL.Path, "/admin/") }
+	h := in.Middleware(okHandler)
+	if rec := serve(h, "GET", "/admin/faults"); rec.Code != 200 || !bytes.Contains(rec.Body.Bytes(), []byte("ok")) {
+		t.Fatalf("skipped request = %d", rec.Code)
+	}
+}
@@ -0,0 +1,85 @@
+// Package flags is a small feature-flag store with percentage rollouts. A
+// flag is a percentage from 0 (off) to 100 (on); in between, whether it is
+// on for a request is decided by hashing the flag name with a key the
+// caller picks (a user, an API key, a connection), so the same key always
+// gets the same answer and each flag splits traffic independently.
+package flags
+
+import (
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"sync"
+)
+
+// Set is safe for concurrent use.
+type Set struct {
+	mu    sync.RWMutex
+	flags map[string]float64
+}
+
+func New() *Set { return &Set{flags: make(map[string]float64)} }
+
+// SetPercent turns name on for pct percent of keys.
+func (s *Set) SetPercent(name string, pct float64) error {
+	return s.Update(map[string]float64{name: pct})
+}
+
+// Update sets several flags at once. Nothing changes if any of them is
+// invalid.
+func (s *Set) Update(pcts map[string]float64) error {
+	for name, pct := range pcts {
+		if err := validate(name, pct); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	maps.Copy(s.flags, pcts)
+	s.mu.Unlock()
+	return nil
+}
+
+func validate(name string, pct float64) error {
+	if name == "" {
+		return fmt.Errorf("flag name must not be empty")
+	}
+	if pct < 0 || pct > 100 {
+		return fmt.Errorf("flag %s: percent must be between 0 and 100, got %v", name, pct)
+	}
+	return nil
+}
+
+// Percent returns name's rollout percentage; unknown flags are 0.
+func (s *Set) Percent(name string) float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.flags[name]
+}
+
+// Enabled reports whether name is on for key.
+func (s *Set) Enabled(name, key string) bool {
+	pct := s.Percent(name)
+	switch {
+	case pct <= 0:
+		return false
+	case pct >= 100:
+		return true
+	}
+	return Bucket(name, key) < pct
+}
+
+// All returns a copy of every flag's percentage.
+func (s *Set) All() map[string]float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return maps.Clone(s.flags)
+}
+
+// Bucket places key in [0, 100) for flag name, in steps of 0.01.
+func Bucket(name, key string) float64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(name))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(key))
+	return float64(h.Sum64()%10000) / 100
+}
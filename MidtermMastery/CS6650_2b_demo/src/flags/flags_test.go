@@ -0,0 +1,93 @@
+package flags
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestOnOffAndUnknown(t *testing.T) {
+	s := New()
+	if s.Enabled("missing", "k") {
+		t.Fatal("unknown flag is on")
+	}
+	_ = s.SetPercent("on", 100)
+	_ = s.SetPercent("off", 0)
+	for i := 0; i < 100; i++ {
+		k := strconv.Itoa(i)
+		if !s.Enabled("on", k) || s.Enabled("off", k) {
+			t.Fatalf("key %s: on=%v off=%v", k, s.Enabled("on", k), s.Enabled("off", k))
+		}
+	}
+}
+
+func TestRolloutIsStickyAndRoughlyProportional(t *testing.T) {
+	s := New()
+	_ = s.SetPercent("fixed", 30)
+
+	on := 0
+	for i := 0; i < 10000; i++ {
+		k := "client-" + strconv.Itoa(i)
+		got := s.Enabled("fixed", k)
+		if got != s.Enabled("fixed", k) {
+			t.Fatalf("key %s flipped between calls", k)
+		}
+		if got {
+			on++
+		}
+	}
+	if on < 2700 || on > 3300 {
+		t.Fatalf("%d of 10000 keys on at 30%%, want about 3000", on)
+	}
+}
+
+func TestRaisingPercentKeepsExistingKeysOn(t *testing.T) {
+	s := New()
+	_ = s.SetPercent("f", 10)
+	var was []string
+	for i := 0; i < 1000; i++ {
+		if k := strconv.Itoa(i); s.Enabled("f", k) {
+			was = append(was, k)
+		}
+	}
+	_ = s.SetPercent("f", 50)
+	for _, k := range was {
+		if !s.Enabled("f", k) {
+			t.Fatalf("key %s dropped out when the rollout grew", k)
+		}
+	}
+}
+
+func TestSetPercentValidates(t *testing.T) {
+	s := New()
+	for _, pct := range []float64{-1, 101} {
+		if err := s.SetPercent("f", pct); err == nil {
+			t.Fatalf("SetPercent(%v) = nil error", pct)
+		}
+	}
+	if err := s.SetPercent("", 5); err == nil {
+		t.Fatal("empty name accepted")
+	}
+	_ = s.SetPercent("f", 5)
+	all := s.All()
+	all["f"] = 99
+	if s.Percent("f") != 5 {
+		t.Fatal("All returned the live map")
+	}
+}
+
+func TestUpdateIsAllOrNothing(t *testing.T) {
+	s := New()
+	_ = s.SetPercent("a", 10)
+	if err := s.Update(map[string]float64{"a": 50, "b": 200}); err == nil {
+		t.Fatal("Update with an invalid flag = nil error")
+	}
+	if s.Percent("a") != 10 {
+		t.Fatalf("a = %v after a rejected update, want 10", s.Percent("a"))
+	}
+	if err := s.Update(map[string]float64{"a": 50, "b": 100}); err != nil {
+		t.Fatal(err)
+	}
+	if s.Percent("a") != 50 || s.Percent("b") != 100 {
+		t.Fatalf("flags = %v", s.All())
+	}
+}
@@ -0,0 +1,214 @@
+// Package hedge sends a backup ("hedged") request when the first one is
+// slower than most calls to the same dependency. The delay before hedging
+// is a percentile (p95 by default) of recent successful calls' latencies,
+// timed from the first request as the caller sees them. The first success
+// wins, and the loser is cancelled through its
+// context. An optional Budget caps hedges to a fraction of calls so a slow
+// dependency doesn't get twice the traffic.
+package hedge
+
+import (
+	"context"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Budget limits how many hedges are sent. Request is called once per call
+// and Withdraw once per hedge; *retry.Budget satisfies it.
+type Budget interface {
+	Request()
+	Withdraw() bool
+}
+
+// Config tunes a Hedger. Zero fields take the defaults noted.
+type Config struct {
+	// Percentile of recent latencies to wait before hedging. Default 0.95.
+	Percentile float64
+	// Window is how many recent latencies are kept. Default 256.
+	Window int
+	// MinSamples is how many latencies are needed before the percentile is
+	// trusted; until then InitialDelay is used. Defaults 20 and 100ms.
+	MinSamples   int
+	InitialDelay time.Duration
+	// MinDelay floors the hedge delay so a very fast dependency isn't
+	// hedged on every call. Default 1ms.
+	MinDelay time.Duration
+	// Budget, if set, must grant a token for each hedge.
+	Budget Budget
+	// Now is the clock used to time calls. Default time.Now.
+	Now func() time.Time
+}
+
+// Hedger is safe for concurrent use.
+type Hedger struct {
+	cfg Config
+
+	mu        sync.Mutex
+	samples   []time.Duration // ring buffer of successful calls' latencies
+	next      int
+	calls     uint64
+	hedges    uint64
+	hedgeWins uint64
+	denied    uint64
+}
+
+func New(cfg Config) *Hedger {
+	if cfg.Percentile <= 0 || cfg.Percentile > 1 {
+		cfg.Percentile = 0.95
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = 256
+	}
+	if cfg.MinSamples <= 0 {
+		cfg.MinSamples = 20
+	}
+	cfg.MinSamples = min(cfg.MinSamples, cfg.Window)
+	if cfg.InitialDelay <= 0 {
+		cfg.InitialDelay = 100 * time.Millisecond
+	}
+	if cfg.MinDelay <= 0 {
+		cfg.MinDelay = time.Millisecond
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	return &Hedger{cfg: cfg, samples: make([]time.Duration, 0, cfg.Window)}
+}
+
+// Do calls fn and, if it hasn't succeeded after h.Delay(), calls it again
+// with a second context. It returns the first successful result and
+// whether it came from the hedge; the other call's context is cancelled. An
+// error before the hedge is sent is returned straight away (retrying is not
+// hedging's job); after that Do waits for the other call, and returns the
+// last error if both fail. A nil h just calls fn.
+func Do[T any](ctx context.Context, h *Hedger, fn func(ctx context.Context) (T, error)) (T, bool, error) {
+	if h == nil {
+		v, err := fn(ctx)
+		return v, false, err
+	}
+	h.begin()
+	start := h.cfg.Now()
+
+	type result struct {
+		v     T
+		err   error
+		hedge bool
+	}
+	results := make(chan result, 2) // buffered so the loser never blocks
+	run := func(ctx context.Context, hedge bool) {
+		v, err := fn(ctx)
+		results <- result{v: v, err: err, hedge: hedge}
+	}
+
+	primaryCtx, cancelPrimary := context.WithCancel(ctx)
+	defer cancelPrimary()
+	hedgeCtx, cancelHedge := context.WithCancel(ctx)
+	defer cancelHedge()
+
+	go run(primaryCtx, false)
+	pending := 1
+
+	timer := time.NewTimer(h.Delay())
+	defer timer.Stop()
+	fire := timer.C
+
+	for {
+		select {
+		case <-fire:
+			fire = nil
+			if h.allowHedge() {
+				pending++
+				go run(hedgeCtx, true)
+			}
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				h.observe(h.cfg.Now().Sub(start), r.hedge)
+				return r.v, r.hedge, nil
+			}
+			if pending == 0 {
+				return r.v, r.hedge, r.err
+			}
+		}
+	}
+}
+
+// Delay is how long Do currently waits before hedging.
+func (h *Hedger) Delay() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.delayLocked()
+}
+
+func (h *Hedger) delayLocked() time.Duration {
+	if len(h.samples) < h.cfg.MinSamples {
+		return h.cfg.InitialDelay
+	}
+	sorted := slices.Clone(h.samples)
+	slices.Sort(sorted)
+	i := int(math.Ceil(h.cfg.Percentile*float64(len(sorted)))) - 1
+	return max(sorted[max(i, 0)], h.cfg.MinDelay)
+}
+
+func (h *Hedger) begin() {
+	h.mu.Lock()
+	h.calls++
+	h.mu.Unlock()
+	if h.cfg.Budget != nil {
+		h.cfg.Budget.Request()
+	}
+}
+
+func (h *Hedger) allowHedge() bool {
+	ok := h.cfg.Budget == nil || h.cfg.Budget.Withdraw()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if ok {
+		h.hedges++
+	} else {
+		h.denied++
+	}
+	return ok
+}
+
+// observe records a successful call's latency, timed from the first
+// request even when the hedge won. Timing a hedge from its own start would
+// pull the percentile down and so hedge ever sooner.
+func (h *Hedger) observe(took time.Duration, hedge bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if hedge {
+		h.hedgeWins++
+	}
+	if len(h.samples) < h.cfg.Window {
+		h.samples = append(h.samples, took)
+		return
+	}
+	h.samples[h.next] = took
+	h.next = (h.next + 1) % h.cfg.Window
+}
+
+// Snapshot is a point-in-time view for metrics and debug endpoints.
+type Snapshot struct {
+	Delay     string `json:"delay"`
+	Samples   int    `json:"samples"`
+	Calls     uint64 `json:"calls"`
+	Hedges    uint64 `json:"hedges"`
+	HedgeWins uint64 `json:"hedge_wins"`
+	Denied    uint64 `json:"denied"`
+}
+
+func (h *Hedger) Snapshot() Snapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return Snapshot{
+		Delay:     h.delayLocked().String(),
+		Samples:   len(h.samples),
+		Calls:     h.calls,
+		Hedges:    h.hedges,
+		HedgeWins: h.hedgeWins,
+		Denied:    h.denied,
+	}
+}
@@ -0,0 +1,145 @@
+package hedge
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// budget is a Budget with a fixed number of tokens.
+type budget struct{ tokens int32 }
+
+func (b *budget) Request() {}
+func (b *budget) Withdraw() bool {
+	return atomic.AddInt32(&b.tokens, -1) >= 0
+}
+
+func TestFastCallIsNotHedged(t *testing.T) {
+	h := New(Config{InitialDelay: 50 * time.Millisecond})
+	var calls int32
+	v, hedged, err := Do(context.Background(), h, func(context.Context) (string, error) {
+		atomic.AddInt32(&calls, 1)
+		return "ok", nil
+	})
+	if v != "ok" || hedged || err != nil || calls != 1 {
+		t.Fatalf("Do = %q, %v, %v with %d calls; want ok from one call", v, hedged, err, calls)
+	}
+}
+
+func TestSlowPrimaryLosesToHedgeAndIsCancelled(t *testing.T) {
+	h := New(Config{InitialDelay: 10 * time.Millisecond})
+	var n int32
+	primaryCancelled := make(chan struct{})
+	v, hedged, err := Do(context.Background(), h, func(ctx context.Context) (string, error) {
+		if atomic.AddInt32(&n, 1) == 1 {
+			select {
+			case <-ctx.Done():
+				close(primaryCancelled)
+				return "", ctx.Err()
+			case <-time.After(5 * time.Second):
+				return "primary", nil
+			}
+		}
+		return "hedge", nil
+	})
+	if v != "hedge" || !hedged || err != nil {
+		t.Fatalf("Do = %q, %v, %v; want hedge to win", v, hedged, err)
+	}
+	select {
+	case <-primaryCancelled:
+	case <-time.After(time.Second):
+		t.Fatal("primary's context was not cancelled")
+	}
+	if s := h.Snapshot(); s.Calls != 1 || s.Hedges != 1 || s.HedgeWins != 1 {
+		t.Fatalf("snapshot = %+v", s)
+	}
+}
+
+func TestHedgeWinIsTimedFromFirstRequest(t *testing.T) {
+	h := New(Config{Percentile: 1, MinSamples: 1, InitialDelay: 20 * time.Millisecond})
+	var n int32
+	_, hedged, err := Do(context.Background(), h, func(ctx context.Context) (string, error) {
+		if atomic.AddInt32(&n, 1) == 1 {
+			<-ctx.Done()
+			return "", ctx.Err()
+		}
+		return "hedge", nil // answers at once
+	})
+	if !hedged || err != nil {
+		t.Fatalf("Do = %v, %v; want hedge to win", hedged, err)
+	}
+	// The caller waited the whole hedge delay, so that is the latency seen.
+	if d := h.Delay(); d < 20*time.Millisecond {
+		t.Fatalf("Delay after a hedge win = %v, want at least the 20ms waited", d)
+	}
+}
+
+func TestEarlyErrorIsReturnedWithoutHedge(t *testing.T) {
+	h := New(Config{InitialDelay: 50 * time.Millisecond})
+	boom := errors.New("boom")
+	var calls int32
+	_, _, err := Do(context.Background(), h, func(context.Context) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		return 0, boom
+	})
+	if err != boom || calls != 1 {
+		t.Fatalf("err = %v after %d calls, want boom after 1", err, calls)
+	}
+}
+
+func TestPrimaryFailureAfterHedgeWaitsForHedge(t *testing.T) {
+	h := New(Config{InitialDelay: 5 * time.Millisecond})
+	var n int32
+	v, hedged, err := Do(context.Background(), h, func(ctx context.Context) (string, error) {
+		if atomic.AddInt32(&n, 1) == 1 {
+			time.Sleep(20 * time.Millisecond)
+			return "", errors.New("primary failed")
+		}
+		time.Sleep(40 * time.Millisecond)
+		return "hedge", nil
+	})
+	if v != "hedge" || !hedged || err != nil {
+		t.Fatalf("Do = %q, %v, %v; want the hedge's success", v, hedged, err)
+	}
+}
+
+func TestBudgetDeniesHedge(t *testing.T) {
+	h := New(Config{InitialDelay: 5 * time.Millisecond, Budget: &budget{}})
+	var calls int32
+	v, hedged, _ := Do(context.Background(), h, func(context.Context) (string, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(30 * time.Millisecond)
+		return "primary", nil
+	})
+	if v != "primary" || hedged || calls != 1 {
+		t.Fatalf("Do = %q, %v with %d calls; want primary only", v, hedged, calls)
+	}
+	if s := h.Snapshot(); s.Denied != 1 || s.Hedges != 0 {
+		t.Fatalf("snapshot = %+v, want 1 denied", s)
+	}
+}
+
+func TestDelayTracksPercentile(t *testing.T) {
+	h := New(Config{Percentile: 0.9, MinSamples: 10, InitialDelay: time.Second})
+	if d := h.Delay(); d != time.Second {
+		t.Fatalf("Delay with no samples = %v, want InitialDelay", d)
+	}
+	for i := 1; i <= 10; i++ {
+		h.observe(time.Duration(i)*time.Millisecond, false)
+	}
+	if d := h.Delay(); d != 9*time.Millisecond {
+		t.Fatalf("p90 of 1..10ms = %v, want 9ms", d)
+	}
+}
+
+func TestWindowKeepsRecentSamples(t *testing.T) {
+	h := New(Config{Percentile: 1, Window: 4, MinSamples: 4})
+	for _, ms := range []int{100, 100, 100, 100, 5, 6, 7, 8} {
+		h.observe(time.Duration(ms)*time.Millisecond, false)
+	}
+	if d := h.Delay(); d != 8*time.Millisecond {
+		t.Fatalf("max of last 4 = %v, want 8ms", d)
+	}
+}
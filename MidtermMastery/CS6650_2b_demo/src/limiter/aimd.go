@@ -0,0 +1,57 @@
+package limiter
+
+import "time"
+
+// AIMDConfig tunes the additive-increase/multiplicative-decrease algorithm.
+// Zero fields take the defaults noted.
+type AIMDConfig struct {
+	Initial int // default 30, the old fixed bulkhead size
+	Min     int // default 1
+	Max     int // default 200
+	// Backoff multiplies the limit on a drop or slow call. Default 0.9.
+	Backoff float64
+	// SlowRTT counts a call as a drop when it takes longer. 0 = only
+	// explicit drops count.
+	SlowRTT time.Duration
+}
+
+// AIMD grows the limit by one per healthy call while the limit is actually
+// being used, and cuts it by Backoff on every drop.
+type AIMD struct {
+	cfg   AIMDConfig
+	limit int
+}
+
+func NewAIMD(cfg AIMDConfig) *AIMD {
+	if cfg.Initial <= 0 {
+		cfg.Initial = 30
+	}
+	if cfg.Min <= 0 {
+		cfg.Min = 1
+	}
+	if cfg.Max <= 0 {
+		cfg.Max = 200
+	}
+	if cfg.Backoff <= 0 || cfg.Backoff >= 1 {
+		cfg.Backoff = 0.9
+	}
+	return &AIMD{cfg: cfg, limit: clampInt(cfg.Initial, cfg.Min, cfg.Max)}
+}
+
+func (a *AIMD) Limit() int { return a.limit }
+
+func (a *AIMD) Update(rtt time.Duration, inflight int, dropped bool) {
+	if dropped || (a.cfg.SlowRTT > 0 && rtt > a.cfg.SlowRTT) {
+		a.limit = clampInt(int(float64(a.limit)*a.cfg.Backoff), a.cfg.Min, a.cfg.Max)
+		return
+	}
+	// Only grow when the current limit is at least half used; otherwise a
+	// quiet period would ratchet the limit up to Max for no reason.
+	if inflight*2 >= a.limit {
+		a.limit = clampInt(a.limit+1, a.cfg.Min, a.cfg.Max)
+	}
+}
+
+func clampInt(v, lo, hi int) int {
+	return max(lo, min(hi, v))
+}
@@ -0,0 +1,91 @@
+package limiter
+
+import (
+	"math"
+	"time"
+)
+
+// GradientConfig tunes the gradient (Vegas-style) algorithm. Zero fields
+// take the defaults noted.
+type GradientConfig struct {
+	Initial int // default 30
+	Min     int // default 1
+	Max     int // default 200
+	// Smoothing is how far each update moves the limit towards its target,
+	// 0..1. Default 0.2.
+	Smoothing float64
+	// Tolerance is how much slower than the long-term RTT calls may get
+	// before the limit shrinks. Default 1.5.
+	Tolerance float64
+	// LongWindow is roughly how many samples the long-term RTT average
+	// spans. Default 600.
+	LongWindow int
+}
+
+// Gradient compares a short-term RTT average against a long-term one. When
+// recent calls are as fast as usual the limit grows by a small queue
+// allowance (sqrt(limit)); as they slow down, the ratio shrinks the limit
+// proportionally. Drops halve the target.
+type Gradient struct {
+	cfg      GradientConfig
+	limit    float64
+	shortRTT float64 // ns, fast EMA
+	longRTT  float64 // ns, slow EMA
+}
+
+func NewGradient(cfg GradientConfig) *Gradient {
+	if cfg.Initial <= 0 {
+		cfg.Initial = 30
+	}
+	if cfg.Min <= 0 {
+		cfg.Min = 1
+	}
+	if cfg.Max <= 0 {
+		cfg.Max = 200
+	}
+	if cfg.Smoothing <= 0 || cfg.Smoothing > 1 {
+		cfg.Smoothing = 0.2
+	}
+	if cfg.Tolerance < 1 {
+		cfg.Tolerance = 1.5
+	}
+	if cfg.LongWindow <= 0 {
+		cfg.LongWindow = 600
+	}
+	return &Gradient{cfg: cfg, limit: float64(clampInt(cfg.Initial, cfg.Min, cfg.Max))}
+}
+
+func (g *Gradient) Limit() int { return int(g.limit) }
+
+func (g *Gradient) Update(rtt time.Duration, inflight int, dropped bool) {
+	var target float64
+	if dropped {
+		target = g.limit / 2
+	} else {
+		sample := float64(rtt)
+		if g.longRTT == 0 {
+			g.shortRTT, g.longRTT = sample, sample
+		}
+		g.shortRTT = ema(g.shortRTT, sample, 10)
+		g.longRTT = ema(g.longRTT, sample, g.cfg.LongWindow)
+
+		// Don't grow while the limit isn't being used.
+		if float64(inflight) < g.limit/2 {
+			return
+		}
+		// With every RTT 0 (a coarse clock) there is no trend to follow.
+		gradient := 1.0
+		if g.shortRTT > 0 {
+			gradient = math.Max(0.5, math.Min(1, g.cfg.Tolerance*g.longRTT/g.shortRTT))
+		}
+		target = g.limit*gradient + math.Sqrt(g.limit)
+	}
+
+	next := g.limit*(1-g.cfg.Smoothing) + target*g.cfg.Smoothing
+	g.limit = math.Max(float64(g.cfg.Min), math.Min(float64(g.cfg.Max), next))
+}
+
+func ema(avg, sample float64, window int) float64 {
+	alpha := 2 / (float64(window) + 1)
+	return avg + alpha*(sample-avg)
+}
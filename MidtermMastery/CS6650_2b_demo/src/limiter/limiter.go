@@ -0,0 +1,203 @@
+// Package limiter is an adaptive concurrency limiter for downstream calls,
+// in the spirit of Netflix's concurrency-limits. Instead of a fixed number
+// of slots, an Algorithm moves the limit up while latency looks healthy and
+// down when calls slow down or get dropped. Callers over the limit can wait
+// in a short bounded queue rather than being rejected outright.
+package limiter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrLimitExceeded is returned by Acquire when the limit is reached and the
+// queue is full or the queue wait ran out.
+var ErrLimitExceeded = errors.New("concurrency limit exceeded")
+
+// Algorithm decides the limit from completed calls. The Limiter calls it
+// with its own lock held, so implementations need no locking of their own.
+type Algorithm interface {
+	// Limit is the current number of calls allowed in flight.
+	Limit() int
+	// Update records one finished call: its round-trip time, how many calls
+	// were in flight when it started, and whether it was dropped (timed out
+	// or rejected by the dependency, i.e. a sign of overload).
+	Update(rtt time.Duration, inflight int, dropped bool)
+}
+
+type Config struct {
+	// Algorithm defaults to NewAIMD(AIMDConfig{}).
+	Algorithm Algorithm
+	// MaxQueue is how many callers may wait for a slot once the limit is
+	// reached. 0 rejects immediately, like the old bulkhead channel.
+	MaxQueue int
+	// MaxWait bounds how long a queued caller waits. Default 10ms.
+	MaxWait time.Duration
+	// Now is the clock used to time calls. Default time.Now.
+	Now func() time.Time
+}
+
+// Limiter is safe for concurrent use.
+type Limiter struct {
+	alg     Algorithm
+	maxQ    int
+	maxWait time.Duration
+	now     func() time.Time
+
+	mu       sync.Mutex
+	inflight int
+	queue    []*waiter
+	accepted uint64
+	rejected uint64
+	dropped  uint64
+}
+
+type waiter struct {
+	ready   chan struct{}
+	granted bool // set under mu when a slot is handed over
+}
+
+func New(cfg Config) *Limiter {
+	if cfg.Algorithm == nil {
+		cfg.Algorithm = NewAIMD(AIMDConfig{})
+	}
+	if cfg.MaxWait <= 0 {
+		cfg.MaxWait = 10 * time.Millisecond
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	return &Limiter{alg: cfg.Algorithm, maxQ: cfg.MaxQueue, maxWait: cfg.MaxWait, now: cfg.Now}
+}
+
+// Token is one admitted call. Finish it with exactly one of Success,
+// Dropped or Ignore.
+type Token struct {
+	l        *Limiter
+	start    time.Time
+	inflight int
+}
+
+// Acquire admits a call, waiting in the queue up to MaxWait (or until ctx is
+// done) if the limit has been reached.
+func (l *Limiter) Acquire(ctx context.Context) (Token, error) {
+	l.mu.Lock()
+	if l.inflight < l.alg.Limit() && len(l.queue) == 0 {
+		t := l.admit()
+		l.mu.Unlock()
+		return t, nil
+	}
+	if len(l.queue) >= l.maxQ {
+		l.rejected++
+		l.mu.Unlock()
+		return Token{}, ErrLimitExceeded
+	}
+	w := &waiter{ready: make(chan struct{})}
+	l.queue = append(l.queue, w)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(l.maxWait)
+	defer timer.Stop()
+
+	select {
+	case <-w.ready:
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if w.granted {
+		// Won the slot, possibly at the same moment the wait ran out.
+		l.accepted++
+		return Token{l: l, start: l.now(), inflight: l.inflight}, nil
+	}
+	l.removeWaiter(w)
+	l.rejected++
+	if err := ctx.Err(); err != nil {
+		return Token{}, err
+	}
+	return Token{}, ErrLimitExceeded
+}
+
+// admit takes a slot. Callers hold l.mu.
+func (l *Limiter) admit() Token {
+	l.inflight++
+	l.accepted++
+	return Token{l: l, start: l.now(), inflight: l.inflight}
+}
+
+func (l *Limiter) removeWaiter(w *waiter) {
+	for i, q := range l.queue {
+		if q == w {
+			l.queue = append(l.queue[:i], l.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// Success records a call that completed normally; its latency feeds the
+// algorithm.
+func (t Token) Success() { t.finish(true, false) }
+
+// Dropped records a call that timed out or was turned away by the
+// dependency; the algorithm treats it as overload.
+func (t Token) Dropped() { t.finish(true, true) }
+
+// Ignore releases the slot without telling the algorithm anything, for calls
+// whose latency says nothing about the dependency's load.
+func (t Token) Ignore() { t.finish(false, false) }
+
+func (t Token) finish(sample, dropped bool) {
+	l := t.l
+	if l == nil {
+		return
+	}
+	rtt := l.now().Sub(t.start)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if dropped {
+		l.dropped++
+	}
+	if sample {
+		l.alg.Update(rtt, t.inflight, dropped)
+	}
+	l.inflight--
+
+	// Hand freed slots (and any the limit just grew by) to the queue in
+	// order. The slot stays counted in inflight for the waiter.
+	for len(l.queue) > 0 && l.inflight < l.alg.Limit() {
+		w := l.queue[0]
+		l.queue = l.queue[1:]
+		w.granted = true
+		l.inflight++
+		close(w.ready)
+	}
+}
+
+// Snapshot is a point-in-time view for metrics and debug endpoints.
+type Snapshot struct {
+	Limit    int    `json:"limit"`
+	InFlight int    `json:"in_flight"`
+	Queued   int    `json:"queued"`
+	Accepted uint64 `json:"accepted"`
+	Rejected uint64 `json:"rejected"`
+	Dropped  uint64 `json:"dropped"`
+}
+
+func (l *Limiter) Snapshot() Snapshot {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return Snapshot{
+		Limit:    l.alg.Limit(),
+		InFlight: l.inflight,
+		Queued:   len(l.queue),
+		Accepted: l.accepted,
+		Rejected: l.rejected,
+		Dropped:  l.dropped,
+	}
+}
@@ -0,0 +1,217 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fixed is an Algorithm with a constant limit, for queueing tests.
+type fixed int
+
+func (f fixed) Limit() int                    { return int(f) }
+func (fixed) Update(time.Duration, int, bool) {}
+
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.t = c.t.Add(d)
+	c.mu.Unlock()
+}
+
+func TestRejectsImmediatelyWithoutQueue(t *testing.T) {
+	l := New(Config{Algorithm: fixed(2)})
+	a, _ := l.Acquire(context.Background())
+	_, _ = l.Acquire(context.Background())
+
+	if _, err := l.Acquire(context.Background()); err != ErrLimitExceeded {
+		t.Fatalf("third Acquire err = %v, want ErrLimitExceeded", err)
+	}
+	a.Success()
+	if _, err := l.Acquire(context.Background()); err != nil {
+		t.Fatalf("Acquire after release: %v", err)
+	}
+	if s := l.Snapshot(); s.Limit != 2 || s.InFlight != 2 || s.Rejected != 1 || s.Accepted != 3 {
+		t.Fatalf("snapshot = %+v", s)
+	}
+}
+
+func TestQueuedCallerGetsFreedSlot(t *testing.T) {
+	l := New(Config{Algorithm: fixed(1), MaxQueue: 1, MaxWait: time.Second})
+	first, _ := l.Acquire(context.Background())
+
+	got := make(chan error, 1)
+	go func() {
+		tok, err := l.Acquire(context.Background())
+		if err == nil {
+			tok.Success()
+		}
+		got <- err
+	}()
+	for l.Snapshot().Queued != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Queue is full: a third caller is rejected straight away.
+	if _, err := l.Acquire(context.Background()); err != ErrLimitExceeded {
+		t.Fatalf("Acquire with full queue err = %v, want ErrLimitExceeded", err)
+	}
+
+	first.Success()
+	if err := <-got; err != nil {
+		t.Fatalf("queued Acquire: %v", err)
+	}
+	if s := l.Snapshot(); s.InFlight != 0 || s.Queued != 0 {
+		t.Fatalf("snapshot = %+v", s)
+	}
+}
+
+func TestQueueWaitTimesOut(t *testing.T) {
+	l := New(Config{Algorithm: fixed(1), MaxQueue: 5, MaxWait: 5 * time.Millisecond})
+	_, _ = l.Acquire(context.Background())
+
+	if _, err := l.Acquire(context.Background()); err != ErrLimitExceeded {
+		t.Fatalf("err = %v, want ErrLimitExceeded", err)
+	}
+	if s := l.Snapshot(); s.Queued != 0 || s.InFlight != 1 {
+		t.Fatalf("timed-out waiter left behind: %+v", s)
+	}
+}
+
+func TestQueueWaitHonoursContext(t *testing.T) {
+	l := New(Config{Algorithm: fixed(1), MaxQueue: 5, MaxWait: time.Minute})
+	_, _ = l.Acquire(context.Background())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.Acquire(ctx); err != context.Canceled {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+}
+
+func TestAIMD(t *testing.T) {
+	a := NewAIMD(AIMDConfig{Initial: 10, Min: 2, Max: 12, SlowRTT: 100 * time.Millisecond})
+
+	a.Update(10*time.Millisecond, 2, false) // limit mostly idle: no growth
+	if a.Limit() != 10 {
+		t.Fatalf("limit = %d, want 10", a.Limit())
+	}
+	for i := 0; i < 5; i++ {
+		a.Update(10*time.Millisecond, 8, false)
+	}
+	if a.Limit() != 12 {
+		t.Fatalf("limit = %d, want capped at 12", a.Limit())
+	}
+
+	a.Update(10*time.Millisecond, 8, true) // 12 * 0.9 = 10
+	if a.Limit() != 10 {
+		t.Fatalf("limit after drop = %d, want 10", a.Limit())
+	}
+	a.Update(200*time.Millisecond, 8, false) // slow counts as a drop: 10 * 0.9 = 9
+	if a.Limit() != 9 {
+		t.Fatalf("limit after slow call = %d, want 9", a.Limit())
+	}
+	for i := 0; i < 50; i++ {
+		a.Update(0, 0, true)
+	}
+	if a.Limit() != 2 {
+		t.Fatalf("limit = %d, want floor of 2", a.Limit())
+	}
+}
+
+func TestGradientShrinksWhenLatencyRises(t *testing.T) {
+	g := NewGradient(GradientConfig{Initial: 50, Max: 100})
+	for i := 0; i < 200; i++ {
+		g.Update(20*time.Millisecond, 50, false)
+	}
+	steady := g.Limit()
+	if steady <= 50 {
+		t.Fatalf("limit with steady fast calls = %d, want growth above 50", steady)
+	}
+
+	for i := 0; i < 50; i++ {
+		g.Update(200*time.Millisecond, 100, false)
+	}
+	if g.Limit() >= steady {
+		t.Fatalf("limit after latency rose = %d, want below %d", g.Limit(), steady)
+	}
+
+	g = NewGradient(GradientConfig{Initial: 50})
+	g.Update(0, 50, true) // target 25, smoothed: 50*0.8 + 25*0.2
+	if g.Limit() != 45 {
+		t.Fatalf("limit after drop = %d, want 45", g.Limit())
+	}
+}
+
+func TestGradientWithZeroRTTs(t *testing.T) {
+	g := NewGradient(GradientConfig{Initial: 50, Max: 100})
+	for i := 0; i < 200; i++ {
+		g.Update(0, 100, false)
+	}
+	if g.Limit() != 100 {
+		t.Fatalf("limit with zero RTTs = %d, want growth to the max 100", g.Limit())
+	}
+}
+
+func TestLimiterFeedsAlgorithm(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	l := New(Config{Algorithm: NewAIMD(AIMDConfig{Initial: 4, SlowRTT: 50 * time.Millisecond}), Now: clock.Now})
+
+	var toks []Token
+	for i := 0; i < 4; i++ {
+		tok, err := l.Acquire(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		toks = append(toks, tok)
+	}
+	clock.Advance(100 * time.Millisecond) // every call is slow
+	for _, tok := range toks {
+		tok.Success()
+	}
+	if s := l.Snapshot(); s.Limit >= 4 {
+		t.Fatalf("limit after slow calls = %d, want below 4", s.Limit)
+	}
+}
+
+func TestConcurrentAcquireRelease(t *testing.T) {
+	l := New(Config{Algorithm: NewAIMD(AIMDConfig{Initial: 5, Max: 10}), MaxQueue: 4, MaxWait: time.Millisecond})
+
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				tok, err := l.Acquire(context.Background())
+				if err != nil {
+					continue
+				}
+				if s := l.Snapshot(); s.InFlight > 10 {
+					t.Errorf("in flight %d over max limit", s.InFlight)
+				}
+				if (g+i)%7 == 0 {
+					tok.Dropped()
+				} else {
+					tok.Success()
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if s := l.Snapshot(); s.InFlight != 0 || s.Queued != 0 {
+		t.Fatalf("leaked slots: %+v", s)
+	}
+}
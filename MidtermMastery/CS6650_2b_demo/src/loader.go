@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	defaultBrands     = []string{"Alpha", "Beta", "Gamma", "Delta", "Omega"}
+	defaultCategories = []string{"Electronics", "Books", "Home", "Sports", "Toys"}
+)
+
+// productLoader streams a dataset into the store one product at a time, so a
+// large file never has to sit in memory twice.
+type productLoader interface {
+	Load(emit func(Product) error) error
+	String() string
+}
+
+// datasetConfig picks the loader. Env vars:
+//
+//	PRODUCTS_FILE        path to a .jsonl/.ndjson or .csv file (wins over synthetic)
+//	PRODUCTS_FORMAT      "jsonl" or "csv", when the extension doesn't say
+//	PRODUCTS_COUNT       synthetic product count (default 100000)
+//	PRODUCTS_SEED        synthetic RNG seed (default 1)
+//	PRODUCTS_ZIPF_S      Zipf skew (> 1) for brands/categories; unset = round-robin
+//	PRODUCTS_BRANDS      comma-separated synthetic brands
+//	PRODUCTS_CATEGORIES  comma-separated synthetic categories
+type datasetConfig struct {
+	File       string
+	Format     string
+	Count      int
+	Seed       int64
+	ZipfS      float64
+	Brands     []string
+	Categories []string
+}
+
+func datasetConfigFromEnv() (datasetConfig, error) {
+	cfg := datasetConfig{
+		File:       os.Getenv("PRODUCTS_FILE"),
+		Format:     strings.ToLower(os.Getenv("PRODUCTS_FORMAT")),
+		Count:      100_000,
+		Seed:       1,
+		Brands:     defaultBrands,
+		Categories: defaultCategories,
+	}
+
+	if v := os.Getenv("PRODUCTS_COUNT"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("PRODUCTS_COUNT must be a non-negative integer, got %q", v)
+		}
+		cfg.Count = n
+	}
+	if v := os.Getenv("PRODUCTS_SEED"); v != "" {
+		seed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return cfg, fmt.Errorf("PRODUCTS_SEED must be an integer, got %q", v)
+		}
+		cfg.Seed = seed
+	}
+	if v := os.Getenv("PRODUCTS_ZIPF_S"); v != "" {
+		s, err := strconv.ParseFloat(v, 64)
+		if err != nil || s <= 1 {
+			return cfg, fmt.Errorf("PRODUCTS_ZIPF_S must be a number > 1, got %q", v)
+		}
+		cfg.ZipfS = s
+	}
+	if v := os.Getenv("PRODUCTS_BRANDS"); v != "" {
+		cfg.Brands = splitList(v)
+	}
+	if v := os.Getenv("PRODUCTS_CATEGORIES"); v != "" {
+		cfg.Categories = splitList(v)
+	}
+	if len(cfg.Brands) == 0 || len(cfg.Categories) == 0 {
+		return cfg, errors.New("PRODUCTS_BRANDS and PRODUCTS_CATEGORIES must not be empty")
+	}
+	return cfg, nil
+}
+
+func (c datasetConfig) loader() (productLoader, error) {
+	if c.File == "" {
+		return syntheticLoader{
+			count:      c.Count,
+			seed:       c.Seed,
+			zipfS:      c.ZipfS,
+			brands:     c.Brands,
+			categories: c.Categories,
+		}, nil
+	}
+
+	format := c.Format
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(c.File)) {
+		case ".jsonl", ".ndjson":
+			format = "jsonl"
+		case ".csv":
+			format = "csv"
+		}
+	}
+	switch format {
+	case "jsonl":
+		return jsonlLoader{path: c.File}, nil
+	case "csv":
+		return csvLoader{path: c.File}, nil
+	default:
+		return nil, fmt.Errorf("can't tell the format of %s; set PRODUCTS_FORMAT=jsonl|csv", c.File)
+	}
+}
+
+func splitList(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+type loadStats struct {
+	Products  int
+	Duration  time.Duration
+	HeapDelta int64  // bytes the store grew the live heap by
+	HeapTotal uint64 // live heap after loading
+}
+
+// loadProducts runs l into store and measures how long it took and how much
+// live heap the products occupy afterwards.
+func loadProducts(store *productStore, l productLoader) (loadStats, error) {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+
+	n := 0
+	err := l.Load(func(p Product) error {
+		if _, err := store.add(p); err != nil {
+			return fmt.Errorf("product %d: %w", p.ID, err)
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		return loadStats{}, fmt.Errorf("%s: %w", l, err)
+	}
+
+	dur := time.Since(start)
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	return loadStats{
+		Products:  n,
+		Duration:  dur,
+		HeapDelta: int64(after.HeapAlloc) - int64(before.HeapAlloc),
+		HeapTotal: after.HeapAlloc,
+	}, nil
+}
+
+// ---------- File loaders ----------
+
+// jsonlLoader reads one JSON product per line; blank lines are skipped.
+type jsonlLoader struct{ path string }
+
+func (l jsonlLoader) String() string { return "jsonl file " + l.path }
+
+func (l jsonlLoader) Load(emit func(Product) error) error {
+	f, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	for line := 1; sc.Scan(); line++ {
+		b := sc.Bytes()
+		if len(strings.TrimSpace(string(b))) == 0 {
+			continue
+		}
+		var p Product
+		if err := json.Unmarshal(b, &p); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := emit(p); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return sc.Err()
+}
+
+// csvLoader reads a CSV with a header row naming its columns (any order):
+// id, name, category, description, brand. Only name is required; a missing
+// or empty id gets the next free one.
+type csvLoader struct{ path string }
+
+func (l csvLoader) String() string { return "csv file " + l.path }
+
+func (l csvLoader) Load(emit func(Product) error) error {
+	f, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(bufio.NewReader(f))
+	r.ReuseRecord = true
+	header, err := r.Read()
+	if err != nil {
+		return fmt.Errorf("reading header: %w", err)
+	}
+	col := map[string]int{}
+	for i, h := range header {
+		col[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	if _, ok := col["name"]; !ok {
+		return errors.New(`header must include a "name" column`)
+	}
+	field := func(rec []string, name string) string {
+		if i, ok := col[name]; ok && i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+
+	for line := 2; ; line++ {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		p := Product{
+			Name:        field(rec, "name"),
+			Category:    field(rec, "category"),
+			Description: field(rec, "description"),
+			Brand:       field(rec, "brand"),
+		}
+		if v := field(rec, "id"); v != "" {
+			if p.ID, err = strconv.Atoi(v); err != nil {
+				return fmt.Errorf("line %d: bad id %q", line, v)
+			}
+		}
+		if err := emit(p); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+}
+
+// ---------- Synthetic generator ----------
+
+// syntheticLoader generates products. With zipfS == 0 it deals brands and
+// categories round-robin (the original generateProducts layout); with zipfS > 1
+// a few brands/categories dominate the way they do in a real catalog. The
+// same seed always produces the same dataset.
+type syntheticLoader struct {
+	count      int
+	seed       int64
+	zipfS      float64
+	brands     []string
+	categories []string
+}
+
+func (l syntheticLoader) String() string {
+	if l.zipfS == 0 {
+		return fmt.Sprintf("synthetic(n=%d, round-robin)", l.count)
+	}
+	return fmt.Sprintf("synthetic(n=%d, zipf s=%g, seed=%d)", l.count, l.zipfS, l.seed)
+}
+
+func (l syntheticLoader) Load(emit func(Product) error) error {
+	descs := []string{"Great product", "High quality", "Budget option", "Premium build", "Popular item"}
+
+	rng := rand.New(rand.NewSource(l.seed))
+	pickBrand := func(i int) string { return l.brands[i%len(l.brands)] }
+	pickCategory := func(i int) string { return l.categories[i%len(l.categories)] }
+	if l.zipfS > 1 {
+		brandZipf := zipfOver(rng, l.zipfS, len(l.brands))
+		catZipf := zipfOver(rng, l.zipfS, len(l.categories))
+		pickBrand = func(int) string { return l.brands[brandZipf()] }
+		pickCategory = func(int) string { return l.categories[catZipf()] }
+	}
+
+	for i := 0; i < l.count; i++ {
+		brand := pickBrand(i)
+		p := Product{
+			ID:          i + 1,
+			Name:        fmt.Sprintf("Product %s %d", brand, i+1),
+			Category:    pickCategory(i),
+			Description: descs[i%len(descs)],
+			Brand:       brand,
+		}
+		if err := emit(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// zipfOver returns a sampler of indexes in [0, n) where index 0 is the most
+// frequent. rand.Zipf needs at least two values, so n == 1 is special-cased.
+func zipfOver(rng *rand.Rand, s float64, n int) func() int {
+	if n < 2 {
+		return func() int { return 0 }
+	}
+	z := rand.NewZipf(rng, s, 1, uint64(n-1))
+	return func() int { return int(z.Uint64()) }
+}
@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestSyntheticRoundRobinMatchesOriginalLayout(t *testing.T) {
+	var got []Product
+	l := syntheticLoader{count: 6, brands: defaultBrands, categories: defaultCategories}
+	_ = l.Load(func(p Product) error {
+		got = append(got, p)
+		return nil
+	})
+
+	want := Product{ID: 6, Name: "Product Alpha 6", Category: "Electronics", Description: "Great product", Brand: "Alpha"}
+	if len(got) != 6 || got[5] != want {
+		t.Fatalf("product 6 = %+v, want %+v", got[len(got)-1], want)
+	}
+}
+
+func TestSyntheticZipfSameSeedSameData(t *testing.T) {
+	gen := func() []Product {
+		var out []Product
+		l := syntheticLoader{count: 1000, seed: 7, zipfS: 2, brands: defaultBrands, categories: defaultCategories}
+		_ = l.Load(func(p Product) error {
+			out = append(out, p)
+			return nil
+		})
+		return out
+	}
+	a, b := gen(), gen()
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("same seed differs at %d: %+v vs %+v", i, a[i], b[i])
+		}
+	}
+}
@@ -1,17 +1,26 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"math/rand"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
-	"sync"
 	"sync/atomic"
 	"time"
+
+	"text/main/breaker"
+	"text/main/config"
+	"text/main/fallback"
+	"text/main/hedge"
+	"text/main/ratelimit"
+	"text/main/retry"
 )
 
 // ---------- Product Model ----------
@@ -24,25 +33,183 @@ type Product struct {
 }
 
 // ---------- In-memory store ----------
-var products []Product
-
-func generateProducts(n int) {
-	brands := []string{"Alpha", "Beta", "Gamma", "Delta", "Omega"}
-	cats := []string{"Electronics", "Books", "Home", "Sports", "Toys"}
-	descs := []string{"Great product", "High quality", "Budget option", "Premium build", "Popular item"}
-
-	products = make([]Product, n)
-	for i := 0; i < n; i++ {
-		brand := brands[i%len(brands)]
-		cat := cats[i%len(cats)]
-		products[i] = Product{
-			ID:          i + 1,
-			Name:        fmt.Sprintf("Product %s %d", brand, i+1),
-			Category:    cat,
-			Description: descs[i%len(descs)],
-			Brand:       brand,
+var catalog = newProductStore()
+
+// ---------- Search result cache ----------
+// resultCache holds the product part of search responses (downstream status
+// and mode are filled in per request). main configures it from the env; when
+// nil every search is computed directly.
+var resultCache *searchCache
+
+// searchCatalog runs the 100-product scan through resultCache.
+func searchCatalog(q string) (SearchResponse, cacheResult) {
+	return resultCache.get(searchCacheKey(q), func() SearchResponse {
+		results, totalFound, checked := catalog.search(q, 100, 20)
+		return SearchResponse{Products: results, TotalFound: totalFound, Checked: checked}
+	})
+}
+
+func cacheStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if resultCache == nil {
+		writeJSON(w, http.StatusOK, cacheSnapshot{})
+		return
+	}
+	writeJSON(w, http.StatusOK, resultCache.snapshot())
+}
+
+// ---------- Product writes ----------
+// Writes go through catalog, which updates the product and its search fields
+// under one lock, so both search handlers see them on the next request.
+
+func getProductHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	p, found := catalog.get(id)
+	if !found {
+		http.Error(w, errProductNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+func createProductHandler(w http.ResponseWriter, r *http.Request) {
+	p, ok := decodeProduct(w, r)
+	if !ok {
+		return
+	}
+	created, err := catalog.add(p)
+	if errors.Is(err, errProductExists) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func updateProductHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	p, ok := decodeProduct(w, r)
+	if !ok {
+		return
+	}
+	if p.ID != 0 && p.ID != id {
+		http.Error(w, "body id must match path id", http.StatusBadRequest)
+		return
+	}
+	p.ID = id
+	if err := catalog.update(p); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+func deleteProductHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	if err := catalog.remove(id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listProductsHandler serves /products?offset=0&limit=1000, streaming large
+// pages batch by batch instead of building them up first.
+func listProductsHandler(w http.ResponseWriter, r *http.Request) {
+	offset, limit, ok := pageParams(w, r)
+	if !ok {
+		return
+	}
+	streamProducts(w, catalog, offset, limit)
+}
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 100_000
+)
+
+func pageParams(w http.ResponseWriter, r *http.Request) (offset, limit int, ok bool) {
+	q := r.URL.Query()
+	offset, limit = 0, defaultListLimit
+	var err error
+	if v := q.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			http.Error(w, "offset must be a non-negative integer", http.StatusBadRequest)
+			return 0, 0, false
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxListLimit {
+			http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxListLimit), http.StatusBadRequest)
+			return 0, 0, false
+		}
+	}
+	return offset, limit, true
+}
+
+// streamProducts writes a ProductPage, copying products out of the store a
+// batch at a time. It's not a snapshot: writes can land between batches.
+func streamProducts(w http.ResponseWriter, store *productStore, offset, limit int) {
+	prefix := fmt.Sprintf(`{"offset":%d,"limit":%d,"total":%d,"products":`, offset, limit, store.len())
+	out := startJSONArray(w, http.StatusOK, prefix)
+
+	buf := make([]Product, 256)
+	for sent := 0; sent < limit; {
+		batch := store.page(offset+sent, buf[:min(len(buf), limit-sent)])
+		if len(batch) == 0 {
+			break
+		}
+		for _, p := range batch {
+			if err := out.add(p); err != nil {
+				return // client went away
+			}
 		}
+		sent += len(batch)
+	}
+	_ = out.close("}")
+}
+
+func writeJSON(w http.ResponseWriter, status int, payload any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(payload)
+}
+
+func pathID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.Error(w, "id must be a positive integer", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+func decodeProduct(w http.ResponseWriter, r *http.Request) (Product, bool) {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	var p Product
+	if err := dec.Decode(&p); err != nil {
+		http.Error(w, "body must be a JSON product", http.StatusBadRequest)
+		return Product{}, false
+	}
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return Product{}, false
+	}
+	if p.ID < 0 {
+		http.Error(w, "id must be a positive integer", http.StatusBadRequest)
+		return Product{}, false
 	}
+	return p, true
 }
 
 // ---------- Downstream simulation ----------
@@ -76,13 +243,27 @@ func downstreamHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // ---------- Search ----------
+// ProductPage is the body of GET /products. Products is last so the
+// listing can be streamed after the fields known up front.
+type ProductPage struct {
+	Offset   int       `json:"offset"`
+	Limit    int       `json:"limit"`
+	Total    int       `json:"total"`
+	Products []Product `json:"products"`
+}
+
 type SearchResponse struct {
-	Products   []Product `json:"products"`
-	TotalFound int       `json:"total_found"`
-	Checked    int       `json:"checked"`
-	SearchTime string    `json:"search_time,omitempty"`
-	Downstream string    `json:"downstream,omitempty"`
-	Mode       string    `json:"mode,omitempty"`
+	Products       []Product       `json:"products"`
+	TotalFound     int             `json:"total_found"`
+	Checked        int             `json:"checked"`
+	SearchTime     string          `json:"search_time,omitempty"`
+	Downstream     string          `json:"downstream,omitempty"`
+	DownstreamData json.RawMessage `json:"downstream_data,omitempty"`
+	Attempts       int             `json:"downstream_attempts,omitempty"` // FIXED mode: downstream calls incl. retries
+	Partial        bool            `json:"partial,omitempty"`             // request budget ran out; sent with a 504
+	Degraded       bool            `json:"degraded"`                      // downstream data came from a fallback, or Partial
+	Fallback       string          `json:"fallback,omitempty"`            // which fallback: stale, default, ...
+	Mode           string          `json:"mode,omitempty"`
 }
 
 var activeRequests int32
@@ -103,10 +284,18 @@ func searchHandler_BAD(w http.ResponseWriter, r *http.Request) {
 
 	// BAD: Call downstream with no timeout and no concurrency limit.
 	// Under load, goroutines pile up waiting on downstream => latency/CPU spikes.
+	// The calls do stop when the client goes away or sends a deadline header.
 	dsURL := "http://127.0.0.1:8080/downstream?mode=slow"
-	resp, err := http.Get(dsURL)
+	get := func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, dsURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		return http.DefaultClient.Do(req)
+	}
+	resp, err := get()
 	for i := 0; i < 9; i++ { // fan-out 10x per request (simulates dependency amplification)
-		resp, err := http.Get(dsURL)
+		resp, err := get()
 		if err == nil && resp != nil {
 			_ = resp.Body.Close()
 		}
@@ -122,33 +311,12 @@ func searchHandler_BAD(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Requirement: always check exactly 100 products
-	checked := 0
-	results := make([]Product, 0, 20)
-	totalFound := 0
-
-	for i := 0; i < len(products) && checked < 100; i++ {
-		checked++
-		p := products[i]
-		if q == "" {
-			continue
-		}
-		if strings.Contains(strings.ToLower(p.Name), q) || strings.Contains(strings.ToLower(p.Category), q) {
-			totalFound++
-			if len(results) < 20 {
-				results = append(results, p)
-			}
-		}
-	}
-
-	out := SearchResponse{
-		Products:   results,
-		TotalFound: totalFound,
-		Checked:    checked,
-		SearchTime: time.Since(start).String(),
-		Downstream: downstreamStatus,
-		Mode:       "bad_no_timeout_no_bulkhead",
-	}
+	out, how := searchCatalog(q)
+	out.SearchTime = time.Since(start).String()
+	out.Downstream = downstreamStatus
+	out.Mode = "bad_no_timeout_no_bulkhead"
 
+	w.Header().Set("X-Cache", string(how))
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(out)
 }
@@ -159,171 +327,556 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // ---------- Bulkhead (limit concurrent downstream work) ----------
-var bulkhead = make(chan struct{}, 30) // allow at most 30 concurrent downstream calls
+// The downstream limiter replaces the fixed 30-slot channel: the number of
+// concurrent downstream calls adapts to observed latency. It lives in the
+// runtime settings (see settings.go) so /admin/config can resize it; main
+// starts it from the LIMITER_* env vars.
+
+// limiterSettingsFromEnv reads LIMITER_ALGORITHM (aimd | gradient, default
+// aimd), LIMITER_INITIAL (30), LIMITER_MIN (1), LIMITER_MAX (200),
+// LIMITER_SLOW_RTT (aimd only, 100ms), LIMITER_QUEUE (0 = reject at once)
+// and LIMITER_QUEUE_WAIT (10ms).
+func limiterSettingsFromEnv() (limiterSettings, error) {
+	s := defaultRuntimeSettings().Limiter
+
+	var err error
+	intVar := func(name string, dst *int) {
+		if v := os.Getenv(name); v != "" && err == nil {
+			if *dst, err = strconv.Atoi(v); err != nil || *dst < 0 {
+				err = fmt.Errorf("%s must be a non-negative integer, got %q", name, v)
+			}
+		}
+	}
+	durVar := func(name string, dst *config.Duration) {
+		if v := os.Getenv(name); v != "" && err == nil {
+			d, perr := time.ParseDuration(v)
+			if perr != nil {
+				err = fmt.Errorf("%s must be a duration, got %q", name, v)
+			}
+			*dst = config.Duration(d)
+		}
+	}
+	intVar("LIMITER_INITIAL", &s.Initial)
+	intVar("LIMITER_MIN", &s.Min)
+	intVar("LIMITER_MAX", &s.Max)
+	intVar("LIMITER_QUEUE", &s.Queue)
+	durVar("LIMITER_SLOW_RTT", &s.SlowRTT)
+	durVar("LIMITER_QUEUE_WAIT", &s.QueueWait)
+	if v := os.Getenv("LIMITER_ALGORITHM"); v != "" {
+		s.Algorithm = strings.ToLower(v)
+	}
+	if err == nil {
+		err = s.validate()
+	}
+	return s, err
+}
 
-// ---------- Simple Circuit Breaker ----------
-type CircuitBreaker struct {
-	mu           sync.Mutex
-	state        string // "closed" | "open" | "half_open"
-	failCount    int
-	successCount int
-	openUntil    time.Time
+func limiterStatsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, liveRuntime().limiter.Snapshot())
 }
 
-func NewBreaker() *CircuitBreaker {
-	return &CircuitBreaker{state: "closed"}
+// ---------- Circuit Breaker ----------
+// The downstream breaker guards /downstream calls. Like the limiter it is
+// part of the runtime settings; main starts it from the BREAKER_* env vars.
+
+// breakerSettingsFromEnv reads the breaker settings; unset vars keep the
+// defaults (10 consecutive failures, 10s open, 5 successes to close, 1
+// half-open probe).
+func breakerSettingsFromEnv() (breakerSettings, error) {
+	s := defaultRuntimeSettings().Breaker
+
+	var err error
+	intVar := func(name string, dst *int) {
+		if v := os.Getenv(name); v != "" && err == nil {
+			if *dst, err = strconv.Atoi(v); err != nil {
+				err = fmt.Errorf("%s must be an integer, got %q", name, v)
+			}
+		}
+	}
+	durVar := func(name string, dst *config.Duration) {
+		if v := os.Getenv(name); v != "" && err == nil {
+			d, perr := time.ParseDuration(v)
+			if perr != nil {
+				err = fmt.Errorf("%s must be a duration, got %q", name, v)
+			}
+			*dst = config.Duration(d)
+		}
+	}
+	intVar("BREAKER_FAILURES", &s.ConsecutiveFailures)
+	intVar("BREAKER_MIN_REQUESTS", &s.MinRequests)
+	intVar("BREAKER_HALF_OPEN_PROBES", &s.HalfOpenProbes)
+	intVar("BREAKER_SUCCESSES_TO_CLOSE", &s.SuccessesToClose)
+	durVar("BREAKER_WINDOW", &s.Window)
+	durVar("BREAKER_OPEN_TIMEOUT", &s.OpenTimeout)
+	if v := os.Getenv("BREAKER_FAILURE_RATE"); v != "" && err == nil {
+		if s.FailureRate, err = strconv.ParseFloat(v, 64); err != nil {
+			err = fmt.Errorf("BREAKER_FAILURE_RATE must be between 0 and 1, got %q", v)
+		}
+	}
+	if err == nil {
+		err = s.validate()
+	}
+	return s, err
 }
 
-func (cb *CircuitBreaker) Allow() bool {
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
+func breakerStatsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, liveRuntime().breaker.Snapshot())
+}
 
-	if cb.state == "open" {
-		if time.Now().After(cb.openUntil) {
-			cb.state = "half_open"
-			cb.successCount = 0
-			return true
+// ---------- Retries ----------
+// downstreamRetry retries 429/503 answers with jittered backoff; its budget
+// keeps retries to a fraction of downstream calls. main configures it from
+// the RETRY_* env vars (see retryPolicyFromEnv).
+var downstreamRetry = retry.Policy{Budget: retry.NewBudget(-1, 0)}
+
+// retryPolicyFromEnv reads RETRY_MAX_ATTEMPTS (3, 1 disables retries),
+// RETRY_BASE_DELAY (10ms), RETRY_MAX_DELAY (100ms), RETRY_MAX_RETRY_AFTER
+// (1s), RETRY_BUDGET_RATIO (0.1 = retries may add at most 10% to downstream
+// traffic; 0 disables retries) and RETRY_BUDGET_MAX (10 tokens of burst).
+func retryPolicyFromEnv() (retry.Policy, error) {
+	var p retry.Policy
+	ratio, maxTokens := -1.0, 0.0 // -1: unset, NewBudget's default
+
+	var err error
+	floatVar := func(name string, dst *float64) {
+		if v := os.Getenv(name); v != "" && err == nil {
+			if *dst, err = strconv.ParseFloat(v, 64); err != nil || *dst < 0 {
+				err = fmt.Errorf("%s must be a non-negative number, got %q", name, v)
+			}
+		}
+	}
+	durVar := func(name string, dst *time.Duration) {
+		if v := os.Getenv(name); v != "" && err == nil {
+			if *dst, err = time.ParseDuration(v); err != nil {
+				err = fmt.Errorf("%s must be a duration, got %q", name, v)
+			}
+		}
+	}
+	if v := os.Getenv("RETRY_MAX_ATTEMPTS"); v != "" {
+		if p.MaxAttempts, err = strconv.Atoi(v); err != nil || p.MaxAttempts < 1 {
+			err = fmt.Errorf("RETRY_MAX_ATTEMPTS must be a positive integer, got %q", v)
 		}
-		return false
 	}
-	return true
+	durVar("RETRY_BASE_DELAY", &p.BaseDelay)
+	durVar("RETRY_MAX_DELAY", &p.MaxDelay)
+	durVar("RETRY_MAX_RETRY_AFTER", &p.MaxRetryAfter)
+	floatVar("RETRY_BUDGET_RATIO", &ratio)
+	floatVar("RETRY_BUDGET_MAX", &maxTokens)
+	p.Budget = retry.NewBudget(ratio, maxTokens)
+	return p, err
 }
 
-func (cb *CircuitBreaker) OnSuccess() {
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
+func retryStatsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, downstreamRetry.Budget.Snapshot())
+}
 
-	if cb.state == "half_open" {
-		cb.successCount++
-		if cb.successCount >= 5 {
-			cb.state = "closed"
-			cb.failCount = 0
-		}
+// downstreamURL is what the FIXED handler calls. DOWNSTREAM_MODE picks the
+// simulated behaviour (slow, fail or normal; default slow).
+func downstreamURL() string {
+	mode := os.Getenv("DOWNSTREAM_MODE")
+	if mode == "" {
+		mode = "slow"
+	}
+	return "http://127.0.0.1:8080/downstream?mode=" + mode
+}
+
+// ---------- Fallbacks ----------
+// downstreamDep serves the last good downstream answer (or a default) when a
+// call fails. main configures it from FALLBACK_MAX_STALE.
+var downstreamDep = newDownstreamDep(0)
+
+// downstreamDefault is what clients get when downstream is down and there's
+// no recent enough answer to reuse.
+var downstreamDefault = json.RawMessage(`{"ok":false}`)
+
+func newDownstreamDep(maxStale time.Duration) *fallback.Dependency[json.RawMessage] {
+	return fallback.New("downstream", fallback.Config[json.RawMessage]{
+		MaxStale: maxStale,
+		Fallbacks: []fallback.Named[json.RawMessage]{
+			{Name: "default", Fn: func(context.Context, error) (json.RawMessage, error) {
+				return downstreamDefault, nil
+			}},
+		},
+	})
+}
+
+// fallbackMaxStaleFromEnv reads FALLBACK_MAX_STALE: how old a last known good
+// downstream answer may be and still be served (default 5m, 0 = never).
+func fallbackMaxStaleFromEnv() (time.Duration, error) {
+	v := os.Getenv("FALLBACK_MAX_STALE")
+	if v == "" {
+		return 5 * time.Minute, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("FALLBACK_MAX_STALE must be a non-negative duration, got %q", v)
+	}
+	return d, nil
+}
+
+// markDegraded tells the client that dep's part of the answer is not live:
+// a Warning header (110 for stale data, 199 otherwise) and X-Degraded, one
+// per degraded dependency.
+func markDegraded(w http.ResponseWriter, dep string, src fallback.Source, age time.Duration) {
+	h := w.Header()
+	if src == fallback.Stale {
+		h.Add("Warning", `110 - "Response is Stale"`)
+		h.Add("X-Degraded", fmt.Sprintf("%s=stale; age=%d", dep, int(age.Seconds())))
 		return
 	}
-	cb.failCount = 0
+	h.Add("Warning", fmt.Sprintf(`199 - "%s unavailable, served %s"`, dep, src))
+	h.Add("X-Degraded", dep+"="+string(src))
+}
+
+func fallbackStatsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, downstreamDep.Snapshot())
 }
 
-func (cb *CircuitBreaker) OnFailure() {
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
+// ---------- Hedging ----------
+// downstreamHedger, when set, sends a second downstream request if the first
+// is slower than the observed HEDGE_PERCENTILE latency. nil disables hedging;
+// main configures it from the HEDGE_* env vars (see hedgerFromEnv).
+var downstreamHedger *hedge.Hedger
+
+// hedgerFromEnv reads HEDGE_PERCENTILE (unset or 0 = off, e.g. 0.95),
+// HEDGE_INITIAL_DELAY (100ms, used until 20 latencies are seen),
+// HEDGE_MIN_DELAY (1ms), HEDGE_BUDGET_RATIO (0.1 = at most ~10% of calls
+// hedged) and HEDGE_BUDGET_MAX (10).
+func hedgerFromEnv() (*hedge.Hedger, error) {
+	var cfg hedge.Config
+	ratio, maxTokens := -1.0, 0.0 // -1: unset, NewBudget's default
+
+	var err error
+	floatVar := func(name string, dst *float64) {
+		if v := os.Getenv(name); v != "" && err == nil {
+			if *dst, err = strconv.ParseFloat(v, 64); err != nil || *dst < 0 {
+				err = fmt.Errorf("%s must be a non-negative number, got %q", name, v)
+			}
+		}
+	}
+	durVar := func(name string, dst *time.Duration) {
+		if v := os.Getenv(name); v != "" && err == nil {
+			if *dst, err = time.ParseDuration(v); err != nil {
+				err = fmt.Errorf("%s must be a duration, got %q", name, v)
+			}
+		}
+	}
+	floatVar("HEDGE_PERCENTILE", &cfg.Percentile)
+	durVar("HEDGE_INITIAL_DELAY", &cfg.InitialDelay)
+	durVar("HEDGE_MIN_DELAY", &cfg.MinDelay)
+	floatVar("HEDGE_BUDGET_RATIO", &ratio)
+	floatVar("HEDGE_BUDGET_MAX", &maxTokens)
+	if err == nil && cfg.Percentile > 1 {
+		err = fmt.Errorf("HEDGE_PERCENTILE must be between 0 and 1, got %v", cfg.Percentile)
+	}
+	if err != nil || cfg.Percentile == 0 {
+		return nil, err
+	}
+	cfg.Budget = retry.NewBudget(ratio, maxTokens)
+	return hedge.New(cfg), nil
+}
+
+func hedgeStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if downstreamHedger == nil {
+		writeJSON(w, http.StatusOK, map[string]bool{"enabled": false})
+		return
+	}
+	writeJSON(w, http.StatusOK, downstreamHedger.Snapshot())
+}
 
-	cb.failCount++
-	// Open breaker after 10 failures
-	if cb.failCount >= 10 && cb.state != "open" {
-		cb.state = "open"
-		cb.openUntil = time.Now().Add(10 * time.Second) // cool down
+// callDownstreamWithProtections makes up to downstreamRetry.MaxAttempts
+// protected calls and returns the last call's status and how many were made.
+// Every attempt (and every hedge) goes through the breaker and limiter on
+// its own, so they are counted (and refused) like any other call. ctx is the
+// incoming request's: no call outlives its deadline or the client.
+func callDownstreamWithProtections(ctx context.Context) (downstreamReply, int, error) {
+	if err := ctx.Err(); err != nil {
+		return downstreamReply{status: contextStatus(err)}, 0, err
+	}
+	var reply downstreamReply
+	attempts, err := downstreamRetry.Do(ctx, func(int) error {
+		var hedged bool
+		var err error
+		reply, hedged, err = hedge.Do(ctx, downstreamHedger, callDownstreamOnce)
+		if hedged {
+			reply.status += "_hedged"
+		}
+		return err
+	})
+	if errors.Is(err, retry.ErrBudgetExhausted) {
+		reply.status += "_retry_budget_exhausted"
 	}
+	return reply, attempts, err
 }
 
-var breaker = NewBreaker()
+// downstreamReply is one downstream call's outcome: a short status for the
+// response's downstream field and, on success, the body it sent.
+type downstreamReply struct {
+	status string
+	body   json.RawMessage
+}
 
-func callDownstreamWithProtections() (string, error) {
-	// Fail fast if breaker is open
-	if !breaker.Allow() {
-		return "breaker_open", fmt.Errorf("circuit breaker open")
+// contextStatus names why a request's context ended.
+func contextStatus(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "deadline_exceeded"
 	}
+	return "cancelled"
+}
 
-	// Bulkhead: limit concurrent downstream calls
-	select {
-	case bulkhead <- struct{}{}:
-		defer func() { <-bulkhead }()
-	default:
-		return "bulkhead_reject", fmt.Errorf("bulkhead full")
+// callDownstreamOnce makes one protected call. The downstream timeout
+// (120ms unless changed) is cut short by ctx's deadline if less than that
+// is left. A call stopped through
+// ctx (a hedge's loser, a spent budget, a client that hung up) is released
+// without counting for or against downstream.
+func callDownstreamOnce(ctx context.Context) (downstreamReply, error) {
+	rt := liveRuntime()
+
+	// Fail fast if breaker is open (or half-open with every probe slot taken)
+	permit, err := rt.breaker.Allow()
+	if errors.Is(err, breaker.ErrTooManyProbes) {
+		return downstreamReply{status: "breaker_half_open"}, err
+	}
+	if err != nil {
+		return downstreamReply{status: "breaker_open"}, err
+	}
+
+	// Bulkhead: adaptive limit on concurrent downstream calls (may queue
+	// briefly if LIMITER_QUEUE is set)
+	token, err := rt.limiter.Acquire(ctx)
+	if err != nil {
+		permit.Ignore() // never reached downstream; says nothing about its health
+		return downstreamReply{status: "bulkhead_reject"}, err
 	}
 
 	// Fail fast timeout
-	client := &http.Client{Timeout: 120 * time.Millisecond}
-	resp, err := client.Get("http://127.0.0.1:8080/downstream?mode=slow")
+	client := &http.Client{Timeout: time.Duration(rt.settings.DownstreamTimeout)}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, downstreamURL(), nil)
 	if err != nil {
-		breaker.OnFailure()
-		return "timeout_or_net_error", err
+		token.Ignore()
+		permit.Ignore()
+		return downstreamReply{status: "bad_request"}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil && ctx.Err() != nil {
+		token.Ignore()
+		permit.Ignore()
+		return downstreamReply{status: contextStatus(ctx.Err())}, err
+	}
+	if err != nil {
+		token.Dropped()
+		permit.Failure()
+		return downstreamReply{status: "timeout_or_net_error"}, err
 	}
 	defer resp.Body.Close()
 
+	// The body counts as part of the call: a read that times out is a
+	// failed call like any other.
+	var payload []byte
+	if resp.StatusCode < 300 {
+		if payload, err = io.ReadAll(io.LimitReader(resp.Body, 64<<10)); err != nil {
+			token.Dropped()
+			permit.Failure()
+			return downstreamReply{status: "timeout_or_net_error"}, err
+		}
+	}
+
+	// 429/503 mean downstream is shedding load; other statuses still give a
+	// usable latency sample.
+	if retry.RetryableStatus(resp.StatusCode) {
+		token.Dropped()
+	} else {
+		token.Success()
+	}
 	if resp.StatusCode >= 500 || resp.StatusCode == 429 || resp.StatusCode == 503 {
-		breaker.OnFailure()
-		return "error_" + strconv.Itoa(resp.StatusCode), fmt.Errorf("downstream status %d", resp.StatusCode)
+		permit.Failure()
+		reply := downstreamReply{status: "error_" + strconv.Itoa(resp.StatusCode)}
+		err := fmt.Errorf("downstream status %d", resp.StatusCode)
+		if retry.RetryableStatus(resp.StatusCode) {
+			return reply, retry.Retryable(err, retry.RetryAfter(resp.Header, time.Now()))
+		}
+		return reply, err
 	}
 
-	breaker.OnSuccess()
-	return "ok", nil
+	permit.Success()
+	return downstreamReply{status: "ok", body: payload}, nil
 }
 
 func searchHandler_FIXED(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	q := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q")))
 
-	downstreamStatus, _ := callDownstreamWithProtections()
+	var (
+		reply    downstreamReply
+		attempts int
+	)
+	res := downstreamDep.Do(r.Context(), func(ctx context.Context) (json.RawMessage, error) {
+		var err error
+		reply, attempts, err = callDownstreamWithProtections(ctx)
+		return reply.body, err
+	})
 	// Even if downstream fails, we still respond quickly (graceful degradation)
 
-	checked := 0
-	results := make([]Product, 0, 20)
-	totalFound := 0
-
-	for i := 0; i < len(products) && checked < 100; i++ {
-		checked++
-		p := products[i]
-		if q == "" {
-			continue
-		}
-		if strings.Contains(strings.ToLower(p.Name), q) || strings.Contains(strings.ToLower(p.Category), q) {
-			totalFound++
-			if len(results) < 20 {
-				results = append(results, p)
-			}
-		}
+	out, how := searchCatalog(q)
+	out.SearchTime = time.Since(start).String()
+	out.Downstream = reply.status
+	out.DownstreamData = res.Value
+	out.Attempts = attempts
+	out.Mode = "fixed_bulkhead_cb_failfast"
+	if res.Degraded() {
+		out.Degraded = true
+		out.Fallback = string(res.Source)
+		markDegraded(w, downstreamDep.Name(), res.Source, res.Age)
 	}
 
-	out := SearchResponse{
-		Products:   results,
-		TotalFound: totalFound,
-		Checked:    checked,
-		SearchTime: time.Since(start).String(),
-		Downstream: downstreamStatus,
-		Mode:       "fixed_bulkhead_cb_failfast",
+	status := http.StatusOK
+	switch err := r.Context().Err(); {
+	case errors.Is(err, context.DeadlineExceeded):
+		// Out of budget: say so, but hand back what we have.
+		status = http.StatusGatewayTimeout
+		out.Partial = true
+		out.Degraded = true
+	case err != nil:
+		return // client is gone; nobody to answer
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(out)
+	w.Header().Set("X-Cache", string(how))
+	writeJSON(w, status, out)
 }
 
+// versionHandler reports the build and the live configuration: search
+// mode, feature flags and the runtime settings, as /admin/config shows them.
 func versionHandler(w http.ResponseWriter, r *http.Request) {
-	mode := os.Getenv("MODE")
-	if mode == "" {
-		mode = "bad"
-	}
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(map[string]string{
-		"mode":  mode,
-		"build": "step3-demo-v1",
-	})
+	writeJSON(w, http.StatusOK, struct {
+		Build string `json:"build"`
+		configView
+	}{"step3-demo-v1", currentConfig()})
 }
 
 func main() {
 	rand.Seed(time.Now().UnixNano())
-	generateProducts(100000)
+
+	cfg, err := datasetConfigFromEnv()
+	if err != nil {
+		log.Fatalf("dataset config: %v", err)
+	}
+	loader, err := cfg.loader()
+	if err != nil {
+		log.Fatalf("dataset config: %v", err)
+	}
+	stats, err := loadProducts(catalog, loader)
+	if err != nil {
+		log.Fatalf("load products: %v", err)
+	}
+	log.Printf("loaded %d products from %s in %s (heap +%.1f MiB, %.1f MiB live)",
+		stats.Products, loader, stats.Duration,
+		float64(stats.HeapDelta)/(1<<20), float64(stats.HeapTotal)/(1<<20))
+
+	resultCache, err = searchCacheFromEnv()
+	if err != nil {
+		log.Fatalf("search cache config: %v", err)
+	}
+	catalog.onWrite = resultCache.invalidate
+
+	settings := defaultRuntimeSettings()
+	if settings.Breaker, err = breakerSettingsFromEnv(); err != nil {
+		log.Fatalf("breaker config: %v", err)
+	}
+	if settings.Limiter, err = limiterSettingsFromEnv(); err != nil {
+		log.Fatalf("limiter config: %v", err)
+	}
+	if settings.DownstreamTimeout, err = downstreamTimeoutFromEnv(); err != nil {
+		log.Fatalf("downstream timeout config: %v", err)
+	}
+	if settings.RequestTimeout, err = requestTimeoutFromEnv(); err != nil {
+		log.Fatalf("request timeout config: %v", err)
+	}
+	if err := setRuntimeSettings(settings); err != nil {
+		log.Fatalf("runtime settings: %v", err)
+	}
+
+	if err := flagsFromEnv(featureFlags); err != nil {
+		log.Fatalf("feature flags config: %v", err)
+	}
+
+	downstreamRetry, err = retryPolicyFromEnv()
+	if err != nil {
+		log.Fatalf("retry config: %v", err)
+	}
+
+	downstreamHedger, err = hedgerFromEnv()
+	if err != nil {
+		log.Fatalf("hedge config: %v", err)
+	}
+
+	maxStale, err := fallbackMaxStaleFromEnv()
+	if err != nil {
+		log.Fatalf("fallback config: %v", err)
+	}
+	downstreamDep = newDownstreamDep(maxStale)
+
+	admissionControl, shedRetryAfter, err = admissionFromEnv()
+	if err != nil {
+		log.Fatalf("load shedding config: %v", err)
+	}
+
+	rateRules, err := rateLimitRulesFromEnv()
+	if err != nil {
+		log.Fatalf("rate limit config: %v", err)
+	}
+	trustedProxies, err = trustedProxiesFromEnv()
+	if err != nil {
+		log.Fatalf("rate limit config: %v", err)
+	}
+	rateBuckets := ratelimit.NewMemory()
+	rateService, err := rateLimitServiceFromEnv(rateBuckets)
+	if err != nil {
+		log.Fatalf("rate limit config: %v", err)
+	}
+
+	if err := faultRulesFromEnv(faultInjector); err != nil {
+		log.Fatalf("fault injection config: %v", err)
+	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/health", healthHandler)
 	mux.HandleFunc("/downstream", downstreamHandler)
 	mux.HandleFunc("/version", versionHandler)
-	mode := strings.ToLower(strings.TrimSpace(os.Getenv("MODE"))) // "bad" or "fixed"
-	if mode == "" {
-		mode = "bad"
-	}
-
-	if mode == "fixed" {
-		log.Println("MODE=fixed: using searchHandler_FIXED")
-		mux.HandleFunc("/products/search", searchHandler_FIXED)
-	} else {
-		log.Println("MODE=bad: using searchHandler_BAD")
-		mux.HandleFunc("/products/search", searchHandler_BAD)
+	mux.HandleFunc("GET /debug/cache", cacheStatsHandler)
+	mux.HandleFunc("GET /debug/breaker", breakerStatsHandler)
+	mux.HandleFunc("GET /debug/limiter", limiterStatsHandler)
+	mux.HandleFunc("GET /debug/retry", retryStatsHandler)
+	mux.HandleFunc("GET /debug/hedge", hedgeStatsHandler)
+	mux.HandleFunc("GET /debug/fallback", fallbackStatsHandler)
+	mux.HandleFunc("GET /debug/admission", admissionStatsHandler)
+	mux.Handle("/admin/faults", withAdminToken(faultInjector.AdminHandler()))
+	mux.Handle("/admin/config", withAdminToken(http.HandlerFunc(configAdminHandler)))
+	if rateService != nil {
+		// This instance is the shared rate-limit service for the others.
+		mux.Handle("POST /internal/ratelimit", rateService)
 	}
+	mux.HandleFunc("GET /products", listProductsHandler)
+	mux.HandleFunc("GET /products/{id}", getProductHandler)
+	mux.HandleFunc("POST /products", createProductHandler)
+	mux.HandleFunc("PUT /products/{id}", updateProductHandler)
+	mux.HandleFunc("DELETE /products/{id}", deleteProductHandler)
+	// BAD or FIXED is picked per request from the search_fixed flag (MODE
+	// sets where it starts), so the two can be compared without a redeploy.
+	log.Printf("search mode %s (search_fixed at %g%%)", searchMode(), featureFlags.Percent(searchFixedFlag))
+	mux.HandleFunc("GET /products/search", searchHandler)
+
+	// Wrapped inside out: a request meets its deadline first, then any
+	// injected faults (so injected latency spends the budget), the
+	// per-client rate limit, load shedding and compression.
+	var handler http.Handler = withCompression(mux, compressMinBytes)
+	handler = withAdmission(handler, admissionControl, shedRetryAfter)
+	handler = withRateLimit(handler, rateRules, rateLimitBackendFromEnv(rateBuckets))
+	handler = faultInjector.Middleware(handler)
+	handler = withDeadline(handler)
 
 	srv := &http.Server{
 		Addr:    ":8080",
-		Handler: mux,
+		Handler: handler,
 	}
 
 	log.Println("listening on :8080")
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"text/main/ratelimit"
+)
+
+// rateLimitRule limits the requests matching Route to Rate per second per
+// client, with bursts up to Burst.
+type rateLimitRule struct {
+	// Route is "METHOD /path", "/path" (any method) or "*" (everything).
+	// A path ending in "/" matches everything under it.
+	Route string  `json:"route"`
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"` // default: Rate rounded up
+	// Key picks the client: "ip" (default; see clientIP), "api_key" (X-API-Key) or "header:<Name>". Requests without
+	// the header are keyed by IP.
+	Key string `json:"key"`
+
+	method, path string
+	prefix       bool
+}
+
+func (rl *rateLimitRule) compile() error {
+	if !(rl.Rate > 0) {
+		return fmt.Errorf("route %q: rate must be positive", rl.Route)
+	}
+	if rl.Burst <= 0 {
+		rl.Burst = int(math.Ceil(rl.Rate))
+	}
+	switch {
+	case rl.Key == "", rl.Key == "ip", rl.Key == "api_key":
+	case strings.HasPrefix(rl.Key, "header:") && len(rl.Key) > len("header:"):
+	default:
+		return fmt.Errorf("route %q: key must be ip, api_key or header:<Name>, got %q", rl.Route, rl.Key)
+	}
+
+	route := strings.TrimSpace(rl.Route)
+	if route == "*" {
+		rl.path, rl.prefix = "/", true
+		return nil
+	}
+	if m, p, ok := strings.Cut(route, " "); ok {
+		rl.method, route = m, strings.TrimSpace(p)
+	}
+	if !strings.HasPrefix(route, "/") {
+		return fmt.Errorf("route %q: path must start with /", rl.Route)
+	}
+	rl.path, rl.prefix = route, strings.HasSuffix(route, "/")
+	return nil
+}
+
+func (rl *rateLimitRule) matches(r *http.Request) bool {
+	if rl.method != "" && rl.method != r.Method {
+		return false
+	}
+	if rl.prefix {
+		return strings.HasPrefix(r.URL.Path, rl.path)
+	}
+	return r.URL.Path == rl.path
+}
+
+func (rl *rateLimitRule) clientKey(r *http.Request) string {
+	switch {
+	case rl.Key == "api_key":
+		if v := r.Header.Get("X-API-Key"); v != "" {
+			return "key:" + v
+		}
+	case strings.HasPrefix(rl.Key, "header:"):
+		if v := r.Header.Get(strings.TrimPrefix(rl.Key, "header:")); v != "" {
+			return "hdr:" + v
+		}
+	}
+	return "ip:" + clientIP(r)
+}
+
+// trustedProxies is how many proxies in front of the service append to
+// X-Forwarded-For: 1, the ALB, unless TRUSTED_PROXIES says otherwise.
+var trustedProxies = 1
+
+// clientIP is the address the outermost trusted proxy saw: the
+// X-Forwarded-For hop trustedProxies from the right. Hops left of it come
+// from the client, which can write anything there. With fewer hops than
+// that, or no trusted proxies, it is the connection's address.
+func clientIP(r *http.Request) string {
+	if n := trustedProxies; n > 0 {
+		var hops []string
+		for _, v := range r.Header.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(v, ",")...)
+		}
+		if len(hops) >= n {
+			if ip := strings.TrimSpace(hops[len(hops)-n]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimitRulesFromEnv reads RATE_LIMITS, a JSON list of rules, e.g.
+//
+//	[{"route":"GET /products/search","rate":50,"burst":100},
+//	 {"route":"POST /products","rate":5,"key":"api_key"}]
+//
+// Unset means no rate limiting.
+func rateLimitRulesFromEnv() ([]rateLimitRule, error) {
+	v := os.Getenv("RATE_LIMITS")
+	if v == "" {
+		return nil, nil
+	}
+	var rules []rateLimitRule
+	if err := json.Unmarshal([]byte(v), &rules); err != nil {
+		return nil, fmt.Errorf("RATE_LIMITS must be a JSON list of rules: %v", err)
+	}
+	for i := range rules {
+		if err := rules[i].compile(); err != nil {
+			return nil, fmt.Errorf("RATE_LIMITS: %v", err)
+		}
+	}
+	return rules, nil
+}
+
+// trustedProxiesFromEnv reads TRUSTED_PROXIES, the number of proxies that
+// append to X-Forwarded-For in front of the service (default 1, the ALB;
+// 0 ignores the header).
+func trustedProxiesFromEnv() (int, error) {
+	v := os.Getenv("TRUSTED_PROXIES")
+	if v == "" {
+		return 1, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("TRUSTED_PROXIES must be a non-negative integer, got %q", v)
+	}
+	return n, nil
+}
+
+// rateLimitBackendFromEnv reads RATE_LIMIT_BACKEND, the URL of a shared
+// rate-limit service (see RATE_LIMIT_SERVE), and RATE_LIMIT_TOKEN, the
+// secret it expects. Unset keeps buckets in this process. With a shared
+// backend that can't be reached, each replica falls back to limiting on its
+// own.
+func rateLimitBackendFromEnv(local *ratelimit.Memory) ratelimit.Backend {
+	if url := os.Getenv("RATE_LIMIT_BACKEND"); url != "" {
+		remote := &ratelimit.Remote{URL: url, Token: os.Getenv("RATE_LIMIT_TOKEN")}
+		return ratelimit.WithFallback(remote, local)
+	}
+	return local
+}
+
+// rateLimitServiceFromEnv returns the shared rate-limit service to mount at
+// /internal/ratelimit when RATE_LIMIT_SERVE=true, or nil. Any caller of it
+// can take from any client's bucket, so it refuses to run without
+// RATE_LIMIT_TOKEN, which every replica must send.
+func rateLimitServiceFromEnv(b ratelimit.Backend) (http.Handler, error) {
+	if os.Getenv("RATE_LIMIT_SERVE") != "true" {
+		return nil, nil
+	}
+	token := os.Getenv("RATE_LIMIT_TOKEN")
+	if token == "" {
+		return nil, fmt.Errorf("RATE_LIMIT_SERVE needs RATE_LIMIT_TOKEN, the secret the other replicas send")
+	}
+	h := ratelimit.Handler(b)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !hasBearerToken(r, token) {
+			http.Error(w, "rate limit token required", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}), nil
+}
+
+// withRateLimit applies the first matching rule to each request and answers
+// 429 once the client's bucket is empty. Limited responses carry
+// RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset (seconds), and
+// refused ones Retry-After.
+func withRateLimit(next http.Handler, rules []rateLimitRule, backend ratelimit.Backend) http.Handler {
+	if len(rules) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if infrastructurePath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for i := range rules {
+			rl := &rules[i]
+			if !rl.matches(r) {
+				continue
+			}
+			key := strconv.Itoa(i) + "|" + rl.clientKey(r)
+			d, err := backend.Take(r.Context(), key, ratelimit.Limit{Rate: rl.Rate, Burst: rl.Burst})
+			if err != nil {
+				log.Printf("rate limit %s: %v", rl.Route, err)
+				break // fail open
+			}
+			h := w.Header()
+			h.Set("RateLimit-Limit", strconv.Itoa(d.Limit))
+			h.Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+			h.Set("RateLimit-Reset", ceilSeconds(d.Reset))
+			if !d.Allowed {
+				h.Set("Retry-After", ceilSeconds(d.RetryAfter))
+				http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+			break
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
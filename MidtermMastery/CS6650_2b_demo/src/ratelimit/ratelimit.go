@@ -0,0 +1,135 @@
+// Package ratelimit is a keyed token-bucket rate limiter. Each key (a
+// client, usually scoped to one route) has a bucket of Burst tokens that
+// refills at Rate per second; a request takes one token or is refused.
+//
+// Buckets live in a Backend. Memory keeps them in this process; Remote asks
+// a shared service (any process serving Handler) so that several replicas
+// enforce one global limit.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit is a bucket's shape.
+type Limit struct {
+	Rate  float64 `json:"rate"`  // tokens added per second; must be > 0
+	Burst int     `json:"burst"` // bucket size, at least 1
+}
+
+var errBadRate = errors.New("ratelimit: rate must be positive")
+
+// Decision is the outcome of one Take.
+type Decision struct {
+	Allowed   bool `json:"allowed"`
+	Limit     int  `json:"limit"`     // the bucket size
+	Remaining int  `json:"remaining"` // whole tokens left after this request
+	// Reset is how long until the bucket is full again; RetryAfter, for a
+	// refused request, how long until the next token.
+	Reset      time.Duration `json:"reset"`
+	RetryAfter time.Duration `json:"retry_after"`
+}
+
+// Backend takes a token from key's bucket.
+type Backend interface {
+	Take(ctx context.Context, key string, l Limit) (Decision, error)
+}
+
+// Memory is an in-process Backend. Buckets that have refilled completely
+// are dropped now and then, so idle clients don't hold memory.
+type Memory struct {
+	now func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time // when the bucket will be full again
+}
+
+// sweepEvery is how often Take looks for full buckets to drop.
+const sweepEvery = time.Minute
+
+func NewMemory() *Memory { return newMemory(time.Now) }
+
+func newMemory(now func() time.Time) *Memory {
+	return &Memory{now: now, buckets: make(map[string]*bucket), lastSweep: now()}
+}
+
+func (m *Memory) Take(_ context.Context, key string, l Limit) (Decision, error) {
+	if !(l.Rate > 0) {
+		return Decision{}, errBadRate
+	}
+	burst := float64(max(l.Burst, 1))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	if now.Sub(m.lastSweep) >= sweepEvery {
+		m.sweep(now)
+	}
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		m.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	b.last = now
+
+	d := Decision{Limit: int(burst)}
+	if b.tokens >= 1 {
+		b.tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = seconds((1 - b.tokens) / l.Rate)
+	}
+	d.Remaining = int(b.tokens)
+	d.Reset = seconds((burst - b.tokens) / l.Rate)
+	b.full = now.Add(d.Reset)
+	return d, nil
+}
+
+func (m *Memory) sweep(now time.Time) {
+	m.lastSweep = now
+	for k, b := range m.buckets {
+		if !now.Before(b.full) {
+			delete(m.buckets, k)
+		}
+	}
+}
+
+// Len is the number of buckets held.
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.buckets)
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// WithFallback uses primary and, when it fails, fallback: with a shared
+// backend that is down, each replica keeps enforcing the limit locally.
+func WithFallback(primary, fallback Backend) Backend {
+	return fallbackBackend{primary, fallback}
+}
+
+type fallbackBackend struct{ primary, fallback Backend }
+
+func (f fallbackBackend) Take(ctx context.Context, key string, l Limit) (Decision, error) {
+	if d, err := f.primary.Take(ctx, key, l); err == nil {
+		return d, nil
+	}
+	return f.fallback.Take(ctx, key, l)
+}
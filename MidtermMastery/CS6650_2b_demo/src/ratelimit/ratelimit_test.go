@@ -0,0 +1,99 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) Now() time.Time { return c.t }
+
+func TestMemoryBucket(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	m := newMemory(clock.Now)
+	l := Limit{Rate: 2, Burst: 3}
+	ctx := context.Background()
+
+	for i := 2; i >= 0; i-- {
+		d, _ := m.Take(ctx, "a", l)
+		if !d.Allowed || d.Remaining != i || d.Limit != 3 {
+			t.Fatalf("take %d = %+v, want allowed with %d left", 3-i, d, i)
+		}
+	}
+	d, _ := m.Take(ctx, "a", l)
+	if d.Allowed || d.RetryAfter != 500*time.Millisecond || d.Reset != 1500*time.Millisecond {
+		t.Fatalf("over limit = %+v, want refused, retry in 500ms, full in 1.5s", d)
+	}
+	if d, _ := m.Take(ctx, "b", l); !d.Allowed {
+		t.Fatalf("other key = %+v, want allowed", d)
+	}
+
+	clock.t = clock.t.Add(500 * time.Millisecond) // one token back
+	if d, _ := m.Take(ctx, "a", l); !d.Allowed || d.Remaining != 0 {
+		t.Fatalf("after refill = %+v, want allowed with 0 left", d)
+	}
+}
+
+func TestMemoryDropsFullBuckets(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	m := newMemory(clock.Now)
+	for _, k := range []string{"a", "b", "c"} {
+		_, _ = m.Take(context.Background(), k, Limit{Rate: 10, Burst: 5})
+	}
+	clock.t = clock.t.Add(sweepEvery)
+	_, _ = m.Take(context.Background(), "d", Limit{Rate: 10, Burst: 5})
+	if n := m.Len(); n != 1 {
+		t.Fatalf("buckets after sweep = %d, want 1", n)
+	}
+}
+
+func TestMemoryRejectsZeroRate(t *testing.T) {
+	if _, err := NewMemory().Take(context.Background(), "a", Limit{Burst: 1}); err == nil {
+		t.Fatal("Take with rate 0 = nil error")
+	}
+}
+
+func TestRemoteSharesBuckets(t *testing.T) {
+	// The test server stands in for the shared service; two Remotes act as
+	// two replicas drawing from one bucket.
+	srv := httptest.NewServer(Handler(NewMemory()))
+	defer srv.Close()
+	a, b := &Remote{URL: srv.URL}, &Remote{URL: srv.URL}
+	l := Limit{Rate: 0.001, Burst: 2}
+	ctx := context.Background()
+
+	d1, err := a.Take(ctx, "client", l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d2, _ := b.Take(ctx, "client", l)
+	d3, _ := a.Take(ctx, "client", l)
+	if !d1.Allowed || !d2.Allowed || d3.Allowed {
+		t.Fatalf("decisions = %v %v %v, want two allowed then refused", d1.Allowed, d2.Allowed, d3.Allowed)
+	}
+}
+
+type failing struct{}
+
+func (failing) Take(context.Context, string, Limit) (Decision, error) {
+	return Decision{}, errors.New("unreachable")
+}
+
+func TestFallbackWhenSharedBackendDown(t *testing.T) {
+	b := WithFallback(failing{}, NewMemory())
+	d, err := b.Take(context.Background(), "a", Limit{Rate: 1, Burst: 1})
+	if err != nil || !d.Allowed {
+		t.Fatalf("Take = %+v, %v; want the local backend's answer", d, err)
+	}
+
+	srv := httptest.NewServer(Handler(NewMemory()))
+	srv.Close() // nothing listening any more
+	b = WithFallback(&Remote{URL: srv.URL}, NewMemory())
+	if d, err := b.Take(context.Background(), "a", Limit{Rate: 1, Burst: 1}); err != nil || !d.Allowed {
+		t.Fatalf("Take with shared service down = %+v, %v", d, err)
+	}
+}
@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// takeRequest is the body Remote sends and Handler reads.
+type takeRequest struct {
+	Key   string `json:"key"`
+	Limit Limit  `json:"limit"`
+}
+
+// Remote is a Backend that asks a shared rate-limit service, reached at URL
+// and served by Handler, so every replica draws from the same buckets.
+type Remote struct {
+	URL    string
+	Token  string       // sent as "Authorization: Bearer <Token>" when set
+	Client *http.Client // default: 50ms timeout
+}
+
+var defaultRemoteClient = &http.Client{Timeout: 50 * time.Millisecond}
+
+func (r *Remote) Take(ctx context.Context, key string, l Limit) (Decision, error) {
+	body, err := json.Marshal(takeRequest{Key: key, Limit: l})
+	if err != nil {
+		return Decision{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.URL, bytes.NewReader(body))
+	if err != nil {
+		return Decision{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.Token)
+	}
+
+	client := r.Client
+	if client == nil {
+		client = defaultRemoteClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Decision{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Decision{}, fmt.Errorf("rate limit service: status %d", resp.StatusCode)
+	}
+	var d Decision
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return Decision{}, fmt.Errorf("rate limit service: %w", err)
+	}
+	return d, nil
+}
+
+// Handler serves Remote's requests from b (normally a Memory). It trusts
+// every caller with any key and limit, so it must sit behind a check of
+// Remote's Token.
+func Handler(b Backend) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req takeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Key == "" {
+			http.Error(w, "body must be {\"key\": ..., \"limit\": {\"rate\": ..., \"burst\": ...}}", http.StatusBadRequest)
+			return
+		}
+		d, err := b.Take(r.Context(), req.Key, req.Limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(d)
+	})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"text/main/ratelimit"
+)
+
+func TestWithRateLimitPerRouteAndClient(t *testing.T) {
+	t.Setenv("RATE_LIMITS", `[{"route":"GET /products/search","rate":0.001,"burst":2},
+		{"route":"/products/","rate":0.001,"burst":1,"key":"api_key"}]`)
+	rules, err := rateLimitRulesFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	h := withRateLimit(ok, rules, ratelimit.NewMemory())
+
+	do := func(method, path, ip, apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = ip + ":1234"
+		if apiKey != "" {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i, wantRemaining := range []string{"1", "0"} {
+		rec := do("GET", "/products/search?q=a", "10.0.0.1", "")
+		if rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Remaining") != wantRemaining || rec.Header().Get("RateLimit-Limit") != "2" {
+			t.Fatalf("search %d = %d %v", i, rec.Code, rec.Header())
+		}
+	}
+	rec := do("GET", "/products/search?q=a", "10.0.0.1", "")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("third search = %d %v, want 429 with Retry-After", rec.Code, rec.Header())
+	}
+	if rec := do("GET", "/products/search?q=a", "10.0.0.2", ""); rec.Code != http.StatusOK {
+		t.Fatalf("other client = %d, want 200", rec.Code)
+	}
+
+	// The second rule keys on the API key, whatever the IP.
+	if rec := do("GET", "/products/7", "10.0.0.1", "k1"); rec.Code != http.StatusOK {
+		t.Fatalf("first /products/7 = %d", rec.Code)
+	}
+	if rec := do("PUT", "/products/7", "10.0.0.9", "k1"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("same key, other IP = %d, want 429", rec.Code)
+	}
+
+	// Unmatched routes and infrastructure endpoints aren't limited.
+	for i := 0; i < 3; i++ {
+		if rec := do("GET", "/health", "10.0.0.1", ""); rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Limit") != "" {
+			t.Fatalf("/health = %d %v", rec.Code, rec.Header())
+		}
+		if rec := do("GET", "/products", "10.0.0.1", ""); rec.Code != http.StatusOK {
+			t.Fatalf("/products = %d", rec.Code)
+		}
+	}
+}
+
+func TestRateLimitRulesValidation(t *testing.T) {
+	for _, v := range []string{
+		`{"route":"*"}`,
+		`[{"route":"*","rate":0}]`,
+		`[{"route":"products","rate":1}]`,
+		`[{"route":"*","rate":1,"key":"cookie"}]`,
+	} {
+		t.Setenv("RATE_LIMITS", v)
+		if _, err := rateLimitRulesFromEnv(); err == nil {
+			t.Fatalf("RATE_LIMITS=%s accepted", v)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.1.1.1:5555"
+	if got := clientIP(req); got != "10.1.1.1" {
+		t.Fatalf("clientIP = %q", got)
+	}
+	// The client wrote the first hop; the ALB appended the last.
+	req.Header.Set("X-Forwarded-For", "198.51.100.9, 203.0.113.7")
+	if got := clientIP(req); got != "203.0.113.7" {
+		t.Fatalf("clientIP behind ALB = %q", got)
+	}
+	req.Header.Add("X-Forwarded-For", "10.0.0.1")
+	defer func(n int) { trustedProxies = n }(trustedProxies)
+	trustedProxies = 2
+	if got := clientIP(req); got != "203.0.113.7" {
+		t.Fatalf("clientIP behind two proxies = %q", got)
+	}
+	trustedProxies = 4
+	if got := clientIP(req); got != "10.1.1.1" {
+		t.Fatalf("clientIP with fewer hops than proxies = %q", got)
+	}
+	trustedProxies = 0
+	if got := clientIP(req); got != "10.1.1.1" {
+		t.Fatalf("clientIP trusting no proxies = %q", got)
+	}
+}
+
+func TestRateLimitIgnoresSpoofedForwardedFor(t *testing.T) {
+	rules := []rateLimitRule{{Route: "*", Rate: 0.001, Burst: 2}}
+	if err := rules[0].compile(); err != nil {
+		t.Fatal(err)
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	h := withRateLimit(ok, rules, ratelimit.NewMemory())
+
+	// One client behind the ALB sends a new made-up first hop each time.
+	var codes []int
+	for _, spoof := range []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"} {
+		req := httptest.NewRequest(http.MethodGet, "/products/search?q=a", nil)
+		req.RemoteAddr = "10.0.0.5:40000"
+		req.Header.Set("X-Forwarded-For", spoof+", 203.0.113.7")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		codes = append(codes, rec.Code)
+	}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusOK || codes[2] != http.StatusTooManyRequests {
+		t.Fatalf("codes = %v, want 200, 200, 429", codes)
+	}
+}
+
+func TestTrustedProxiesFromEnv(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "")
+	if n, err := trustedProxiesFromEnv(); n != 1 || err != nil {
+		t.Fatalf("unset = %d, %v; want 1", n, err)
+	}
+	t.Setenv("TRUSTED_PROXIES", "-1")
+	if _, err := trustedProxiesFromEnv(); err == nil {
+		t.Fatal("TRUSTED_PROXIES=-1 accepted")
+	}
+}
+
+func TestRateLimitServiceNeedsToken(t *testing.T) {
+	t.Setenv("RATE_LIMIT_SERVE", "true")
+	t.Setenv("RATE_LIMIT_TOKEN", "")
+	if _, err := rateLimitServiceFromEnv(ratelimit.NewMemory()); err == nil {
+		t.Fatal("RATE_LIMIT_SERVE without RATE_LIMIT_TOKEN accepted")
+	}
+
+	t.Setenv("RATE_LIMIT_TOKEN", "s3cret")
+	h, err := rateLimitServiceFromEnv(ratelimit.NewMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	l := ratelimit.Limit{Rate: 1, Burst: 1}
+
+	// A caller without the token can't touch anyone's bucket.
+	if _, err := (&ratelimit.Remote{URL: srv.URL}).Take(t.Context(), "victim", l); err == nil {
+		t.Fatal("Take without the token succeeded")
+	}
+	d, err := (&ratelimit.Remote{URL: srv.URL, Token: "s3cret"}).Take(t.Context(), "victim", l)
+	if err != nil || !d.Allowed {
+		t.Fatalf("Take with the token = %+v, %v; want the victim's full bucket", d, err)
+	}
+}
@@ -0,0 +1,80 @@
+package retry
+
+import "sync"
+
+// Budget is a token bucket shared by every call to one dependency. Each
+// call deposits Ratio tokens and each retry spends a whole one, so over
+// time retries stay under Ratio of the calls made (e.g. 0.1 = 10%). The
+// bucket holds at most MaxTokens, which is also where it starts, so a
+// short burst of failures can still be retried after a quiet spell.
+type Budget struct {
+	ratio     float64
+	maxTokens float64
+
+	mu       sync.Mutex
+	tokens   float64
+	requests uint64
+	retries  uint64
+	denied   uint64
+}
+
+// NewBudget returns a full budget. ratio < 0 (unset) defaults to 0.1 and
+// maxTokens <= 0 to 10. A ratio of 0 starts empty and never refills, so it
+// allows no retries at all.
+func NewBudget(ratio, maxTokens float64) *Budget {
+	if ratio < 0 {
+		ratio = 0.1
+	}
+	if maxTokens <= 0 {
+		maxTokens = 10
+	}
+	b := &Budget{ratio: ratio, maxTokens: maxTokens, tokens: maxTokens}
+	if ratio == 0 {
+		b.tokens = 0
+	}
+	return b
+}
+
+// Request records one call (not counting its retries).
+func (b *Budget) Request() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.requests++
+	b.tokens = min(b.tokens+b.ratio, b.maxTokens)
+}
+
+// Withdraw takes a token for a retry, or reports false if there isn't one.
+func (b *Budget) Withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		b.denied++
+		return false
+	}
+	b.tokens--
+	b.retries++
+	return true
+}
+
+// BudgetSnapshot is a point-in-time view for metrics and debug endpoints.
+type BudgetSnapshot struct {
+	Tokens    float64 `json:"tokens"`
+	MaxTokens float64 `json:"max_tokens"`
+	Ratio     float64 `json:"ratio"`
+	Requests  uint64  `json:"requests"`
+	Retries   uint64  `json:"retries"`
+	Denied    uint64  `json:"denied"`
+}
+
+func (b *Budget) Snapshot() BudgetSnapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return BudgetSnapshot{
+		Tokens:    b.tokens,
+		MaxTokens: b.maxTokens,
+		Ratio:     b.ratio,
+		Requests:  b.requests,
+		Retries:   b.retries,
+		Denied:    b.denied,
+	}
+}
@@ -0,0 +1,171 @@
+// Package retry retries failed downstream calls with capped exponential
+// backoff and full jitter. Only errors marked retryable (429/503 from the
+// dependency) are retried, a server's Retry-After is honoured, and a shared
+// Budget caps retries to a fraction of all calls so retrying can't multiply
+// the load on a dependency that is already failing.
+package retry
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrBudgetExhausted is wrapped around the last error when a retry was
+// wanted but the budget had no tokens left.
+var ErrBudgetExhausted = errors.New("retry budget exhausted")
+
+// RetryableError marks an error as worth another attempt. After is the
+// server's Retry-After hint, 0 if it sent none.
+type RetryableError struct {
+	Err   error
+	After time.Duration
+}
+
+func (e *RetryableError) Error() string { return e.Err.Error() }
+func (e *RetryableError) Unwrap() error { return e.Err }
+
+// Retryable wraps err so Do will try again, waiting at least after.
+func Retryable(err error, after time.Duration) error {
+	return &RetryableError{Err: err, After: after}
+}
+
+// RetryableStatus reports whether an HTTP status means "try again later":
+// 429 Too Many Requests and 503 Service Unavailable.
+func RetryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable
+}
+
+// RetryAfter parses a Retry-After header (delay-seconds or an HTTP date).
+// It returns 0 for a missing or malformed header.
+func RetryAfter(h http.Header, now time.Time) time.Duration {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// Policy says how often and how patiently to retry. Zero fields take the
+// defaults noted.
+type Policy struct {
+	// MaxAttempts counts the first try. Default 3; 1 disables retries.
+	MaxAttempts int
+	// BaseDelay is the backoff cap for the first retry, doubled for each
+	// one after it up to MaxDelay. Defaults 10ms and 100ms.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// MaxRetryAfter is the longest Retry-After worth waiting for; a server
+	// asking for more ends the retries. Default 1s, the shortest wait a
+	// Retry-After in whole seconds can ask for.
+	MaxRetryAfter time.Duration
+	// Budget, if set, is charged one token per retry.
+	Budget *Budget
+
+	// Rand returns a value in [0, 1) for the jitter. Default math/rand.
+	Rand func() float64
+	// Sleep waits d or until ctx is done. Tests replace it.
+	Sleep func(ctx context.Context, d time.Duration) error
+}
+
+func (p Policy) withDefaults() Policy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 3
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = 10 * time.Millisecond
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = 100 * time.Millisecond
+	}
+	if p.MaxDelay < p.BaseDelay {
+		p.MaxDelay = p.BaseDelay
+	}
+	if p.MaxRetryAfter <= 0 {
+		p.MaxRetryAfter = time.Second
+	}
+	if p.Rand == nil {
+		p.Rand = rand.Float64
+	}
+	if p.Sleep == nil {
+		p.Sleep = sleep
+	}
+	return p
+}
+
+// Backoff is the full-jitter delay before retry number n (1-based): a
+// uniform pick in [0, min(MaxDelay, BaseDelay*2^(n-1))). n < 1 counts as 1.
+func (p Policy) Backoff(n int) time.Duration {
+	p = p.withDefaults()
+	n = max(n, 1)
+	ceiling := p.MaxDelay
+	if n-1 < 32 {
+		if d := p.BaseDelay << (n - 1); d > 0 && d < ceiling {
+			ceiling = d
+		}
+	}
+	return time.Duration(p.Rand() * float64(ceiling))
+}
+
+// Do calls fn until it succeeds, returns an error that isn't retryable,
+// runs out of attempts or budget, or ctx is done. fn gets the 1-based
+// attempt number. Do returns the number of attempts made and fn's last
+// error.
+//
+// A Retry-After longer than MaxRetryAfter, or one that would outlast ctx's
+// deadline, ends the retries: the server has asked for more patience than
+// this caller has.
+func (p Policy) Do(ctx context.Context, fn func(attempt int) error) (int, error) {
+	p = p.withDefaults()
+	if p.Budget != nil {
+		p.Budget.Request()
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := fn(attempt)
+		if err == nil {
+			return attempt, nil
+		}
+		var re *RetryableError
+		if !errors.As(err, &re) || attempt >= p.MaxAttempts || re.After > p.MaxRetryAfter {
+			return attempt, err
+		}
+		delay := max(p.Backoff(attempt), re.After)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return attempt, err
+		}
+		if p.Budget != nil && !p.Budget.Withdraw() {
+			return attempt, errors.Join(ErrBudgetExhausted, err)
+		}
+
+		if sleepErr := p.Sleep(ctx, delay); sleepErr != nil {
+			return attempt, err
+		}
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
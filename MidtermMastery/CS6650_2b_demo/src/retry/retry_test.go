@@ -0,0 +1,196 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+var errUnavailable = errors.New("503")
+
+// recordSleeps returns a Policy.Sleep that records delays instead of waiting.
+func recordSleeps(got *[]time.Duration) func(context.Context, time.Duration) error {
+	return func(ctx context.Context, d time.Duration) error {
+		*got = append(*got, d)
+		return ctx.Err()
+	}
+}
+
+func TestRetriesRetryableUntilSuccess(t *testing.T) {
+	var sleeps []time.Duration
+	p := Policy{MaxAttempts: 3, Sleep: recordSleeps(&sleeps)}
+
+	attempts, err := p.Do(context.Background(), func(n int) error {
+		if n < 3 {
+			return Retryable(errUnavailable, 0)
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Fatalf("Do = %d, %v; want 3, nil", attempts, err)
+	}
+	if len(sleeps) != 2 {
+		t.Fatalf("slept %d times, want 2", len(sleeps))
+	}
+}
+
+func TestDoesNotRetryPlainErrors(t *testing.T) {
+	p := Policy{MaxAttempts: 5, Sleep: recordSleeps(new([]time.Duration))}
+	calls := 0
+	attempts, err := p.Do(context.Background(), func(int) error {
+		calls++
+		return errUnavailable
+	})
+	if calls != 1 || attempts != 1 || err != errUnavailable {
+		t.Fatalf("calls = %d, Do = %d, %v; want a single attempt", calls, attempts, err)
+	}
+}
+
+func TestStopsAtMaxAttempts(t *testing.T) {
+	p := Policy{MaxAttempts: 4, Sleep: recordSleeps(new([]time.Duration))}
+	attempts, err := p.Do(context.Background(), func(int) error {
+		return Retryable(errUnavailable, 0)
+	})
+	if attempts != 4 || !errors.Is(err, errUnavailable) {
+		t.Fatalf("Do = %d, %v; want 4, 503", attempts, err)
+	}
+}
+
+func TestBackoffIsFullJitterUnderCap(t *testing.T) {
+	p := Policy{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond, Rand: func() float64 { return 0.999 }}
+	for n, wantMax := range map[int]time.Duration{1: 10, 2: 20, 3: 40, 4: 50, 40: 50} {
+		got := p.Backoff(n)
+		if got >= wantMax*time.Millisecond || got < (wantMax-1)*time.Millisecond {
+			t.Fatalf("Backoff(%d) = %v, want just under %dms", n, got, wantMax)
+		}
+	}
+	p.Rand = func() float64 { return 0 }
+	if got := p.Backoff(3); got != 0 {
+		t.Fatalf("Backoff with rand 0 = %v, want 0", got)
+	}
+	p.Rand = func() float64 { return 0.999 }
+	for _, n := range []int{0, -5} {
+		if got := p.Backoff(n); got >= 10*time.Millisecond {
+			t.Fatalf("Backoff(%d) = %v, want under the first retry's 10ms", n, got)
+		}
+	}
+}
+
+func TestHonoursRetryAfter(t *testing.T) {
+	var sleeps []time.Duration
+	p := Policy{MaxDelay: 200 * time.Millisecond, Rand: func() float64 { return 0 }, Sleep: recordSleeps(&sleeps)}
+	_, _ = p.Do(context.Background(), func(n int) error {
+		if n == 1 {
+			return Retryable(errUnavailable, 150*time.Millisecond)
+		}
+		return nil
+	})
+	if len(sleeps) != 1 || sleeps[0] != 150*time.Millisecond {
+		t.Fatalf("sleeps = %v, want [150ms]", sleeps)
+	}
+
+	// The whole-second Retry-After this service's own 429s send is waited
+	// for, even though it is longer than MaxDelay.
+	sleeps = nil
+	attempts, _ := p.Do(context.Background(), func(n int) error {
+		if n == 1 {
+			return Retryable(errUnavailable, time.Second)
+		}
+		return nil
+	})
+	if attempts != 2 || len(sleeps) != 1 || sleeps[0] != time.Second {
+		t.Fatalf("attempts = %d, sleeps = %v with a 1s Retry-After, want 2, [1s]", attempts, sleeps)
+	}
+
+	// A Retry-After beyond MaxRetryAfter means give up now rather than wait.
+	attempts, _ = p.Do(context.Background(), func(int) error {
+		return Retryable(errUnavailable, 5*time.Second)
+	})
+	if attempts != 1 {
+		t.Fatalf("attempts = %d with a 5s Retry-After, want 1", attempts)
+	}
+
+	// So does one that would outlast the caller's deadline.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	attempts, _ = p.Do(ctx, func(int) error {
+		return Retryable(errUnavailable, time.Second)
+	})
+	if attempts != 1 {
+		t.Fatalf("attempts = %d with a 1s Retry-After and 100ms left, want 1", attempts)
+	}
+}
+
+func TestStopsWhenContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	attempts, err := Policy{MaxAttempts: 5}.Do(ctx, func(int) error {
+		return Retryable(errUnavailable, 0)
+	})
+	if attempts != 1 || !errors.Is(err, errUnavailable) {
+		t.Fatalf("Do = %d, %v; want 1, 503", attempts, err)
+	}
+}
+
+func TestBudgetCapsRetries(t *testing.T) {
+	b := NewBudget(0.1, 2)
+	p := Policy{MaxAttempts: 3, Budget: b, Sleep: recordSleeps(new([]time.Duration))}
+	fail := func(int) error { return Retryable(errUnavailable, 0) }
+
+	// The full bucket (2 tokens) pays for one call's two retries.
+	if attempts, _ := p.Do(context.Background(), fail); attempts != 3 {
+		t.Fatalf("first call attempts = %d, want 3", attempts)
+	}
+	// Now the bucket is near empty: no retry until ~10 more calls refill it.
+	attempts, err := p.Do(context.Background(), fail)
+	if attempts != 1 || !errors.Is(err, ErrBudgetExhausted) || !errors.Is(err, errUnavailable) {
+		t.Fatalf("second call = %d, %v; want 1 attempt, budget exhausted", attempts, err)
+	}
+	for i := 0; i < 10; i++ {
+		b.Request()
+	}
+	if !b.Withdraw() {
+		t.Fatal("Withdraw after 10 requests at ratio 0.1 = false, want true")
+	}
+
+	s := b.Snapshot()
+	if s.Requests != 12 || s.Retries != 3 || s.Denied != 1 {
+		t.Fatalf("snapshot = %+v, want 12 requests, 3 retries, 1 denied", s)
+	}
+}
+
+func TestZeroRatioBudgetAllowsNoRetries(t *testing.T) {
+	p := Policy{MaxAttempts: 3, Budget: NewBudget(0, 0), Sleep: recordSleeps(new([]time.Duration))}
+	attempts, err := p.Do(context.Background(), func(int) error { return Retryable(errUnavailable, 0) })
+	if attempts != 1 || !errors.Is(err, ErrBudgetExhausted) {
+		t.Fatalf("Do = %d, %v; want 1 attempt, budget exhausted", attempts, err)
+	}
+	if s := NewBudget(-1, 0).Snapshot(); s.Ratio != 0.1 || s.Tokens != 10 {
+		t.Fatalf("unset budget = %+v, want ratio 0.1 and 10 tokens", s)
+	}
+}
+
+func TestRetryAfterHeader(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		v    string
+		want time.Duration
+	}{
+		{"", 0},
+		{"2", 2 * time.Second},
+		{"-1", 0},
+		{"soon", 0},
+		{now.Add(3 * time.Second).Format(http.TimeFormat), 3 * time.Second},
+		{now.Add(-time.Hour).Format(http.TimeFormat), 0},
+	} {
+		h := http.Header{}
+		if tc.v != "" {
+			h.Set("Retry-After", tc.v)
+		}
+		if got := RetryAfter(h, now); got != tc.want {
+			t.Fatalf("RetryAfter(%q) = %v, want %v", tc.v, got, tc.want)
+		}
+	}
+}
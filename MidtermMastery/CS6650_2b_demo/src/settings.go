@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"text/main/breaker"
+	"text/main/config"
+	"text/main/flags"
+	"text/main/limiter"
+)
+
+// ---------- Runtime settings ----------
+// The resilience knobs that are worth turning during a load test live here
+// rather than in fixed globals, so /admin/config can change them while
+// Locust is running instead of redeploying the task.
+
+// runtimeSettings is what /admin/config shows and accepts. Durations are
+// strings like "250ms".
+type runtimeSettings struct {
+	Breaker           breakerSettings `json:"breaker"`
+	Limiter           limiterSettings `json:"limiter"`
+	DownstreamTimeout config.Duration `json:"downstream_timeout"` // per downstream call
+	RequestTimeout    config.Duration `json:"request_timeout"`    // server default, 0 = none
+}
+
+type breakerSettings struct {
+	ConsecutiveFailures int             `json:"consecutive_failures"` // negative disables
+	FailureRate         float64         `json:"failure_rate"`         // 0 disables
+	MinRequests         int             `json:"min_requests"`
+	Window              config.Duration `json:"window"`
+	OpenTimeout         config.Duration `json:"open_timeout"`
+	HalfOpenProbes      int             `json:"half_open_probes"`
+	SuccessesToClose    int             `json:"successes_to_close"`
+}
+
+type limiterSettings struct {
+	Algorithm string          `json:"algorithm"` // aimd or gradient
+	Initial   int             `json:"initial"`
+	Min       int             `json:"min"`
+	Max       int             `json:"max"`
+	Queue     int             `json:"queue"`
+	QueueWait config.Duration `json:"queue_wait"`
+	SlowRTT   config.Duration `json:"slow_rtt"` // aimd only
+}
+
+// defaultRuntimeSettings spells out the package defaults, so what
+// /admin/config reports is what is actually in force.
+func defaultRuntimeSettings() runtimeSettings {
+	return runtimeSettings{
+		Breaker: breakerSettings{
+			ConsecutiveFailures: 10,
+			MinRequests:         20,
+			Window:              config.Duration(10 * time.Second),
+			OpenTimeout:         config.Duration(10 * time.Second),
+			HalfOpenProbes:      1,
+			SuccessesToClose:    5,
+		},
+		Limiter: limiterSettings{
+			Algorithm: "aimd",
+			Initial:   30,
+			Min:       1,
+			Max:       200,
+			QueueWait: config.Duration(10 * time.Millisecond),
+			SlowRTT:   config.Duration(100 * time.Millisecond),
+		},
+		DownstreamTimeout: config.Duration(120 * time.Millisecond),
+	}
+}
+
+func (s runtimeSettings) validate() error {
+	if err := s.Breaker.validate(); err != nil {
+		return err
+	}
+	if err := s.Limiter.validate(); err != nil {
+		return err
+	}
+	if s.DownstreamTimeout <= 0 {
+		return fmt.Errorf("downstream_timeout must be positive, got %v", time.Duration(s.DownstreamTimeout))
+	}
+	if s.RequestTimeout < 0 {
+		return fmt.Errorf("request_timeout must not be negative, got %v", time.Duration(s.RequestTimeout))
+	}
+	return nil
+}
+
+func (s breakerSettings) validate() error {
+	switch {
+	case s.FailureRate < 0 || s.FailureRate > 1:
+		return fmt.Errorf("breaker failure_rate must be between 0 and 1, got %v", s.FailureRate)
+	case s.MinRequests < 0 || s.HalfOpenProbes < 0 || s.SuccessesToClose < 0:
+		return fmt.Errorf("breaker min_requests, half_open_probes and successes_to_close must not be negative")
+	case s.Window < 0 || s.OpenTimeout < 0:
+		return fmt.Errorf("breaker window and open_timeout must not be negative")
+	}
+	return nil
+}
+
+func (s breakerSettings) config() breaker.Config {
+	return breaker.Config{
+		ConsecutiveFailures: s.ConsecutiveFailures,
+		FailureRate:         s.FailureRate,
+		MinRequests:         s.MinRequests,
+		Window:              time.Duration(s.Window),
+		OpenTimeout:         time.Duration(s.OpenTimeout),
+		HalfOpenMaxProbes:   s.HalfOpenProbes,
+		SuccessesToClose:    s.SuccessesToClose,
+		OnStateChange: func(from, to breaker.State) {
+			log.Printf("downstream breaker %s -> %s", from, to)
+		},
+	}
+}
+
+func (s limiterSettings) validate() error {
+	switch {
+	case s.Algorithm != "aimd" && s.Algorithm != "gradient":
+		return fmt.Errorf("limiter algorithm must be aimd or gradient, got %q", s.Algorithm)
+	case s.Initial < 0 || s.Min < 0 || s.Max < 0 || s.Queue < 0:
+		return fmt.Errorf("limiter initial, min, max and queue must not be negative")
+	case s.Min > 0 && s.Max > 0 && s.Min > s.Max:
+		return fmt.Errorf("limiter min (%d) is above max (%d)", s.Min, s.Max)
+	case s.QueueWait < 0 || s.SlowRTT < 0:
+		return fmt.Errorf("limiter queue_wait and slow_rtt must not be negative")
+	}
+	return nil
+}
+
+func (s limiterSettings) config() limiter.Config {
+	cfg := limiter.Config{MaxQueue: s.Queue, MaxWait: time.Duration(s.QueueWait)}
+	if s.Algorithm == "gradient" {
+		cfg.Algorithm = limiter.NewGradient(limiter.GradientConfig{Initial: s.Initial, Min: s.Min, Max: s.Max})
+	} else {
+		cfg.Algorithm = limiter.NewAIMD(limiter.AIMDConfig{Initial: s.Initial, Min: s.Min, Max: s.Max, SlowRTT: time.Duration(s.SlowRTT)})
+	}
+	return cfg
+}
+
+// downstreamTimeoutFromEnv reads DOWNSTREAM_TIMEOUT (default 120ms).
+func downstreamTimeoutFromEnv() (config.Duration, error) {
+	v := os.Getenv("DOWNSTREAM_TIMEOUT")
+	if v == "" {
+		return defaultRuntimeSettings().DownstreamTimeout, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("DOWNSTREAM_TIMEOUT must be a positive duration, got %q", v)
+	}
+	return config.Duration(d), nil
+}
+
+// runtimeState is the settings together with the breaker and limiter built
+// from them. It is swapped as a whole, so a downstream call sees one
+// consistent set.
+type runtimeState struct {
+	settings runtimeSettings
+	breaker  *breaker.Breaker
+	limiter  *limiter.Limiter
+}
+
+var (
+	live   atomic.Pointer[runtimeState]
+	liveMu sync.Mutex // serializes read-modify-write of the settings
+)
+
+func init() {
+	s := defaultRuntimeSettings()
+	live.Store(&runtimeState{
+		settings: s,
+		breaker:  breaker.New(s.Breaker.config()),
+		limiter:  limiter.New(s.Limiter.config()),
+	})
+}
+
+func liveRuntime() *runtimeState { return live.Load() }
+
+// setRuntimeSettings makes s live. The breaker and limiter are rebuilt only
+// when their own settings changed, and a rebuilt one starts fresh: the
+// breaker closed, the limiter at its initial limit. Calls already holding a
+// permit or token finish against the old ones.
+func setRuntimeSettings(s runtimeSettings) error {
+	liveMu.Lock()
+	defer liveMu.Unlock()
+	return setRuntimeSettingsLocked(s)
+}
+
+func setRuntimeSettingsLocked(s runtimeSettings) error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+	old := live.Load()
+	next := &runtimeState{settings: s, breaker: old.breaker, limiter: old.limiter}
+	if s.Breaker != old.settings.Breaker {
+		next.breaker = breaker.New(s.Breaker.config())
+	}
+	if s.Limiter != old.settings.Limiter {
+		next.limiter = limiter.New(s.Limiter.config())
+	}
+	live.Store(next)
+	return nil
+}
+
+// ---------- Feature flags ----------
+// featureFlags holds percentage rollouts. search_fixed picks the search
+// handler: 0 serves everyone searchHandler_BAD, 100 everyone
+// searchHandler_FIXED, anything between splits requests by rollout key.
+var featureFlags = flags.New()
+
+const searchFixedFlag = "search_fixed"
+
+// flagsFromEnv starts search_fixed from MODE (bad or fixed, default bad),
+// then applies FLAGS, a JSON object of flag name to percent such as
+// {"search_fixed": 25}.
+func flagsFromEnv(fs *flags.Set) error {
+	pct, err := modePercent(strings.TrimSpace(os.Getenv("MODE")))
+	if err != nil {
+		return fmt.Errorf("MODE: %v", err)
+	}
+	if err := fs.SetPercent(searchFixedFlag, pct); err != nil {
+		return err
+	}
+	v := os.Getenv("FLAGS")
+	if v == "" {
+		return nil
+	}
+	var pcts map[string]float64
+	if err := json.Unmarshal([]byte(v), &pcts); err != nil {
+		return fmt.Errorf("FLAGS must be a JSON object of flag percentages: %v", err)
+	}
+	return fs.Update(pcts)
+}
+
+func modePercent(mode string) (float64, error) {
+	switch strings.ToLower(mode) {
+	case "", "bad":
+		return 0, nil
+	case "fixed":
+		return 100, nil
+	}
+	return 0, fmt.Errorf("mode must be bad or fixed, got %q", mode)
+}
+
+// searchMode names the search handler in use: bad, fixed, or rollout while
+// search_fixed is partway.
+func searchMode() string { return modeOf(featureFlags.Percent(searchFixedFlag)) }
+
+func modeOf(pct float64) string {
+	switch {
+	case pct <= 0:
+		return "bad"
+	case pct >= 100:
+		return "fixed"
+	}
+	return "rollout"
+}
+
+// rolloutKey is what a request is bucketed by: X-Rollout-Key if the client
+// pins one, else its API key, else its IP (see clientIP). The connection's
+// address won't do: its port changes with every connection, and behind the
+// ALB it is the load balancer's. Locust users on one machine share an IP,
+// so to spread them across the buckets give each its own X-Rollout-Key.
+func rolloutKey(r *http.Request) string {
+	if k := r.Header.Get("X-Rollout-Key"); k != "" {
+		return k
+	}
+	if k := r.Header.Get("X-API-Key"); k != "" {
+		return k
+	}
+	return clientIP(r)
+}
+
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	if featureFlags.Enabled(searchFixedFlag, rolloutKey(r)) {
+		searchHandler_FIXED(w, r)
+		return
+	}
+	searchHandler_BAD(w, r)
+}
+
+// ---------- Admin: live configuration ----------
+
+// configView is the live configuration, as GET /admin/config and /version
+// report it and PATCH /admin/config takes it.
+type configView struct {
+	Mode  string             `json:"mode"`
+	Flags map[string]float64 `json:"flags"`
+	runtimeSettings
+}
+
+func currentConfig() configView {
+	return configView{
+		Mode:            searchMode(),
+		Flags:           featureFlags.All(),
+		runtimeSettings: liveRuntime().settings,
+	}
+}
+
+// configAdminHandler serves /admin/config:
+//
+//	GET    the live configuration
+//	PATCH  change the fields present in the JSON body; the rest stay as
+//	       they are. "mode": "bad" or "fixed" is shorthand for setting
+//	       search_fixed to 0 or 100, and "flags" sets the flags it names.
+//	       A mode sent with flags.search_fixed must agree with it, so what
+//	       GET returns can be sent back as is.
+//
+// A PATCH is applied whole or not at all.
+func configAdminHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPatch:
+		body, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := patchConfig(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, PATCH")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, currentConfig())
+}
+
+func patchConfig(body []byte) error {
+	liveMu.Lock()
+	defer liveMu.Unlock()
+
+	// Decoding onto the current settings leaves out fields untouched.
+	patch := configView{runtimeSettings: live.Load().settings}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&patch); err != nil {
+		return fmt.Errorf("invalid JSON: %v", err)
+	}
+
+	pcts := patch.Flags
+	if mode := strings.ToLower(patch.Mode); mode != "" {
+		if pct, ok := pcts[searchFixedFlag]; ok {
+			if modeOf(pct) != mode {
+				return fmt.Errorf("mode %q disagrees with flags.%s = %g", patch.Mode, searchFixedFlag, pct)
+			}
+		} else if mode == "rollout" {
+			// A bare rollout names no percentage, so it can only mean "as is".
+			if searchMode() != "rollout" {
+				return fmt.Errorf("mode rollout needs flags.%s between 0 and 100", searchFixedFlag)
+			}
+		} else {
+			pct, err := modePercent(mode)
+			if err != nil {
+				return err
+			}
+			pcts = map[string]float64{searchFixedFlag: pct}
+			for name, p := range patch.Flags {
+				pcts[name] = p
+			}
+		}
+	}
+	// Validate the flags before touching the settings, so a bad flag
+	// doesn't leave the settings half applied.
+	check := flags.New()
+	if err := check.Update(pcts); err != nil {
+		return err
+	}
+	if err := setRuntimeSettingsLocked(patch.runtimeSettings); err != nil {
+		return err
+	}
+	if len(pcts) > 0 {
+		_ = featureFlags.Update(pcts)
+	}
+	log.Printf("config updated: mode %s, flags %v", searchMode(), featureFlags.All())
+	return nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"text/main/config"
+	"text/main/flags"
+)
+
+// withFreshConfig restores the live settings and flags after the test.
+func withFreshConfig(t *testing.T) {
+	t.Helper()
+	old, oldFlags := live.Load(), featureFlags
+	featureFlags = flags.New()
+	t.Cleanup(func() {
+		live.Store(old)
+		featureFlags = oldFlags
+	})
+}
+
+func patchAdminConfig(t *testing.T, body string) (*httptest.ResponseRecorder, configView) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPatch, "/admin/config", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	configAdminHandler(rec, req)
+	var view configView
+	if rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(&view); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec, view
+}
+
+func TestPatchConfigChangesOnlyWhatIsSent(t *testing.T) {
+	withFreshConfig(t)
+	before := liveRuntime()
+
+	rec, view := patchAdminConfig(t, `{"breaker":{"consecutive_failures":3},"downstream_timeout":"250ms"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	want := before.settings
+	want.Breaker.ConsecutiveFailures = 3
+	want.DownstreamTimeout = config.Duration(250 * time.Millisecond)
+	if view.runtimeSettings != want {
+		t.Fatalf("settings = %+v, want %+v", view.runtimeSettings, want)
+	}
+
+	after := liveRuntime()
+	if after.breaker == before.breaker {
+		t.Fatal("breaker settings changed but the breaker was not rebuilt")
+	}
+	if after.limiter != before.limiter {
+		t.Fatal("limiter rebuilt although its settings did not change")
+	}
+}
+
+func TestPatchConfigRejectsAtomically(t *testing.T) {
+	withFreshConfig(t)
+	before := liveRuntime()
+
+	for _, body := range []string{
+		`{"limiter":{"algorithm":"vegas"}}`,
+		`{"breaker":{"failure_rate":2}}`,
+		`{"downstream_timeout":"0s"}`,
+		`{"mode":"sideways"}`,
+		`{"mode":"fixed","flags":{"search_fixed":50}}`,
+		`{"mode":"rollout"}`,
+		`{"request_timeout":"1s","flags":{"search_fixed":150}}`,
+		`{"no_such_setting":1}`,
+	} {
+		rec, _ := patchAdminConfig(t, body)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status %d, want 400", body, rec.Code)
+		}
+		if liveRuntime() != before || featureFlags.Percent(searchFixedFlag) != 0 {
+			t.Fatalf("%s: rejected patch changed the live config", body)
+		}
+	}
+}
+
+func TestGetConfigPatchesBackUnchanged(t *testing.T) {
+	withFreshConfig(t)
+	for _, setup := range []string{`{"mode":"fixed"}`, `{"flags":{"search_fixed":30}}`, `{"mode":"bad"}`} {
+		if rec, _ := patchAdminConfig(t, setup); rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", setup, rec.Code)
+		}
+		rec := httptest.NewRecorder()
+		configAdminHandler(rec, httptest.NewRequest(http.MethodGet, "/admin/config", nil))
+		got := rec.Body.String()
+
+		rec, view := patchAdminConfig(t, got)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("after %s, PATCH of GET's body: status %d: %s", setup, rec.Code, rec.Body)
+		}
+		if back, _ := json.Marshal(view); strings.TrimSpace(string(back)) != strings.TrimSpace(got) {
+			t.Fatalf("after %s, round trip changed the config:\n%s\nto\n%s", setup, got, back)
+		}
+	}
+}
+
+func TestModeAndRolloutPickSearchHandler(t *testing.T) {
+	withFreshConfig(t)
+	fixedFor := func(key string) bool {
+		r := httptest.NewRequest(http.MethodGet, "/products/search", nil)
+		r.Header.Set("X-Rollout-Key", key)
+		return featureFlags.Enabled(searchFixedFlag, rolloutKey(r))
+	}
+
+	if _, view := patchAdminConfig(t, `{"mode":"fixed"}`); view.Mode != "fixed" || !fixedFor("anyone") {
+		t.Fatalf("mode fixed: view mode %q", view.Mode)
+	}
+
+	_, view := patchAdminConfig(t, `{"flags":{"search_fixed":30}}`)
+	if view.Mode != "rollout" || view.Flags[searchFixedFlag] != 30 {
+		t.Fatalf("rollout: view %+v", view)
+	}
+	fixed := 0
+	for i := 0; i < 1000; i++ {
+		key := "user-" + strconv.Itoa(i)
+		if fixedFor(key) {
+			fixed++
+		}
+		if fixedFor(key) != fixedFor(key) {
+			t.Fatalf("key %s flipped between requests", key)
+		}
+	}
+	if fixed < 250 || fixed > 350 {
+		t.Fatalf("%d of 1000 keys on FIXED at 30%%", fixed)
+	}
+}
+
+func TestRolloutKeyIgnoresConnectionPort(t *testing.T) {
+	key := func(remote, xff string) string {
+		r := httptest.NewRequest(http.MethodGet, "/products/search", nil)
+		r.RemoteAddr = remote
+		if xff != "" {
+			r.Header.Set("X-Forwarded-For", xff)
+		}
+		return rolloutKey(r)
+	}
+	if a, b := key("10.0.0.1:40001", ""), key("10.0.0.1:40002", ""); a != b {
+		t.Fatalf("same client on two connections keyed %q and %q", a, b)
+	}
+	// Behind the ALB every connection is the load balancer's; the client is
+	// the hop it appended.
+	if a, b := key("10.0.9.9:5000", "203.0.113.7"), key("10.0.9.9:5001", "203.0.113.7"); a != b || a != "203.0.113.7" {
+		t.Fatalf("same client through the ALB keyed %q and %q", a, b)
+	}
+	if a, b := key("10.0.9.9:5000", "203.0.113.7"), key("10.0.9.9:5000", "203.0.113.8"); a == b {
+		t.Fatalf("two clients through the ALB both keyed %q", a)
+	}
+}
+
+func TestVersionReportsLiveConfig(t *testing.T) {
+	withFreshConfig(t)
+	patchAdminConfig(t, `{"mode":"fixed","limiter":{"max":50}}`)
+
+	rec := httptest.NewRecorder()
+	versionHandler(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+	var got struct {
+		Build   string
+		Mode    string
+		Limiter limiterSettings
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Build == "" || got.Mode != "fixed" || got.Limiter.Max != 50 {
+		t.Fatalf("/version = %+v", got)
+	}
+}
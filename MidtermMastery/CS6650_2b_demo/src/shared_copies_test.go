@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The compression and streaming middleware, and the faults and config
+// packages, are copied into every service that uses them rather than
+// imported from one module. Each service's image is built with only its own
+// src directory as the Docker context (HW5's product API copies just *.go),
+// so a module outside it could not be fetched. The copy here is the
+// original; this test keeps the others from drifting.
+var sharedCopies = map[string][]string{
+	"compress.go": {"HW5/CS6650_2b_demo/src", "HW5/online-store-product-api/src", "HW6/CS6650_2b_demo/src"},
+	"stream.go":   {"HW5/CS6650_2b_demo/src", "HW6/CS6650_2b_demo/src"},
+
+	"faults/faults.go":      {"HW6/CS6650_2b_demo/src"},
+	"faults/admin.go":       {"HW6/CS6650_2b_demo/src"},
+	"faults/faults_test.go": {"HW6/CS6650_2b_demo/src"},
+
+	"config/duration.go":      {"HW6/CS6650_2b_demo/src"},
+	"config/duration_test.go": {"HW6/CS6650_2b_demo/src"},
+}
+
+// repoRoot is the course repository, three levels above this module.
+const repoRoot = "../../.."
+
+func TestSharedCopiesMatch(t *testing.T) {
+	for file, modules := range sharedCopies {
+		want, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range modules {
+			got, err := os.ReadFile(filepath.Join(repoRoot, m, file))
+			if os.IsNotExist(err) {
+				t.Skipf("%s not found: not in the course repository", m)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s/%s differs from this module's copy", m, file)
+			}
+		}
+	}
+}
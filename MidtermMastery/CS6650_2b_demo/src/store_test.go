@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestStoreWritesVisibleToSearch(t *testing.T) {
+	s := newProductStore()
+	for i := 1; i <= 5; i++ {
+		_, _ = s.add(Product{ID: i, Name: "Item", Category: "Books"})
+	}
+
+	if _, err := s.add(Product{Name: "Lamp Zebra", Category: "Home"}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if _, total, _ := s.search("zebra", 100, 20); total != 1 {
+		t.Fatalf("search after add found %d, want 1", total)
+	}
+
+	if err := s.update(Product{ID: 2, Name: "Quokka", Category: "Toys"}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if res, _, _ := s.search("quokka", 100, 20); len(res) != 1 || res[0].ID != 2 {
+		t.Fatalf("search after update = %+v, want product 2", res)
+	}
+
+	if err := s.remove(2); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if _, total, checked := s.search("quokka", 100, 20); total != 0 || checked != 5 {
+		t.Fatalf("search after remove total=%d checked=%d, want 0 and 5", total, checked)
+	}
+	if err := s.remove(2); err != errProductNotFound {
+		t.Fatalf("second remove err = %v, want %v", err, errProductNotFound)
+	}
+	if _, err := s.add(Product{ID: 3, Name: "Dup"}); err != errProductExists {
+		t.Fatalf("duplicate add err = %v, want %v", err, errProductExists)
+	}
+}
+
+func TestCreateProductHandler(t *testing.T) {
+	catalog = newProductStore()
+	defer func() { catalog = newProductStore() }()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /products", createProductHandler)
+	mux.HandleFunc("DELETE /products/{id}", deleteProductHandler)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/products", strings.NewReader(`{"name":"Desk Lamp","category":"Home"}`)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /products status = %d, want %d", w.Code, http.StatusCreated)
+	}
+	if _, total, _ := catalog.search("lamp", 100, 20); total != 1 {
+		t.Fatalf("search after POST found %d, want 1", total)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/products/1", nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE /products/1 status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+}
+
+// Run with -race: writers and searchers share the store concurrently.
+func TestStoreConcurrentWritesAndSearches(t *testing.T) {
+	s := newProductStore()
+	for i := 1; i <= 200; i++ {
+		_, _ = s.add(Product{ID: i, Name: "Seed", Category: "Books"})
+	}
+
+	var wg sync.WaitGroup
+	for wr := 0; wr < 4; wr++ {
+		wg.Add(1)
+		go func(wr int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				id := 1000 + wr*1000 + i
+				_, _ = s.add(Product{ID: id, Name: "Racer", Category: "Sports"})
+				_ = s.update(Product{ID: 1 + (wr*100+i)%200, Name: "Racer", Category: "Sports"})
+				if i%2 == 0 {
+					_ = s.remove(id)
+				}
+			}
+		}(wr)
+	}
+	for rd := 0; rd < 4; rd++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				res, total, checked := s.search("racer", 100, 20)
+				if checked != 100 || total > checked || len(res) > 20 || len(res) > total {
+					t.Errorf("inconsistent search: results=%d total=%d checked=%d", len(res), total, checked)
+					return
+				}
+				for _, p := range res {
+					if p.Name != "Racer" {
+						t.Errorf("search returned non-matching product %+v", p)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}